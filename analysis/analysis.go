@@ -0,0 +1,242 @@
+// Package analysis resolves the names of a program without running it:
+// which let, param or fn every identifier refers to, and which names are visible where.
+// The scopes are the ones evaluator.WalkScopes finds, so the answers agree with what a run would do.
+package analysis
+
+import (
+	"sort"
+
+	"xmonkey/ast"
+	"xmonkey/evaluator"
+	"xmonkey/token"
+)
+
+// SymbolKind says how a name got bound
+type SymbolKind string
+
+const (
+	LET      = SymbolKind(evaluator.LET_BINDING)
+	PARAM    = SymbolKind(evaluator.PARAM_BINDING)
+	FUNCTION = SymbolKind(evaluator.FUNCTION_BINDING)
+	CATCH    = SymbolKind(evaluator.CATCH_BINDING)
+	MATCH    = SymbolKind(evaluator.MATCH_BINDING)
+	BUILTIN  = SymbolKind("builtin")
+)
+
+// Symbol is one binding of a name
+type Symbol struct {
+	Name string
+	Kind SymbolKind
+	// the identifier which binds the name, nil for builtins
+	Decl *ast.Identifier
+	// what is bound when it is known: the value of `let x = value`, the literal of a fn;
+	// nil for params, patterns and builtins
+	Value ast.Expression
+	Scope *Scope
+	// the identifiers using this binding, in source order
+	Refs []*ast.Identifier
+}
+
+// Scope is an environment of the evaluator, as seen in the source
+type Scope struct {
+	Parent   *Scope
+	Children []*Scope
+	// symbols declared in this scope, in the order they get bound
+	Symbols []*Symbol
+	// where the scope starts and ends, End is zero for the program, which never ends
+	Start, End token.Token
+}
+
+// Info is the result of Resolve
+type Info struct {
+	// Universe holds the builtins, it is the parent of Program
+	Universe *Scope
+	Program  *Scope
+
+	// Symbols are all symbols except builtins, in source order
+	Symbols []*Symbol
+	// Defs maps each binding identifier to its symbol
+	Defs map[*ast.Identifier]*Symbol
+	// Uses maps each identifier used as a value to its symbol, nil when it is undefined
+	Uses map[*ast.Identifier]*Symbol
+	// Undefined are the used identifiers bound nowhere, in source order
+	Undefined []*ast.Identifier
+}
+
+// Resolve binds every identifier of program, in the scopes evaluator.WalkScopes finds with scoping.
+// A name refers to the latest binding before it, in its scope or the ones around it;
+// function bodies come after the code around them, so they see the names bound later outside.
+func Resolve(program *ast.Program, scoping evaluator.Scoping) *Info {
+	info := &Info{
+		Defs: map[*ast.Identifier]*Symbol{},
+		Uses: map[*ast.Identifier]*Symbol{},
+	}
+
+	info.Universe = &Scope{}
+	for _, name := range evaluator.BuiltinNames() {
+		info.Universe.Symbols = append(info.Universe.Symbols, &Symbol{Name: name, Kind: BUILTIN, Scope: info.Universe})
+	}
+
+	evaluator.WalkScopes(program, scoping, &resolver{info: info, scopes: map[*evaluator.Scope]*Scope{}})
+
+	sort.SliceStable(info.Symbols, func(i, j int) bool {
+		return before(info.Symbols[i].Decl.Token, info.Symbols[j].Decl.Token)
+	})
+	sort.SliceStable(info.Undefined, func(i, j int) bool {
+		return before(info.Undefined[i].Token, info.Undefined[j].Token)
+	})
+	for _, sym := range info.Symbols {
+		sort.SliceStable(sym.Refs, func(i, j int) bool {
+			return before(sym.Refs[i].Token, sym.Refs[j].Token)
+		})
+	}
+
+	return info
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// queries
+
+// IdentAt finds the identifier covering the position, binding or use, nil if there is none
+func (info *Info) IdentAt(line, column int) *ast.Identifier {
+	for ident := range info.Defs {
+		if covers(ident, line, column) {
+			return ident
+		}
+	}
+	for ident := range info.Uses {
+		if covers(ident, line, column) {
+			return ident
+		}
+	}
+	return nil
+}
+
+// SymbolAt is the symbol of the identifier at the position, nil if there is none or it is undefined
+func (info *Info) SymbolAt(line, column int) *Symbol {
+	ident := info.IdentAt(line, column)
+	if ident == nil {
+		return nil
+	}
+	if sym, ok := info.Defs[ident]; ok {
+		return sym
+	}
+	return info.Uses[ident]
+}
+
+// ScopeAt is the innermost scope containing the position
+func (info *Info) ScopeAt(line, column int) *Scope {
+	pos := token.Token{Line: line, Column: column}
+
+	scope := info.Program
+	for {
+		var inner *Scope
+		for _, child := range scope.Children {
+			if child.contains(pos) {
+				inner = child
+			}
+		}
+		if inner == nil {
+			return scope
+		}
+		scope = inner
+	}
+}
+
+// Visible lists the names visible at the position, the innermost binding of each name,
+// declared before the position or hoisted; the builtins come last.
+func (info *Info) Visible(line, column int) []*Symbol {
+	pos := token.Token{Line: line, Column: column}
+	seen := map[string]bool{}
+
+	var visible []*Symbol
+	for scope := info.ScopeAt(line, column); scope != nil; scope = scope.Parent {
+		for i := len(scope.Symbols) - 1; i >= 0; i-- {
+			sym := scope.Symbols[i]
+			if seen[sym.Name] {
+				continue
+			}
+			if sym.Decl != nil && sym.Kind != FUNCTION && !before(sym.Decl.Token, pos) {
+				continue
+			}
+			seen[sym.Name] = true
+			visible = append(visible, sym)
+		}
+	}
+
+	return visible
+}
+
+// Lookup finds the binding of name from this scope outwards, the latest one of each scope wins
+func (s *Scope) Lookup(name string) *Symbol {
+	for scope := s; scope != nil; scope = scope.Parent {
+		for i := len(scope.Symbols) - 1; i >= 0; i-- {
+			if scope.Symbols[i].Name == name {
+				return scope.Symbols[i]
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Scope) child(start, end token.Token) *Scope {
+	child := &Scope{Parent: s, Start: start, End: end}
+	s.Children = append(s.Children, child)
+	return child
+}
+
+func (s *Scope) contains(pos token.Token) bool {
+	if before(pos, s.Start) {
+		return false
+	}
+	return s.End.Line == 0 || !before(s.End, pos)
+}
+
+func before(a, b token.Token) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+}
+
+func covers(ident *ast.Identifier, line, column int) bool {
+	return ident.Token.Line == line && column >= ident.Token.Column && column < ident.Token.Column+len(ident.Name)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// resolver
+
+// resolver is the ScopeVisitor of Resolve
+type resolver struct {
+	info   *Info
+	scopes map[*evaluator.Scope]*Scope
+}
+
+func (r *resolver) Scope(s *evaluator.Scope) {
+	if s.Outer == nil {
+		r.info.Program = r.info.Universe.child(s.Start, s.End)
+		r.scopes[s] = r.info.Program
+		return
+	}
+	r.scopes[s] = r.scopes[s.Outer].child(s.Start, s.End)
+}
+
+func (r *resolver) Bind(s *evaluator.Scope, ident *ast.Identifier, kind evaluator.BindingKind, value ast.Expression) {
+	// _ is never used, let _ = f() is not a let nobody reads
+	if ident.Name == "_" {
+		return
+	}
+
+	scope := r.scopes[s]
+	sym := &Symbol{Name: ident.Name, Kind: SymbolKind(kind), Decl: ident, Value: value, Scope: scope}
+	scope.Symbols = append(scope.Symbols, sym)
+	r.info.Symbols = append(r.info.Symbols, sym)
+	r.info.Defs[ident] = sym
+}
+
+func (r *resolver) Use(s *evaluator.Scope, ident *ast.Identifier) {
+	sym := r.scopes[s].Lookup(ident.Name)
+	r.info.Uses[ident] = sym
+	if sym == nil {
+		r.info.Undefined = append(r.info.Undefined, ident)
+		return
+	}
+	sym.Refs = append(sym.Refs, ident)
+}
@@ -0,0 +1,305 @@
+package analysis
+
+import (
+	"strings"
+	"testing"
+
+	"xmonkey/ast"
+	"xmonkey/evaluator"
+	"xmonkey/lexer"
+	"xmonkey/object"
+	"xmonkey/parser"
+)
+
+func resolve(t *testing.T, input string) *Info {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	return Resolve(program, evaluator.Scoping{})
+}
+
+func isLetter(input string, i int) bool {
+	return i >= 0 && i < len(input) && ('a' <= input[i] && input[i] <= 'z' || 'A' <= input[i] && input[i] <= 'Z' || input[i] == '_')
+}
+
+// position of the n-th (from 0) occurrence of the word name in input, line and column count from 1
+func position(input, name string, n int) (int, int) {
+	line, column := 1, 1
+	for i := 0; i < len(input); i++ {
+		if strings.HasPrefix(input[i:], name) && !isLetter(input, i-1) && !isLetter(input, i+len(name)) {
+			if n == 0 {
+				return line, column
+			}
+			n--
+		}
+
+		if input[i] == '\n' {
+			line, column = line+1, 1
+		} else {
+			column++
+		}
+	}
+	return 0, 0
+}
+
+func TestResolveUses(t *testing.T) {
+	tests := []struct {
+		input string
+		// the n-th occurrence of name refers to the declaration at the decl-th occurrence, -1 for undefined
+		name      string
+		use, decl int
+	}{
+		{"let x = 1; x", "x", 1, 0},
+		{"let x = 1; let x = x + 1; x", "x", 2, 0},
+		{"let x = 1; let x = x + 1; x", "x", 3, 1},
+		{"let f = fn(x) { x }; let x = 2;", "x", 1, 0},
+		{"let f = fn() { x }; let x = 2;", "x", 0, 1},
+		{"let f = fn() { g() }; fn g() { 1 }", "g", 0, 1},
+		{"g(); fn g() { 1 }", "g", 0, 1},
+		{"let y = 1; if (true) { let y = 2; y }; y", "y", 2, 1},
+		{"let y = 1; if (true) { let y = 2; y }; y", "y", 3, 0},
+		{"y; let y = 1;", "y", 0, -1},
+		{"let [a, b] = [1, 2]; a + b", "b", 1, 0},
+		{"let {name} = {\"name\": 1}; name", "name", 2, 0},
+		{"match (1) { [h, ...t] => t, t => t }", "t", 1, 0},
+		{"match (1) { [h, ...t] => t, t => t }", "t", 3, 2},
+		{"try { 1 } catch (e) { e }", "e", 1, 0},
+		{"let r = fn f(n) { f(n) }; f", "f", 1, 0},
+		{"let r = fn f(n) { f(n) }; f", "f", 2, -1},
+		{"fn fact(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }", "fact", 1, 0},
+	}
+
+	for _, tt := range tests {
+		info := resolve(t, tt.input)
+
+		line, column := position(tt.input, tt.name, tt.use)
+		ident := info.IdentAt(line, column)
+		if ident == nil {
+			t.Errorf("%q: no identifier at the use %d of %s", tt.input, tt.use, tt.name)
+			continue
+		}
+
+		sym, ok := info.Uses[ident]
+		if !ok {
+			t.Errorf("%q: use %d of %s is not a use", tt.input, tt.use, tt.name)
+			continue
+		}
+
+		if tt.decl < 0 {
+			if sym != nil {
+				t.Errorf("%q: expect %s undefined, got %s declared at %d:%d", tt.input, tt.name, sym.Kind, sym.Decl.Token.Line, sym.Decl.Token.Column)
+			}
+			continue
+		}
+
+		declLine, declColumn := position(tt.input, tt.name, tt.decl)
+		if sym == nil || sym.Decl == nil || sym.Decl.Token.Line != declLine || sym.Decl.Token.Column != declColumn {
+			t.Errorf("%q: use %d of %s should refer to %d:%d, got %+v", tt.input, tt.use, tt.name, declLine, declColumn, sym)
+		}
+	}
+}
+
+func TestResolveBuiltinsAndUndefined(t *testing.T) {
+	input := "let a = len([1]); puts(a, b); let len = 2; c + len"
+	info := resolve(t, input)
+
+	line, column := position(input, "len", 0)
+	if sym := info.SymbolAt(line, column); sym == nil || sym.Kind != BUILTIN {
+		t.Errorf("expect the first len to be the builtin, got %+v", sym)
+	}
+
+	line, column = position(input, "len", 2)
+	if sym := info.SymbolAt(line, column); sym == nil || sym.Kind != LET {
+		t.Errorf("expect the last len to be the let, got %+v", sym)
+	}
+
+	var undefined []string
+	for _, ident := range info.Undefined {
+		undefined = append(undefined, ident.Name)
+	}
+	if len(undefined) != 2 || undefined[0] != "b" || undefined[1] != "c" {
+		t.Errorf("expect b and c undefined, got %v", undefined)
+	}
+}
+
+func TestResolveLeakBlockScope(t *testing.T) {
+	input := "if (c) { let x = 1; }; x"
+	program := parser.New(lexer.New(input)).ParseProgram()
+
+	line, column := position(input, "x", 1)
+	if sym := Resolve(program, evaluator.Scoping{}).SymbolAt(line, column); sym != nil {
+		t.Errorf("expect x undefined after the block, got %+v", sym)
+	}
+	if sym := Resolve(program, evaluator.Scoping{LeakBlockScope: true}).SymbolAt(line, column); sym == nil || sym.Kind != LET {
+		t.Errorf("expect x to be the let of the block with LeakBlockScope, got %+v", sym)
+	}
+}
+
+// evaluator.Resolve gives every name the slot of the binding Resolve finds for it,
+// Depth levels out from where it is used; a global, a builtin or an undefined name is looked up by name
+func TestResolveAgreesWithEvaluator(t *testing.T) {
+	inputs := []string{
+		"let g = 1; let f = fn(a, [b, c]) { let d = a; if (true) { let e = d; fn() { e + b + g } } }; f(1, [2, 3])",
+		"fn fact(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; let h = fn k(x) { k(x) + h(x) + fact(x) }",
+		`let r = try { let t = 1; t } catch (e) { let m = e["message"]; m + len(m) } finally { puts(r) }`,
+		`let v = 1; match ([1, 2]) { [x, ...rest] if x > v => x + len(rest), {"k": v} => v, [y, 2] => y + v }`,
+		"if (c) { let x = 1; x } else { let x = 2; fn w() { x } w() }; let y = 3; fn g() { y + z }",
+		"let q = fn(a) { let b = 2; quote(a + unquote(a + b)) }; { let s = 1; s }",
+	}
+
+	depth := func(from, to *Scope) int {
+		n := 0
+		for ; from != nil && from != to; from = from.Parent {
+			n++
+		}
+		return n
+	}
+
+	for _, leak := range []bool{false, true} {
+		scoping := evaluator.Scoping{LeakBlockScope: leak}
+
+		for _, input := range inputs {
+			program := parser.New(lexer.New(input)).ParseProgram()
+			info := Resolve(program, scoping)
+			evaluator.Resolve(program, scoping)
+
+			for ident, sym := range info.Uses {
+				used := info.ScopeAt(ident.Token.Line, ident.Token.Column)
+
+				wantDepth, wantSlot := depth(used, info.Program), 0
+				if sym != nil && sym.Kind != BUILTIN && sym.Scope != info.Program {
+					wantDepth, wantSlot = depth(used, sym.Scope), sym.Decl.Slot
+				}
+
+				if ident.Depth != wantDepth || ident.Slot != wantSlot {
+					t.Errorf("%q (leak=%v): %s at %d:%d has (depth, slot) (%d, %d), want (%d, %d)",
+						input, leak, ident.Name, ident.Token.Line, ident.Token.Column, ident.Depth, ident.Slot, wantDepth, wantSlot)
+				}
+			}
+		}
+	}
+}
+
+func TestResolveQuote(t *testing.T) {
+	input := "let m = macro(a) { quote(f(unquote(a), x)) }; m(y)"
+	info := resolve(t, input)
+
+	line, column := position(input, "a", 1)
+	if sym := info.SymbolAt(line, column); sym == nil || sym.Kind != PARAM {
+		t.Errorf("expect a in unquote to be the param, got %+v", sym)
+	}
+
+	line, column = position(input, "quote", 0)
+	if sym := info.SymbolAt(line, column); sym == nil || sym.Kind != BUILTIN {
+		t.Errorf("expect quote to be a builtin, got %+v", sym)
+	}
+
+	// f and x are only code in the quote, y is passed to the macro unevaluated, but it is still a name used here
+	var undefined []string
+	for _, ident := range info.Undefined {
+		undefined = append(undefined, ident.Name)
+	}
+	if len(undefined) != 1 || undefined[0] != "y" {
+		t.Errorf("expect only y undefined, got %v", undefined)
+	}
+}
+
+func TestResolveRefs(t *testing.T) {
+	input := `let total = 0;
+let add = fn(n) { total + n };
+add(total);
+total`
+	info := resolve(t, input)
+
+	sym := info.SymbolAt(1, 5)
+	if sym == nil || sym.Name != "total" || sym.Kind != LET {
+		t.Fatalf("expect total at 1:5, got %+v", sym)
+	}
+
+	// fn bodies are resolved late, refs are still in source order
+	want := [][2]int{{2, 19}, {3, 5}, {4, 1}}
+	if len(sym.Refs) != len(want) {
+		t.Fatalf("expect %d refs, got %d", len(want), len(sym.Refs))
+	}
+	for i, ref := range sym.Refs {
+		if ref.Token.Line != want[i][0] || ref.Token.Column != want[i][1] {
+			t.Errorf("ref %d: expect %v, got %d:%d", i, want[i], ref.Token.Line, ref.Token.Column)
+		}
+	}
+}
+
+func TestVisible(t *testing.T) {
+	input := `let a = 1;
+let f = fn(x) {
+    let y = 2;
+
+};
+let b = 3;
+`
+	info := resolve(t, input)
+
+	names := func(line, column int) map[string]SymbolKind {
+		visible := map[string]SymbolKind{}
+		for _, sym := range info.Visible(line, column) {
+			visible[sym.Name] = sym.Kind
+		}
+		return visible
+	}
+
+	inside := names(4, 5)
+	for name, kind := range map[string]SymbolKind{"a": LET, "f": LET, "x": PARAM, "y": LET, "len": BUILTIN} {
+		if inside[name] != kind {
+			t.Errorf("inside the fn: expect %s to be a visible %s, got %q", name, kind, inside[name])
+		}
+	}
+	if _, ok := inside["b"]; ok {
+		t.Errorf("inside the fn: b is declared after the position")
+	}
+
+	after := names(7, 1)
+	for _, name := range []string{"x", "y"} {
+		if _, ok := after[name]; ok {
+			t.Errorf("after the fn: %s should not be visible", name)
+		}
+	}
+	if after["b"] != LET {
+		t.Errorf("after the fn: b should be visible")
+	}
+}
+
+func TestTypeOf(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected object.ObjectType
+	}{
+		{"1", object.INTEGER_OBJ},
+		{"\"a\" + \"b\"", object.STRING_OBJ},
+		{"1 < 2", object.BOOLEAN_OBJ},
+		{"!x", object.BOOLEAN_OBJ},
+		{"[1, 2][1:]", object.ARRAY_OBJ},
+		{"{}", object.HASH_OBJ},
+		{"fn(a) { a }", object.FUNCTION_OBJ},
+		{"let n = 2; n * 3", object.INTEGER_OBJ},
+		{"let s = \"a\"; let t = s + s; t", object.STRING_OBJ},
+		{"true ? 1 : 2", object.INTEGER_OBJ},
+		{"true ? 1 : \"a\"", ""},
+		{"f(1)", ""},
+		{"let a = [1]; a[0]", ""},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		info := Resolve(program, evaluator.Scoping{})
+
+		last := program.Statements[len(program.Statements)-1].(*ast.ExpressionStatement)
+		if got := info.TypeOf(last.Expr); got != tt.expected {
+			t.Errorf("%q: expect %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package analysis
+
+import (
+	"xmonkey/ast"
+	"xmonkey/object"
+)
+
+// TypeOf guesses the object type expr evaluates to, without running it,
+// it is empty when it can not be told from the source.
+func (info *Info) TypeOf(expr ast.Expression) object.ObjectType {
+	return info.typeOf(expr, map[*Symbol]bool{})
+}
+
+func (info *Info) typeOf(expr ast.Expression, visiting map[*Symbol]bool) object.ObjectType {
+	switch expr := expr.(type) {
+	case *ast.IntegerLiteral:
+		return object.INTEGER_OBJ
+	case *ast.StringLiteral:
+		return object.STRING_OBJ
+	case *ast.Boolean:
+		return object.BOOLEAN_OBJ
+	case *ast.ArrayLiteral:
+		return object.ARRAY_OBJ
+	case *ast.HashLiteral:
+		return object.HASH_OBJ
+	case *ast.FunctionLiteral:
+		return object.FUNCTION_OBJ
+
+	case *ast.Identifier:
+		sym := info.Uses[expr]
+		if sym == nil || sym.Value == nil || visiting[sym] {
+			return ""
+		}
+		// let x = x + 1 refers to an older x, but a cycle is still possible through fn bodies
+		visiting[sym] = true
+		defer delete(visiting, sym)
+		return info.typeOf(sym.Value, visiting)
+
+	case *ast.PrefixExpression:
+		switch expr.Operator {
+		case "!":
+			return object.BOOLEAN_OBJ
+		case "-", "~":
+			return object.INTEGER_OBJ
+		}
+
+	case *ast.InfixExpression:
+		switch expr.Operator {
+		case "==", "!=", "<", ">", "<=", ">=":
+			return object.BOOLEAN_OBJ
+		case "+":
+			left, right := info.typeOf(expr.Left, visiting), info.typeOf(expr.Right, visiting)
+			if left == object.STRING_OBJ && right == object.STRING_OBJ {
+				return object.STRING_OBJ
+			}
+			if left == object.INTEGER_OBJ && right == object.INTEGER_OBJ {
+				return object.INTEGER_OBJ
+			}
+		case "-", "*", "/", "%", "**", "&", "|", "^", "<<", ">>":
+			return object.INTEGER_OBJ
+		}
+
+	case *ast.ConditionalExpression:
+		consequence := info.typeOf(expr.Consequence, visiting)
+		if consequence == info.typeOf(expr.Alternative, visiting) {
+			return consequence
+		}
+
+	case *ast.SliceExpression:
+		left := info.typeOf(expr.Left, visiting)
+		if left == object.ARRAY_OBJ || left == object.STRING_OBJ {
+			return left
+		}
+	}
+
+	return ""
+}
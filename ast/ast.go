@@ -79,6 +79,12 @@ func (p *Program) String() string {
 type BlockStatement struct {
 	Token      token.Token
 	Statements []Statement
+
+	// the closing }, the comments before it still belong to the block
+	Rbrace token.Token
+
+	// Slots are the names bound in the env of the block (of the call for a fn body), set by evaluator.Resolve
+	Slots []string `json:"-"`
 }
 
 func (r *BlockStatement) statementNode()       {}
@@ -104,11 +110,16 @@ func (r *BlockStatement) String() string {
 // a 类型为 object.Identifier，是 key，在 env 中对应的 Object 是 4，类型是 Integer；
 // b 类型为 object.Identifier, 是 key，在 env 中对应的 Object 是 eval(a + 4) 的值，
 // a+4 这个 infix 被 eval 之后的值是 8，也即：b 在 env 中对应的 Object 是 8，类型为 Integer
+//
+// let [a, ...rest] = arr; let {name} = person; 是解构，Name 为 nil，Pattern 是 = 左边的 ArrayPattern/HashPattern
+// let x: int = 1; Type is the optional annotation, nil without it
 type LetStatement struct {
 	// the token.LET token
-	Token token.Token
-	Name  *Identifier
-	Expr  Expression
+	Token   token.Token
+	Name    *Identifier
+	Pattern Pattern
+	Type    TypeExpr
+	Expr    Expression
 }
 
 func (ls *LetStatement) statementNode()       {}
@@ -117,7 +128,14 @@ func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
 	out.WriteString(ls.TokenLiteral() + " ")
-	out.WriteString(ls.Name.String())
+	if ls.Pattern != nil {
+		out.WriteString(ls.Pattern.String())
+	} else {
+		out.WriteString(ls.Name.String())
+	}
+	if ls.Type != nil {
+		out.WriteString(": " + ls.Type.String())
+	}
 	out.WriteString(" = ")
 
 	if ls.Expr != nil {
@@ -152,6 +170,25 @@ func (rs *ReturnStatement) String() string {
 	return out.String()
 }
 
+// ThrowStatement for throw "bad input"; or throw error("ValueError", "bad input");
+type ThrowStatement struct {
+	// the token.THROW token
+	Token token.Token
+	Expr  Expression
+}
+
+func (r *ThrowStatement) statementNode()       {}
+func (r *ThrowStatement) TokenLiteral() string { return r.Token.RawString }
+func (r *ThrowStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(r.TokenLiteral() + " ")
+	out.WriteString(r.Expr.String())
+	out.WriteString(";")
+
+	return out.String()
+}
+
 // ExpressionStatement for one statement, which is the expression
 // expression is self-recursion, Expr is the root, which may have Left, Op, Right, ect.
 type ExpressionStatement struct {
@@ -175,10 +212,15 @@ func (r *ExpressionStatement) String() string {
 // Identifier 是 变量名，变量名是不可变的，这里只保存 变量名，不保存 变量值；
 // 变量值 是在 eval(letStatement) 时计算出来的，保存到 env 中, key 是 这里的 变量名
 // expression: foobar, 注意：没有引号，如果有引号，就表示 StringLiteral
+// Depth and Slot are filled by evaluator.Resolve: the value is in the env Depth levels out,
+// in its slot Slot-1, or found there by Name when Slot is 0 (a global, or a program not resolved).
 type Identifier struct {
 	// the token.IDENT token
 	Token token.Token
 	Name  string
+
+	Depth int `json:"-"`
+	Slot  int `json:"-"`
 }
 
 func (i *Identifier) expressionNode()      {}
@@ -229,6 +271,8 @@ func (r *StringLiteral) String() string       { return r.Token.RawString }
 type ArrayLiteral struct {
 	Token    token.Token
 	Elements []Expression
+	// the closing bracket, where the last element ends
+	Rbracket token.Token
 }
 
 func (r *ArrayLiteral) expressionNode()      {}
@@ -249,11 +293,19 @@ func (r *ArrayLiteral) String() string {
 }
 
 // FunctionLiteral parses function definition, fn(a, b) { c = a + b; c; }
-// Notice: no name for the function
+// Name is nil for fn(a, b) { ... }, and set for fn add(a, b) { ... }:
+// as a statement it declares add in the scope (hoisted, so it can be called above the declaration),
+// as an expression add is only visible inside its own body, for the recursion.
+// a param is an Identifier, or an ArrayPattern/HashPattern destructuring the argument: fn([x, y], {name}) { ... }
+// fn(a: int, b): bool { ... } has annotations, ParamTypes is nil when no param has one,
+// otherwise it goes along FormalParams with nil for the params without; ReturnType is nil without it.
 type FunctionLiteral struct {
 	// token.FUNCTION is always the same (fn)
 	Token        token.Token
-	FormalParams []*Identifier
+	Name         *Identifier
+	FormalParams []Pattern
+	ParamTypes   []TypeExpr
+	ReturnType   TypeExpr
 	Body         *BlockStatement
 }
 
@@ -263,7 +315,47 @@ func (r *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
 	params := []string{}
-	for _, p := range r.FormalParams {
+	for i, p := range r.FormalParams {
+		if r.ParamTypes != nil && r.ParamTypes[i] != nil {
+			params = append(params, p.String()+": "+r.ParamTypes[i].String())
+			continue
+		}
+		params = append(params, p.String())
+	}
+
+	out.WriteString(r.TokenLiteral())
+	if r.Name != nil {
+		out.WriteString(" " + r.Name.String())
+	}
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(")")
+	if r.ReturnType != nil {
+		out.WriteString(": " + r.ReturnType.String())
+	}
+	out.WriteString(" ")
+	out.WriteString(r.Body.String())
+
+	return out.String()
+}
+
+// MacroLiteral is macro(cond, body) { quote(...) }, it is only allowed as let m = macro(...) at the top level.
+// The macros are taken out of the program before it runs, and every call m(a, b) is replaced by
+// the quote the body returns, the args are passed to it unevaluated, as quotes of their ast.
+type MacroLiteral struct {
+	// token.MACRO
+	Token      token.Token
+	Parameters []*Identifier
+	Body       *BlockStatement
+}
+
+func (r *MacroLiteral) expressionNode()      {}
+func (r *MacroLiteral) TokenLiteral() string { return r.Token.RawString }
+func (r *MacroLiteral) String() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range r.Parameters {
 		params = append(params, p.String())
 	}
 
@@ -276,9 +368,15 @@ func (r *FunctionLiteral) String() string {
 	return out.String()
 }
 
+// HashLiteral for {"one": 1, two: 1 + 1}
+// Pairs is looked up by key node, Keys keeps the keys in source order,
+// so the hash built from the literal (and String) follows the order it was written in.
 type HashLiteral struct {
 	Token token.Token
 	Pairs map[Expression]Expression
+	Keys  []Expression
+	// the closing brace, where the last pair ends
+	Rbrace token.Token
 }
 
 func (r *HashLiteral) expressionNode()      {}
@@ -287,8 +385,8 @@ func (r *HashLiteral) String() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for key, value := range r.Pairs {
-		pairs = append(pairs, key.String()+":"+value.String())
+	for _, key := range r.Keys {
+		pairs = append(pairs, key.String()+":"+r.Pairs[key].String())
 	}
 
 	out.WriteString("{")
@@ -355,6 +453,8 @@ type CallExpression struct {
 	Token        token.Token
 	CallableName Expression
 	ActualParams []Expression
+	// the closing paren, where the last arg ends
+	Rparen token.Token
 }
 
 func (r *CallExpression) expressionNode()      {}
@@ -377,10 +477,12 @@ func (r *CallExpression) String() string {
 
 // IndexExpression is another infix operator
 // arr[2]
+// Optional is for h?.["k"] and obj?.field: null instead of an error when Left is null.
 type IndexExpression struct {
-	Token token.Token
-	Left  Expression
-	Index Expression
+	Token    token.Token
+	Left     Expression
+	Index    Expression
+	Optional bool
 }
 
 func (r *IndexExpression) expressionNode()      {}
@@ -390,6 +492,9 @@ func (r *IndexExpression) String() string {
 
 	out.WriteString("(")
 	out.WriteString(r.Left.String())
+	if r.Optional {
+		out.WriteString("?.")
+	}
 	out.WriteString("[")
 	out.WriteString(r.Index.String())
 	out.WriteString("])")
@@ -397,6 +502,64 @@ func (r *IndexExpression) String() string {
 	return out.String()
 }
 
+// SliceExpression for arr[1:3], s[2:], arr[:-1], Start/End is nil when omitted
+type SliceExpression struct {
+	// the [ token
+	Token    token.Token
+	Left     Expression
+	Start    Expression
+	End      Expression
+	Optional bool
+}
+
+func (r *SliceExpression) expressionNode()      {}
+func (r *SliceExpression) TokenLiteral() string { return r.Token.RawString }
+func (r *SliceExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(r.Left.String())
+	if r.Optional {
+		out.WriteString("?.")
+	}
+	out.WriteString("[")
+	if r.Start != nil {
+		out.WriteString(r.Start.String())
+	}
+	out.WriteString(":")
+	if r.End != nil {
+		out.WriteString(r.End.String())
+	}
+	out.WriteString("])")
+
+	return out.String()
+}
+
+// ConditionalExpression is c ? a : b, only one of Consequence and Alternative is evaluated
+type ConditionalExpression struct {
+	// the ? token
+	Token       token.Token
+	Condition   Expression
+	Consequence Expression
+	Alternative Expression
+}
+
+func (r *ConditionalExpression) expressionNode()      {}
+func (r *ConditionalExpression) TokenLiteral() string { return r.Token.RawString }
+func (r *ConditionalExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(r.Condition.String())
+	out.WriteString("?")
+	out.WriteString(r.Consequence.String())
+	out.WriteString(":")
+	out.WriteString(r.Alternative.String())
+	out.WriteString(")")
+
+	return out.String()
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 // expression: if-then-else
 type IfExpression struct {
@@ -424,3 +587,249 @@ func (r *IfExpression) String() string {
 
 	return out.String()
 }
+
+// TryExpression for try { block } catch (e) { handler } finally { cleanup }
+// at least one of Catch and Finally is set, CatchParam may be nil: catch { handler }
+type TryExpression struct {
+	// the try token
+	Token      token.Token
+	Block      *BlockStatement
+	CatchParam *Identifier
+	Catch      *BlockStatement
+	Finally    *BlockStatement
+
+	// CatchSlots are the names bound in the env of the catch param, set by evaluator.Resolve
+	CatchSlots []string `json:"-"`
+}
+
+func (r *TryExpression) expressionNode()      {}
+func (r *TryExpression) TokenLiteral() string { return r.Token.RawString }
+func (r *TryExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("try ")
+	out.WriteString(r.Block.String())
+
+	if r.Catch != nil {
+		out.WriteString(" catch")
+		if r.CatchParam != nil {
+			out.WriteString("(" + r.CatchParam.String() + ")")
+		}
+		out.WriteString(" ")
+		out.WriteString(r.Catch.String())
+	}
+
+	if r.Finally != nil {
+		out.WriteString(" finally ")
+		out.WriteString(r.Finally.String())
+	}
+
+	return out.String()
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// patterns: the left side of a match arm
+// match (value) { 0 => "zero", [x, ...rest] => x, {"name": n} if n != "" => n, _ => "other" }
+
+// Pattern describes the shape a value must have, and the names it binds when the value has that shape.
+// An Identifier is a pattern too: it matches anything and binds it, _ matches anything and binds nothing.
+type Pattern interface {
+	Expression
+	patternNode()
+}
+
+func (i *Identifier) patternNode() {}
+
+// LiteralPattern matches a value equal to Value: 1, -1, "a", true
+type LiteralPattern struct {
+	// the first token of the literal
+	Token token.Token
+	Value Expression
+}
+
+func (r *LiteralPattern) expressionNode()      {}
+func (r *LiteralPattern) patternNode()         {}
+func (r *LiteralPattern) TokenLiteral() string { return r.Token.RawString }
+func (r *LiteralPattern) String() string       { return r.Value.String() }
+
+// ArrayPattern matches an array element by element: [a, b] needs exactly 2 elements,
+// [a, ...rest] needs at least 1, and binds the others as an array to rest.
+type ArrayPattern struct {
+	// the [ token
+	Token    token.Token
+	Elements []Pattern
+	Rest     *Identifier
+}
+
+func (r *ArrayPattern) expressionNode()      {}
+func (r *ArrayPattern) patternNode()         {}
+func (r *ArrayPattern) TokenLiteral() string { return r.Token.RawString }
+func (r *ArrayPattern) String() string {
+	var out bytes.Buffer
+
+	elements := []string{}
+	for _, el := range r.Elements {
+		elements = append(elements, el.String())
+	}
+	if r.Rest != nil {
+		elements = append(elements, "..."+r.Rest.String())
+	}
+
+	out.WriteString("[")
+	out.WriteString(strings.Join(elements, ", "))
+	out.WriteString("]")
+
+	return out.String()
+}
+
+// HashPattern matches a hash having all of Keys, Values[i] is matched against the value of Keys[i].
+// The hash may have more keys. {name} is short for {"name": name}.
+type HashPattern struct {
+	// the { token
+	Token  token.Token
+	Keys   []Expression
+	Values []Pattern
+}
+
+func (r *HashPattern) expressionNode()      {}
+func (r *HashPattern) patternNode()         {}
+func (r *HashPattern) TokenLiteral() string { return r.Token.RawString }
+func (r *HashPattern) String() string {
+	var out bytes.Buffer
+
+	pairs := []string{}
+	for i, key := range r.Keys {
+		pairs = append(pairs, key.String()+":"+r.Values[i].String())
+	}
+
+	out.WriteString("{")
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// expression: match
+
+// MatchExpression evaluates to the Body of the first arm whose Pattern matches Subject (and whose Guard is truthy)
+type MatchExpression struct {
+	// the match token
+	Token   token.Token
+	Subject Expression
+	Arms    []*MatchArm
+	// the closing brace, where the last arm ends
+	Rbrace token.Token
+}
+
+func (r *MatchExpression) expressionNode()      {}
+func (r *MatchExpression) TokenLiteral() string { return r.Token.RawString }
+func (r *MatchExpression) String() string {
+	var out bytes.Buffer
+
+	arms := []string{}
+	for _, arm := range r.Arms {
+		arms = append(arms, arm.String())
+	}
+
+	out.WriteString("match")
+	out.WriteString(r.Subject.String())
+	out.WriteString(" {")
+	out.WriteString(strings.Join(arms, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+// MatchArm is pattern => body or pattern if guard => body, Guard is nil without if
+type MatchArm struct {
+	// the first token of the pattern
+	Token   token.Token
+	Pattern Pattern
+	Guard   Expression
+	Body    Expression
+
+	// Slots are the names the arm binds, set by evaluator.Resolve
+	Slots []string `json:"-"`
+}
+
+func (r *MatchArm) TokenLiteral() string { return r.Token.RawString }
+func (r *MatchArm) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(r.Pattern.String())
+	if r.Guard != nil {
+		out.WriteString(" if ")
+		out.WriteString(r.Guard.String())
+	}
+	out.WriteString(" => ")
+	out.WriteString(r.Body.String())
+
+	return out.String()
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// type annotations, only read by the type checker, the evaluator ignores them
+// let x: int = 1;  fn(a: string, b: [int]): {string: bool} { ... }  let f: fn(int): int = ...
+
+// TypeExpr is a type written in the source
+type TypeExpr interface {
+	Node
+	typeNode()
+}
+
+// NamedType is one of int, string, bool, null, any
+type NamedType struct {
+	Token token.Token
+	Name  string
+}
+
+func (t *NamedType) typeNode()            {}
+func (t *NamedType) TokenLiteral() string { return t.Token.RawString }
+func (t *NamedType) String() string       { return t.Name }
+
+// ArrayType is [elem]
+type ArrayType struct {
+	// the [ token
+	Token token.Token
+	Elem  TypeExpr
+}
+
+func (t *ArrayType) typeNode()            {}
+func (t *ArrayType) TokenLiteral() string { return t.Token.RawString }
+func (t *ArrayType) String() string       { return "[" + t.Elem.String() + "]" }
+
+// HashType is {key: value}
+type HashType struct {
+	// the { token
+	Token token.Token
+	Key   TypeExpr
+	Value TypeExpr
+}
+
+func (t *HashType) typeNode()            {}
+func (t *HashType) TokenLiteral() string { return t.Token.RawString }
+func (t *HashType) String() string       { return "{" + t.Key.String() + ": " + t.Value.String() + "}" }
+
+// FunctionType is fn(param, ...): return, Return is nil when it is not written
+type FunctionType struct {
+	// the fn token
+	Token  token.Token
+	Params []TypeExpr
+	Return TypeExpr
+}
+
+func (t *FunctionType) typeNode()            {}
+func (t *FunctionType) TokenLiteral() string { return t.Token.RawString }
+func (t *FunctionType) String() string {
+	params := []string{}
+	for _, p := range t.Params {
+		params = append(params, p.String())
+	}
+
+	out := "fn(" + strings.Join(params, ", ") + ")"
+	if t.Return != nil {
+		out += ": " + t.Return.String()
+	}
+	return out
+}
@@ -1,6 +1,8 @@
 package ast
 
 import (
+	"strconv"
+	"strings"
 	"testing"
 
 	"xmonkey/token"
@@ -28,3 +30,298 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() wrong. got=%q", program.String())
 	}
 }
+
+func TestJSON(t *testing.T) {
+	// (1 + x) as the parser would make it
+	program := &Program{
+		Statements: []Statement{
+			&ExpressionStatement{
+				Token: token.Token{Type: token.INT, RawString: "1", Line: 1, Column: 1},
+				Expr: &InfixExpression{
+					Token:    token.Token{Type: token.PLUS, RawString: "+", Line: 1, Column: 3},
+					Left:     &IntegerLiteral{Token: token.Token{Type: token.INT, RawString: "1", Line: 1, Column: 1}, Value: 1},
+					Operator: "+",
+					Right:    &Identifier{Token: token.Token{Type: token.IDENT, RawString: "x", Line: 1, Column: 5}, Name: "x"},
+				},
+			},
+		},
+	}
+
+	data, err := EncodeJSON(program)
+	if err != nil {
+		t.Fatalf("encode: %s", err)
+	}
+
+	expected := `{"kind":"Program","statements":[{"kind":"ExpressionStatement","token":{"type":"INT","literal":"1","line":1,"column":1},` +
+		`"expr":{"kind":"InfixExpression","token":{"type":"+","literal":"+","line":1,"column":3},` +
+		`"left":{"kind":"IntegerLiteral","token":{"type":"INT","literal":"1","line":1,"column":1},"value":1},"operator":"+",` +
+		`"right":{"kind":"Identifier","token":{"type":"IDENT","literal":"x","line":1,"column":5},"name":"x"}}}]}`
+	if string(data) != expected {
+		t.Errorf("encode wrong.\nexpected=%s\ngot=     %s", expected, data)
+	}
+
+	node, err := DecodeJSON(data)
+	if err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+	if node.String() != program.String() {
+		t.Errorf("decode wrong. expected=%q, got=%q", program.String(), node.String())
+	}
+}
+
+func TestJSONHashLiteral(t *testing.T) {
+	a := &StringLiteral{Token: token.Token{Type: token.STRING, RawString: "a"}, Value: "a"}
+	b := &StringLiteral{Token: token.Token{Type: token.STRING, RawString: "b"}, Value: "b"}
+	one := &IntegerLiteral{Token: token.Token{Type: token.INT, RawString: "1"}, Value: 1}
+	two := &IntegerLiteral{Token: token.Token{Type: token.INT, RawString: "2"}, Value: 2}
+
+	hash := &HashLiteral{
+		Token: token.Token{Type: token.LBRACE, RawString: "{"},
+		Pairs: map[Expression]Expression{b: two, a: one},
+		Keys:  []Expression{b, a},
+	}
+
+	data, err := EncodeJSON(hash)
+	if err != nil {
+		t.Fatalf("encode: %s", err)
+	}
+
+	node, err := DecodeJSON(data)
+	if err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+
+	decoded, ok := node.(*HashLiteral)
+	if !ok {
+		t.Fatalf("not *HashLiteral. got=%T", node)
+	}
+	if len(decoded.Keys) != 2 || len(decoded.Pairs) != 2 {
+		t.Fatalf("wrong pairs. got keys=%v pairs=%v", decoded.Keys, decoded.Pairs)
+	}
+	if decoded.String() != hash.String() {
+		t.Errorf("the order of the keys changed. expected=%q, got=%q", hash.String(), decoded.String())
+	}
+	if decoded.Pairs[decoded.Keys[0]].String() != "2" {
+		t.Errorf("pair wrong. got %s: %s", decoded.Keys[0], decoded.Pairs[decoded.Keys[0]])
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"kind":"Nothing"}`, `unknown node kind "Nothing"`},
+		{`{"token":{}}`, `node without kind: {"token":{}}`},
+		{`{"kind":"ReturnStatement","expr":{"kind":"LetStatement"}}`, "ReturnStatement.expr: LetStatement can not be used as Expression"},
+		{`{"kind":"HashLiteral","pairs":[{"key":null,"value":null}]}`, "HashLiteral.pairs: key is null"},
+	}
+
+	for _, tt := range tests {
+		_, err := DecodeJSON([]byte(tt.input))
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%s: expected error %q, got %v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func ident(name string) *Identifier {
+	return &Identifier{Token: token.Token{Type: token.IDENT, RawString: name}, Name: name}
+}
+
+func integer(value int64) *IntegerLiteral {
+	return &IntegerLiteral{Token: token.Token{Type: token.INT, RawString: strconv.FormatInt(value, 10)}, Value: value}
+}
+
+func block(exprs ...Expression) *BlockStatement {
+	b := &BlockStatement{}
+	for _, expr := range exprs {
+		b.Statements = append(b.Statements, &ExpressionStatement{Expr: expr})
+	}
+	return b
+}
+
+func TestInspect(t *testing.T) {
+	// let f = fn(a) { if (a) { b } else { c } }; {d: [e]}[0]
+	hash := &HashLiteral{Pairs: map[Expression]Expression{}}
+	hash.Keys = []Expression{ident("d")}
+	hash.Pairs[hash.Keys[0]] = &ArrayLiteral{Elements: []Expression{ident("e")}}
+
+	program := &Program{Statements: []Statement{
+		&LetStatement{Name: ident("f"), Expr: &FunctionLiteral{
+			FormalParams: []Pattern{ident("a")},
+			Body:         block(&IfExpression{Condition: ident("a"), Consequence: block(ident("b")), Alternative: block(ident("c"))}),
+		}},
+		&ExpressionStatement{Expr: &IndexExpression{Left: hash, Index: integer(0)}},
+	}}
+
+	var names []string
+	Inspect(program, func(node Node) bool {
+		if id, ok := node.(*Identifier); ok {
+			names = append(names, id.Name)
+		}
+		return true
+	})
+
+	if got := strings.Join(names, " "); got != "f a a b c d e" {
+		t.Errorf("identifiers visited wrong. got=%q", got)
+	}
+
+	// false skips the children, fn bodies are left out here
+	names = nil
+	Inspect(program, func(node Node) bool {
+		if id, ok := node.(*Identifier); ok {
+			names = append(names, id.Name)
+		}
+		_, isFn := node.(*FunctionLiteral)
+		return !isFn
+	})
+
+	if got := strings.Join(names, " "); got != "f d e" {
+		t.Errorf("identifiers visited wrong. got=%q", got)
+	}
+}
+
+func TestModify(t *testing.T) {
+	one := func() Expression { return integer(1) }
+	two := func() Expression { return integer(2) }
+
+	turnOneIntoTwo := func(node Node) Node {
+		if i, ok := node.(*IntegerLiteral); ok && i.Value == 1 {
+			return integer(2)
+		}
+		return node
+	}
+
+	hash := &HashLiteral{Pairs: map[Expression]Expression{}}
+	key := one()
+	hash.Keys = []Expression{key}
+	hash.Pairs[key] = one()
+
+	tests := []struct {
+		input    Node
+		expected Node
+	}{
+		{one(), two()},
+		{&Program{Statements: []Statement{&ExpressionStatement{Expr: one()}}}, &Program{Statements: []Statement{&ExpressionStatement{Expr: two()}}}},
+		{&InfixExpression{Left: one(), Operator: "+", Right: two()}, &InfixExpression{Left: two(), Operator: "+", Right: two()}},
+		{&PrefixExpression{Operator: "-", Right: one()}, &PrefixExpression{Operator: "-", Right: two()}},
+		{&IndexExpression{Left: one(), Index: one()}, &IndexExpression{Left: two(), Index: two()}},
+		{
+			&IfExpression{Condition: one(), Consequence: block(one()), Alternative: block(one())},
+			&IfExpression{Condition: two(), Consequence: block(two()), Alternative: block(two())},
+		},
+		{&IfExpression{Condition: one(), Consequence: block(one())}, &IfExpression{Condition: two(), Consequence: block(two())}},
+		{&ReturnStatement{Expr: one()}, &ReturnStatement{Expr: two()}},
+		{&LetStatement{Name: ident("x"), Expr: one()}, &LetStatement{Name: ident("x"), Expr: two()}},
+		{
+			&FunctionLiteral{FormalParams: []Pattern{ident("a")}, Body: block(one())},
+			&FunctionLiteral{FormalParams: []Pattern{ident("a")}, Body: block(two())},
+		},
+		{&ArrayLiteral{Elements: []Expression{one(), one()}}, &ArrayLiteral{Elements: []Expression{two(), two()}}},
+		{&CallExpression{CallableName: ident("f"), ActualParams: []Expression{one()}}, &CallExpression{CallableName: ident("f"), ActualParams: []Expression{two()}}},
+	}
+
+	for _, tt := range tests {
+		modified := Modify(tt.input, turnOneIntoTwo)
+		if modified.String() != tt.expected.String() {
+			t.Errorf("not modified. expected=%q, got=%q", tt.expected.String(), modified.String())
+		}
+	}
+
+	Modify(hash, turnOneIntoTwo)
+	for _, key := range hash.Keys {
+		if key.(*IntegerLiteral).Value != 2 {
+			t.Errorf("key not modified. got=%s", key)
+		}
+		if value, ok := hash.Pairs[key]; !ok || value.(*IntegerLiteral).Value != 2 {
+			t.Errorf("value not modified, or lost its key. got=%v", hash.Pairs)
+		}
+	}
+}
+
+func TestCopy(t *testing.T) {
+	// let f = fn g(a, [b, ...c]) { try { {a: b}[0][1:] } catch (e) { match e { 1 if !a => c } } }
+	name := ident("f")
+	name.Depth, name.Slot = 0, 1
+	key := ident("a")
+	hash := &HashLiteral{Pairs: map[Expression]Expression{key: ident("b")}, Keys: []Expression{key}}
+	body := block(&TryExpression{
+		Block: block(&SliceExpression{
+			Left:  &IndexExpression{Left: hash, Index: integer(0)},
+			Start: integer(1),
+		}),
+		CatchParam: ident("e"),
+		Catch: block(&MatchExpression{Subject: ident("e"), Arms: []*MatchArm{{
+			Pattern: &LiteralPattern{Value: integer(1)},
+			Guard:   &PrefixExpression{Operator: "!", Right: ident("a")},
+			Body:    ident("c"),
+			Slots:   []string{"c"},
+		}}}),
+		CatchSlots: []string{"e"},
+	})
+	body.Slots = []string{"a", "b", "c"}
+	program := &Program{Statements: []Statement{&LetStatement{
+		Token: token.Token{Type: token.LET, RawString: "let", Line: 1, Column: 1},
+		Name:  name,
+		Expr: &FunctionLiteral{
+			Name:         ident("g"),
+			FormalParams: []Pattern{ident("a"), &ArrayPattern{Elements: []Pattern{ident("b")}, Rest: ident("c")}},
+			ParamTypes:   []TypeExpr{&NamedType{Name: "int"}, nil},
+			ReturnType:   &FunctionType{Params: []TypeExpr{&ArrayType{Elem: &NamedType{Name: "int"}}}, Return: &HashType{Key: &NamedType{Name: "string"}, Value: &NamedType{Name: "int"}}},
+			Body:         body,
+		},
+	}}}
+
+	copied := Copy(program)
+
+	expected, err := EncodeJSON(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := EncodeJSON(copied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(expected) {
+		t.Errorf("copy differs.\nexpected=%s\ngot=%s", expected, got)
+	}
+
+	original := map[Node]bool{}
+	Inspect(program, func(node Node) bool {
+		if node != nil {
+			original[node] = true
+		}
+		return true
+	})
+	Inspect(copied, func(node Node) bool {
+		if original[node] {
+			t.Errorf("node %T %q shared with the original", node, node.String())
+		}
+		switch node := node.(type) {
+		case *Identifier:
+			if node.Depth != 0 || node.Slot != 0 {
+				t.Errorf("%s kept its slot: depth=%d, slot=%d", node.Name, node.Depth, node.Slot)
+			}
+		case *BlockStatement:
+			if node.Slots != nil {
+				t.Errorf("block kept its slots: %v", node.Slots)
+			}
+		case *MatchArm:
+			if node.Slots != nil {
+				t.Errorf("match arm kept its slots: %v", node.Slots)
+			}
+		case *TryExpression:
+			if node.CatchSlots != nil {
+				t.Errorf("try kept its catch slots: %v", node.CatchSlots)
+			}
+		case *HashLiteral:
+			for _, key := range node.Keys {
+				if _, ok := node.Pairs[key]; !ok {
+					t.Errorf("key %s lost its value", key)
+				}
+			}
+		}
+		return true
+	})
+}
@@ -0,0 +1,314 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"xmonkey/token"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// json 编码/解码
+// every node is an object, "kind" is the name of its type, then its fields in declaration order,
+// named like the go fields with a lower first letter:
+//
+//	{"kind": "LetStatement", "token": {"type": "LET", "literal": "let", "line": 1, "column": 1},
+//	 "name": {"kind": "Identifier", ...}, "pattern": null, "type": null, "expr": {...}}
+//
+// tokens keep their position, the fields tagged json:"-" (what the resolver fills in) are left out,
+// HashLiteral is written as its pairs in source order:
+//
+//	{"kind": "HashLiteral", "token": {...}, "pairs": [{"key": {...}, "value": {...}}]}
+
+// nodeKinds are all the nodes DecodeJSON can make, by kind
+var nodeKinds = map[string]reflect.Type{}
+
+func init() {
+	for _, node := range []Node{
+		&Program{}, &BlockStatement{}, &LetStatement{}, &ReturnStatement{}, &ThrowStatement{}, &ExpressionStatement{},
+		&Identifier{}, &IntegerLiteral{}, &Boolean{}, &StringLiteral{}, &ArrayLiteral{}, &FunctionLiteral{}, &MacroLiteral{}, &HashLiteral{},
+		&PrefixExpression{}, &InfixExpression{}, &CallExpression{}, &IndexExpression{}, &SliceExpression{},
+		&ConditionalExpression{}, &IfExpression{}, &TryExpression{},
+		&LiteralPattern{}, &ArrayPattern{}, &HashPattern{}, &MatchExpression{}, &MatchArm{},
+		&NamedType{}, &ArrayType{}, &HashType{}, &FunctionType{},
+	} {
+		t := reflect.TypeOf(node).Elem()
+		nodeKinds[t.Name()] = t
+	}
+}
+
+var (
+	tokenType = reflect.TypeOf(token.Token{})
+	nodeType  = reflect.TypeOf((*Node)(nil)).Elem()
+)
+
+// EncodeJSON writes node and all the nodes below it as json
+func EncodeJSON(node Node) ([]byte, error) {
+	var out bytes.Buffer
+	if err := encodeNode(&out, reflect.ValueOf(node)); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// DecodeJSON reads back what EncodeJSON wrote
+func DecodeJSON(data []byte) (Node, error) {
+	v, err := decodeNode(data, nodeType)
+	if err != nil {
+		return nil, err
+	}
+	if !v.IsValid() || v.IsNil() {
+		return nil, nil
+	}
+	return v.Interface().(Node), nil
+}
+
+func jsonName(field string) string {
+	return strings.ToLower(field[:1]) + field[1:]
+}
+
+type jsonToken struct {
+	Type    token.TokenType `json:"type"`
+	Literal string          `json:"literal"`
+	Line    int             `json:"line"`
+	Column  int             `json:"column"`
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// encode
+
+// encodeNode writes a node, v is a pointer to a node struct or an interface holding one
+func encodeNode(out *bytes.Buffer, v reflect.Value) error {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if !v.IsValid() || v.IsNil() {
+		out.WriteString("null")
+		return nil
+	}
+
+	t := v.Elem().Type()
+	if _, ok := nodeKinds[t.Name()]; !ok {
+		return fmt.Errorf("unknown node %s", t)
+	}
+
+	fmt.Fprintf(out, `{"kind":%q`, t.Name())
+
+	s := v.Elem()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		// Pairs is written in the order of Keys, the keys come back with the pairs
+		if (t.Name() == "HashLiteral" && field.Name == "Keys") || field.Tag.Get("json") == "-" {
+			continue
+		}
+
+		fmt.Fprintf(out, `,%q:`, jsonName(field.Name))
+		if err := encodeValue(out, s.Field(i), s); err != nil {
+			return err
+		}
+	}
+
+	out.WriteString("}")
+	return nil
+}
+
+func encodeValue(out *bytes.Buffer, v reflect.Value, parent reflect.Value) error {
+	switch {
+	case v.Type() == tokenType:
+		tok := v.Interface().(token.Token)
+		b, err := json.Marshal(jsonToken{Type: tok.Type, Literal: tok.RawString, Line: tok.Line, Column: tok.Column})
+		if err != nil {
+			return err
+		}
+		out.Write(b)
+		return nil
+
+	case v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr:
+		return encodeNode(out, v)
+
+	case v.Kind() == reflect.Slice:
+		if v.IsNil() {
+			out.WriteString("null")
+			return nil
+		}
+		out.WriteString("[")
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				out.WriteString(",")
+			}
+			if err := encodeValue(out, v.Index(i), parent); err != nil {
+				return err
+			}
+		}
+		out.WriteString("]")
+		return nil
+
+	case v.Kind() == reflect.Map:
+		hash := parent.Addr().Interface().(*HashLiteral)
+		out.WriteString("[")
+		for i, key := range hash.Keys {
+			if i > 0 {
+				out.WriteString(",")
+			}
+			out.WriteString(`{"key":`)
+			if err := encodeNode(out, reflect.ValueOf(key)); err != nil {
+				return err
+			}
+			out.WriteString(`,"value":`)
+			if err := encodeNode(out, reflect.ValueOf(hash.Pairs[key])); err != nil {
+				return err
+			}
+			out.WriteString("}")
+		}
+		out.WriteString("]")
+		return nil
+	}
+
+	// string, int64, bool
+	b, err := json.Marshal(v.Interface())
+	if err != nil {
+		return err
+	}
+	out.Write(b)
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// decode
+
+// decodeNode reads a node object into a value of type want (an interface or a node pointer),
+// the zero value for null
+func decodeNode(data []byte, want reflect.Type) (reflect.Value, error) {
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		return reflect.Zero(want), nil
+	}
+
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return reflect.Value{}, err
+	}
+
+	var kind string
+	if err := json.Unmarshal(fields["kind"], &kind); err != nil {
+		return reflect.Value{}, fmt.Errorf("node without kind: %s", abbrev(data))
+	}
+
+	t, ok := nodeKinds[kind]
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("unknown node kind %q", kind)
+	}
+
+	node := reflect.New(t)
+	if !node.Type().AssignableTo(want) {
+		return reflect.Value{}, fmt.Errorf("%s can not be used as %s", kind, want.Name())
+	}
+
+	s := node.Elem()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		raw, ok := fields[jsonName(field.Name)]
+		if !ok || field.Tag.Get("json") == "-" {
+			continue
+		}
+
+		if kind == "HashLiteral" && field.Name == "Pairs" {
+			if err := decodePairs(raw, node.Interface().(*HashLiteral)); err != nil {
+				return reflect.Value{}, err
+			}
+			continue
+		}
+
+		if err := decodeValue(raw, s.Field(i)); err != nil {
+			return reflect.Value{}, fmt.Errorf("%s.%s: %s", kind, jsonName(field.Name), err)
+		}
+	}
+
+	return node, nil
+}
+
+func decodeValue(raw json.RawMessage, v reflect.Value) error {
+	switch {
+	case v.Type() == tokenType:
+		var tok jsonToken
+		if err := json.Unmarshal(raw, &tok); err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(token.Token{Type: tok.Type, RawString: tok.Literal, Line: tok.Line, Column: tok.Column}))
+		return nil
+
+	case v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr:
+		node, err := decodeNode(raw, v.Type())
+		if err != nil {
+			return err
+		}
+		v.Set(node)
+		return nil
+
+	case v.Kind() == reflect.Slice:
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return err
+		}
+		if items == nil {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+
+		slice := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := decodeValue(item, slice.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	}
+
+	return json.Unmarshal(raw, v.Addr().Interface())
+}
+
+func decodePairs(raw json.RawMessage, hash *HashLiteral) error {
+	var pairs []struct {
+		Key   json.RawMessage `json:"key"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(raw, &pairs); err != nil {
+		return err
+	}
+
+	expressionType := reflect.TypeOf((*Expression)(nil)).Elem()
+
+	hash.Pairs = map[Expression]Expression{}
+	hash.Keys = []Expression{}
+	for _, pair := range pairs {
+		key, err := decodeNode(pair.Key, expressionType)
+		if err != nil {
+			return err
+		}
+		value, err := decodeNode(pair.Value, expressionType)
+		if err != nil {
+			return err
+		}
+
+		// every key node is a different map key, even for the same text
+		k, _ := key.Interface().(Expression)
+		if k == nil {
+			return fmt.Errorf("HashLiteral.pairs: key is null")
+		}
+		hash.Keys = append(hash.Keys, k)
+		hash.Pairs[k], _ = value.Interface().(Expression)
+	}
+	return nil
+}
+
+func abbrev(data []byte) string {
+	if len(data) > 40 {
+		return string(data[:40]) + "..."
+	}
+	return string(data)
+}
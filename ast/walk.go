@@ -0,0 +1,567 @@
+package ast
+
+////////////////////////////////////////////////////////////////////////////////
+// 遍历和改写 ast
+// Walk/Inspect visit a node and everything below it in source order,
+// Modify rebuilds the tree bottom up and Copy duplicates it, so the tools (check, optimize, macros) need no type switch of their own.
+
+// Visitor is called by Walk for every node, if the result w is not nil,
+// Walk visits the children of node with w, then calls w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk visits node with v, then its children depth first
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		walkStatements(v, n.Statements)
+
+	case *BlockStatement:
+		walkStatements(v, n.Statements)
+
+	case *LetStatement:
+		if n.Name != nil {
+			Walk(v, n.Name)
+		}
+		if n.Pattern != nil {
+			Walk(v, n.Pattern)
+		}
+		if n.Type != nil {
+			Walk(v, n.Type)
+		}
+		walkIf(v, n.Expr)
+
+	case *ReturnStatement:
+		walkIf(v, n.Expr)
+
+	case *ThrowStatement:
+		walkIf(v, n.Expr)
+
+	case *ExpressionStatement:
+		walkIf(v, n.Expr)
+
+	case *Identifier, *IntegerLiteral, *Boolean, *StringLiteral, *NamedType:
+		// no children
+
+	case *ArrayLiteral:
+		walkExpressions(v, n.Elements)
+
+	case *FunctionLiteral:
+		if n.Name != nil {
+			Walk(v, n.Name)
+		}
+		for i, param := range n.FormalParams {
+			Walk(v, param)
+			if n.ParamTypes != nil && n.ParamTypes[i] != nil {
+				Walk(v, n.ParamTypes[i])
+			}
+		}
+		if n.ReturnType != nil {
+			Walk(v, n.ReturnType)
+		}
+		if n.Body != nil {
+			Walk(v, n.Body)
+		}
+
+	case *MacroLiteral:
+		for _, param := range n.Parameters {
+			Walk(v, param)
+		}
+		if n.Body != nil {
+			Walk(v, n.Body)
+		}
+
+	case *HashLiteral:
+		for _, key := range n.Keys {
+			Walk(v, key)
+			walkIf(v, n.Pairs[key])
+		}
+
+	case *PrefixExpression:
+		walkIf(v, n.Right)
+
+	case *InfixExpression:
+		walkIf(v, n.Left)
+		walkIf(v, n.Right)
+
+	case *CallExpression:
+		walkIf(v, n.CallableName)
+		walkExpressions(v, n.ActualParams)
+
+	case *IndexExpression:
+		walkIf(v, n.Left)
+		walkIf(v, n.Index)
+
+	case *SliceExpression:
+		walkIf(v, n.Left)
+		walkIf(v, n.Start)
+		walkIf(v, n.End)
+
+	case *ConditionalExpression:
+		walkIf(v, n.Condition)
+		walkIf(v, n.Consequence)
+		walkIf(v, n.Alternative)
+
+	case *IfExpression:
+		walkIf(v, n.Condition)
+		if n.Consequence != nil {
+			Walk(v, n.Consequence)
+		}
+		if n.Alternative != nil {
+			Walk(v, n.Alternative)
+		}
+
+	case *TryExpression:
+		if n.Block != nil {
+			Walk(v, n.Block)
+		}
+		if n.CatchParam != nil {
+			Walk(v, n.CatchParam)
+		}
+		if n.Catch != nil {
+			Walk(v, n.Catch)
+		}
+		if n.Finally != nil {
+			Walk(v, n.Finally)
+		}
+
+	case *LiteralPattern:
+		walkIf(v, n.Value)
+
+	case *ArrayPattern:
+		for _, el := range n.Elements {
+			Walk(v, el)
+		}
+		if n.Rest != nil {
+			Walk(v, n.Rest)
+		}
+
+	case *HashPattern:
+		for i, key := range n.Keys {
+			Walk(v, key)
+			Walk(v, n.Values[i])
+		}
+
+	case *MatchExpression:
+		walkIf(v, n.Subject)
+		for _, arm := range n.Arms {
+			Walk(v, arm)
+		}
+
+	case *MatchArm:
+		Walk(v, n.Pattern)
+		walkIf(v, n.Guard)
+		walkIf(v, n.Body)
+
+	case *ArrayType:
+		Walk(v, n.Elem)
+
+	case *HashType:
+		Walk(v, n.Key)
+		Walk(v, n.Value)
+
+	case *FunctionType:
+		for _, param := range n.Params {
+			Walk(v, param)
+		}
+		if n.Return != nil {
+			Walk(v, n.Return)
+		}
+	}
+
+	v.Visit(nil)
+}
+
+func walkIf(v Visitor, expr Expression) {
+	if expr != nil {
+		Walk(v, expr)
+	}
+}
+
+func walkStatements(v Visitor, stmts []Statement) {
+	for _, stmt := range stmts {
+		Walk(v, stmt)
+	}
+}
+
+func walkExpressions(v Visitor, exprs []Expression) {
+	for _, expr := range exprs {
+		walkIf(v, expr)
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect calls f for node and everything below it, depth first;
+// if f returns false the children of that node are skipped.
+// After the children of a node, f is called with nil.
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ModifierFunc gets every node after its children are modified, and returns the node to put in its place
+type ModifierFunc func(Node) Node
+
+// Modify rewrites node bottom up with modifier and returns the new node.
+// The nodes are changed in place, a replacement which does not fit the field
+// (a Statement where an Expression is expected) leaves nil in it.
+// Names being bound (let names, params, patterns, catch params) and type annotations are not passed to modifier,
+// so a modifier replacing identifiers only touches the places they are used.
+func Modify(node Node, modifier ModifierFunc) Node {
+	switch n := node.(type) {
+	case *Program:
+		n.Statements = modifyStatements(n.Statements, modifier)
+
+	case *BlockStatement:
+		n.Statements = modifyStatements(n.Statements, modifier)
+
+	case *LetStatement:
+		n.Expr = modifyExpression(n.Expr, modifier)
+
+	case *ReturnStatement:
+		n.Expr = modifyExpression(n.Expr, modifier)
+
+	case *ThrowStatement:
+		n.Expr = modifyExpression(n.Expr, modifier)
+
+	case *ExpressionStatement:
+		n.Expr = modifyExpression(n.Expr, modifier)
+
+	case *ArrayLiteral:
+		n.Elements = modifyExpressions(n.Elements, modifier)
+
+	case *FunctionLiteral:
+		n.Body = modifyBlock(n.Body, modifier)
+
+	case *MacroLiteral:
+		n.Body = modifyBlock(n.Body, modifier)
+
+	case *HashLiteral:
+		pairs := make(map[Expression]Expression, len(n.Pairs))
+		for i, key := range n.Keys {
+			value := n.Pairs[key]
+			n.Keys[i] = modifyExpression(key, modifier)
+			pairs[n.Keys[i]] = modifyExpression(value, modifier)
+		}
+		n.Pairs = pairs
+
+	case *PrefixExpression:
+		n.Right = modifyExpression(n.Right, modifier)
+
+	case *InfixExpression:
+		n.Left = modifyExpression(n.Left, modifier)
+		n.Right = modifyExpression(n.Right, modifier)
+
+	case *CallExpression:
+		n.CallableName = modifyExpression(n.CallableName, modifier)
+		n.ActualParams = modifyExpressions(n.ActualParams, modifier)
+
+	case *IndexExpression:
+		n.Left = modifyExpression(n.Left, modifier)
+		n.Index = modifyExpression(n.Index, modifier)
+
+	case *SliceExpression:
+		n.Left = modifyExpression(n.Left, modifier)
+		n.Start = modifyExpression(n.Start, modifier)
+		n.End = modifyExpression(n.End, modifier)
+
+	case *ConditionalExpression:
+		n.Condition = modifyExpression(n.Condition, modifier)
+		n.Consequence = modifyExpression(n.Consequence, modifier)
+		n.Alternative = modifyExpression(n.Alternative, modifier)
+
+	case *IfExpression:
+		n.Condition = modifyExpression(n.Condition, modifier)
+		n.Consequence = modifyBlock(n.Consequence, modifier)
+		n.Alternative = modifyBlock(n.Alternative, modifier)
+
+	case *TryExpression:
+		n.Block = modifyBlock(n.Block, modifier)
+		n.Catch = modifyBlock(n.Catch, modifier)
+		n.Finally = modifyBlock(n.Finally, modifier)
+
+	case *MatchExpression:
+		n.Subject = modifyExpression(n.Subject, modifier)
+		for i, arm := range n.Arms {
+			n.Arms[i], _ = Modify(arm, modifier).(*MatchArm)
+		}
+
+	case *MatchArm:
+		n.Guard = modifyExpression(n.Guard, modifier)
+		n.Body = modifyExpression(n.Body, modifier)
+	}
+
+	return modifier(node)
+}
+
+func modifyExpression(expr Expression, modifier ModifierFunc) Expression {
+	if expr == nil {
+		return nil
+	}
+	modified, _ := Modify(expr, modifier).(Expression)
+	return modified
+}
+
+func modifyBlock(block *BlockStatement, modifier ModifierFunc) *BlockStatement {
+	if block == nil {
+		return nil
+	}
+	modified, _ := Modify(block, modifier).(*BlockStatement)
+	return modified
+}
+
+func modifyStatements(stmts []Statement, modifier ModifierFunc) []Statement {
+	for i, stmt := range stmts {
+		stmts[i], _ = Modify(stmt, modifier).(Statement)
+	}
+	return stmts
+}
+
+func modifyExpressions(exprs []Expression, modifier ModifierFunc) []Expression {
+	for i, expr := range exprs {
+		exprs[i] = modifyExpression(expr, modifier)
+	}
+	return exprs
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// copy
+
+// Copy returns a copy of node and all the nodes below it, positions included, not what the resolver filled in
+// (the fields tagged json:"-"), the copy has to be resolved again
+func Copy(node Node) Node {
+	switch n := node.(type) {
+	case *Program:
+		return &Program{Statements: copyStatements(n.Statements)}
+
+	case *BlockStatement:
+		return copyBlock(n)
+
+	case *LetStatement:
+		return &LetStatement{Token: n.Token, Name: copyIdentifier(n.Name), Pattern: copyPattern(n.Pattern),
+			Type: copyType(n.Type), Expr: copyExpression(n.Expr)}
+
+	case *ReturnStatement:
+		return &ReturnStatement{Token: n.Token, Expr: copyExpression(n.Expr)}
+
+	case *ThrowStatement:
+		return &ThrowStatement{Token: n.Token, Expr: copyExpression(n.Expr)}
+
+	case *ExpressionStatement:
+		return &ExpressionStatement{Token: n.Token, Expr: copyExpression(n.Expr)}
+
+	case *Identifier:
+		return copyIdentifier(n)
+
+	case *IntegerLiteral:
+		return &IntegerLiteral{Token: n.Token, Value: n.Value}
+
+	case *Boolean:
+		return &Boolean{Token: n.Token, Value: n.Value}
+
+	case *StringLiteral:
+		return &StringLiteral{Token: n.Token, Value: n.Value}
+
+	case *ArrayLiteral:
+		return &ArrayLiteral{Token: n.Token, Elements: copyExpressions(n.Elements), Rbracket: n.Rbracket}
+
+	case *FunctionLiteral:
+		fn := &FunctionLiteral{Token: n.Token, Name: copyIdentifier(n.Name), ReturnType: copyType(n.ReturnType), Body: copyBlock(n.Body)}
+		if n.FormalParams != nil {
+			fn.FormalParams = make([]Pattern, len(n.FormalParams))
+			for i, param := range n.FormalParams {
+				fn.FormalParams[i] = copyPattern(param)
+			}
+		}
+		if n.ParamTypes != nil {
+			fn.ParamTypes = make([]TypeExpr, len(n.ParamTypes))
+			for i, typ := range n.ParamTypes {
+				fn.ParamTypes[i] = copyType(typ)
+			}
+		}
+		return fn
+
+	case *MacroLiteral:
+		macro := &MacroLiteral{Token: n.Token, Body: copyBlock(n.Body)}
+		if n.Parameters != nil {
+			macro.Parameters = make([]*Identifier, len(n.Parameters))
+			for i, param := range n.Parameters {
+				macro.Parameters[i] = copyIdentifier(param)
+			}
+		}
+		return macro
+
+	case *HashLiteral:
+		hash := &HashLiteral{Token: n.Token, Pairs: make(map[Expression]Expression, len(n.Pairs)), Rbrace: n.Rbrace}
+		if n.Keys != nil {
+			hash.Keys = make([]Expression, len(n.Keys))
+		}
+		for i, key := range n.Keys {
+			hash.Keys[i] = copyExpression(key)
+			hash.Pairs[hash.Keys[i]] = copyExpression(n.Pairs[key])
+		}
+		return hash
+
+	case *PrefixExpression:
+		return &PrefixExpression{Token: n.Token, Operator: n.Operator, Right: copyExpression(n.Right)}
+
+	case *InfixExpression:
+		return &InfixExpression{Token: n.Token, Left: copyExpression(n.Left), Operator: n.Operator, Right: copyExpression(n.Right)}
+
+	case *CallExpression:
+		return &CallExpression{Token: n.Token, CallableName: copyExpression(n.CallableName),
+			ActualParams: copyExpressions(n.ActualParams), Rparen: n.Rparen}
+
+	case *IndexExpression:
+		return &IndexExpression{Token: n.Token, Left: copyExpression(n.Left), Index: copyExpression(n.Index), Optional: n.Optional}
+
+	case *SliceExpression:
+		return &SliceExpression{Token: n.Token, Left: copyExpression(n.Left), Start: copyExpression(n.Start),
+			End: copyExpression(n.End), Optional: n.Optional}
+
+	case *ConditionalExpression:
+		return &ConditionalExpression{Token: n.Token, Condition: copyExpression(n.Condition),
+			Consequence: copyExpression(n.Consequence), Alternative: copyExpression(n.Alternative)}
+
+	case *IfExpression:
+		return &IfExpression{Token: n.Token, Condition: copyExpression(n.Condition),
+			Consequence: copyBlock(n.Consequence), Alternative: copyBlock(n.Alternative)}
+
+	case *TryExpression:
+		return &TryExpression{Token: n.Token, Block: copyBlock(n.Block), CatchParam: copyIdentifier(n.CatchParam),
+			Catch: copyBlock(n.Catch), Finally: copyBlock(n.Finally)}
+
+	case *LiteralPattern:
+		return &LiteralPattern{Token: n.Token, Value: copyExpression(n.Value)}
+
+	case *ArrayPattern:
+		pattern := &ArrayPattern{Token: n.Token, Rest: copyIdentifier(n.Rest)}
+		if n.Elements != nil {
+			pattern.Elements = make([]Pattern, len(n.Elements))
+			for i, el := range n.Elements {
+				pattern.Elements[i] = copyPattern(el)
+			}
+		}
+		return pattern
+
+	case *HashPattern:
+		pattern := &HashPattern{Token: n.Token, Keys: copyExpressions(n.Keys)}
+		if n.Values != nil {
+			pattern.Values = make([]Pattern, len(n.Values))
+			for i, value := range n.Values {
+				pattern.Values[i] = copyPattern(value)
+			}
+		}
+		return pattern
+
+	case *MatchExpression:
+		match := &MatchExpression{Token: n.Token, Subject: copyExpression(n.Subject), Rbrace: n.Rbrace}
+		if n.Arms != nil {
+			match.Arms = make([]*MatchArm, len(n.Arms))
+			for i, arm := range n.Arms {
+				match.Arms[i], _ = Copy(arm).(*MatchArm)
+			}
+		}
+		return match
+
+	case *MatchArm:
+		return &MatchArm{Token: n.Token, Pattern: copyPattern(n.Pattern), Guard: copyExpression(n.Guard), Body: copyExpression(n.Body)}
+
+	case *NamedType:
+		return &NamedType{Token: n.Token, Name: n.Name}
+
+	case *ArrayType:
+		return &ArrayType{Token: n.Token, Elem: copyType(n.Elem)}
+
+	case *HashType:
+		return &HashType{Token: n.Token, Key: copyType(n.Key), Value: copyType(n.Value)}
+
+	case *FunctionType:
+		typ := &FunctionType{Token: n.Token, Return: copyType(n.Return)}
+		if n.Params != nil {
+			typ.Params = make([]TypeExpr, len(n.Params))
+			for i, param := range n.Params {
+				typ.Params[i] = copyType(param)
+			}
+		}
+		return typ
+	}
+
+	return node
+}
+
+func copyExpression(expr Expression) Expression {
+	if expr == nil {
+		return nil
+	}
+	copied, _ := Copy(expr).(Expression)
+	return copied
+}
+
+func copyPattern(pattern Pattern) Pattern {
+	if pattern == nil {
+		return nil
+	}
+	copied, _ := Copy(pattern).(Pattern)
+	return copied
+}
+
+func copyType(typ TypeExpr) TypeExpr {
+	if typ == nil {
+		return nil
+	}
+	copied, _ := Copy(typ).(TypeExpr)
+	return copied
+}
+
+func copyIdentifier(ident *Identifier) *Identifier {
+	if ident == nil {
+		return nil
+	}
+	return &Identifier{Token: ident.Token, Name: ident.Name}
+}
+
+func copyBlock(block *BlockStatement) *BlockStatement {
+	if block == nil {
+		return nil
+	}
+	return &BlockStatement{Token: block.Token, Statements: copyStatements(block.Statements), Rbrace: block.Rbrace}
+}
+
+func copyStatements(stmts []Statement) []Statement {
+	if stmts == nil {
+		return nil
+	}
+	copied := make([]Statement, len(stmts))
+	for i, stmt := range stmts {
+		if stmt != nil {
+			copied[i], _ = Copy(stmt).(Statement)
+		}
+	}
+	return copied
+}
+
+func copyExpressions(exprs []Expression) []Expression {
+	if exprs == nil {
+		return nil
+	}
+	copied := make([]Expression, len(exprs))
+	for i, expr := range exprs {
+		copied[i] = copyExpression(expr)
+	}
+	return copied
+}
@@ -0,0 +1,141 @@
+// Package check finds mistakes in a program without running it,
+// the ones the evaluator only reports when (and if) the line runs.
+package check
+
+import (
+	"fmt"
+	"sort"
+
+	"xmonkey/analysis"
+	"xmonkey/ast"
+	"xmonkey/evaluator"
+	"xmonkey/token"
+)
+
+// the kinds of problems
+const (
+	UNDEFINED   = "undefined"
+	UNUSED      = "unused"
+	SHADOW      = "shadow"
+	ARITY       = "arity"
+	UNREACHABLE = "unreachable"
+)
+
+// Problem is one finding, Token is where it is
+type Problem struct {
+	Token   token.Token
+	Kind    string
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%d:%d: %s", p.Token.Line, p.Token.Column, p.Message)
+}
+
+// Program checks a parsed program, the problems are in source order
+func Program(program *ast.Program) []Problem {
+	c := &checker{info: analysis.Resolve(program, evaluator.Scoping{})}
+
+	c.names()
+	c.code(program)
+
+	sort.SliceStable(c.problems, func(i, j int) bool {
+		a, b := c.problems[i].Token, c.problems[j].Token
+		return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+	})
+	return c.problems
+}
+
+type checker struct {
+	info     *analysis.Info
+	problems []Problem
+}
+
+func (c *checker) report(tok token.Token, kind, format string, a ...interface{}) {
+	c.problems = append(c.problems, Problem{Token: tok, Kind: kind, Message: fmt.Sprintf(format, a...)})
+}
+
+// names reports what the resolver found: undefined names, lets nobody reads, builtins hidden by a binding
+func (c *checker) names() {
+	for _, ident := range c.info.Undefined {
+		c.report(ident.Token, UNDEFINED, "undefined: %s", ident.Name)
+	}
+
+	for _, sym := range c.info.Symbols {
+		if sym.Kind == analysis.LET && len(sym.Refs) == 0 {
+			c.report(sym.Decl.Token, UNUSED, "%s declared and not used", sym.Name)
+		}
+
+		if builtin := c.info.Universe.Lookup(sym.Name); builtin != nil {
+			c.report(sym.Decl.Token, SHADOW, "%s %s shadows a builtin", sym.Kind, sym.Name)
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// walking the tree for calls and dead code
+
+func (c *checker) code(program *ast.Program) {
+	ast.Inspect(program, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.Program:
+			c.unreachable(node.Statements)
+		case *ast.BlockStatement:
+			c.unreachable(node.Statements)
+		case *ast.CallExpression:
+			c.call(node)
+		}
+		return true
+	})
+}
+
+// unreachable reports the first statement after a return or throw, once for the rest of the block
+func (c *checker) unreachable(stmts []ast.Statement) {
+	for i := 0; i+1 < len(stmts); i++ {
+		switch stmts[i].(type) {
+		case *ast.ReturnStatement, *ast.ThrowStatement:
+			c.report(firstToken(stmts[i+1]), UNREACHABLE, "unreachable code after %s", stmts[i].TokenLiteral())
+			return
+		}
+	}
+}
+
+// call compares the number of arguments with the params of the function called, when it is known:
+// a fn literal called directly, or a name bound once to a fn literal
+func (c *checker) call(call *ast.CallExpression) {
+	var fn *ast.FunctionLiteral
+	name := "function"
+
+	switch callee := call.CallableName.(type) {
+	case *ast.FunctionLiteral:
+		fn = callee
+	case *ast.Identifier:
+		if sym := c.info.Uses[callee]; sym != nil {
+			fn, _ = sym.Value.(*ast.FunctionLiteral)
+			name = sym.Name
+		}
+	}
+
+	if fn == nil || len(fn.FormalParams) == len(call.ActualParams) {
+		return
+	}
+
+	c.report(call.Token, ARITY, "wrong number of arguments to %s: got %d, want %d", name, len(call.ActualParams), len(fn.FormalParams))
+}
+
+// firstToken is where a statement starts
+func firstToken(stmt ast.Statement) token.Token {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		return stmt.Token
+	case *ast.ReturnStatement:
+		return stmt.Token
+	case *ast.ThrowStatement:
+		return stmt.Token
+	case *ast.ExpressionStatement:
+		return stmt.Token
+	case *ast.BlockStatement:
+		return stmt.Token
+	}
+	return token.Token{}
+}
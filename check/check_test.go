@@ -0,0 +1,51 @@
+package check
+
+import (
+	"strings"
+	"testing"
+
+	"xmonkey/lexer"
+	"xmonkey/parser"
+)
+
+func TestProgram(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"let x = 1; puts(x)", nil},
+		{"puts(y)", []string{"1:6: undefined: y"}},
+		{"let f = fn() { z }; let z = 1; f()", nil},
+		{"if (true) { let a = 1; }; a", []string{"1:17: a declared and not used", "1:27: undefined: a"}},
+		{"let x = 1;", []string{"1:5: x declared and not used"}},
+		{"let [a, _] = [1, 2];", []string{"1:6: a declared and not used"}},
+		{"let f = fn(unused) { 1 }; f(1)", nil},
+		{"let len = fn(x) { 1 }; len(1)", []string{"1:5: let len shadows a builtin"}},
+		{"let f = fn(first) { first }; f(1)", []string{"1:12: param first shadows a builtin"}},
+		{"let add = fn(a, b) { a + b }; add(1)", []string{"1:34: wrong number of arguments to add: got 1, want 2"}},
+		{"fn add(a, b) { a + b }; add(1, 2, 3)", []string{"1:28: wrong number of arguments to add: got 3, want 2"}},
+		{"fn(a) { a }()", []string{"1:12: wrong number of arguments to function: got 0, want 1"}},
+		{"let f = fn() { return 1; puts(2); 3 }; f()", []string{"1:26: unreachable code after return"}},
+		{"let f = fn() { if (true) { throw \"x\"; 1 } }; f()", []string{"1:39: unreachable code after throw"}},
+		{"let f = fn(x) { return x; }; f(1)", nil},
+		{"let m = macro(a) { quote(unquote(a) + later) }; m(1)", nil},
+		{"let m = macro(a) { quote(unquote(b)) }; m(1)", []string{"1:34: undefined: b"}},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("%q: parser errors: %v", tt.input, p.Errors())
+		}
+
+		var got []string
+		for _, problem := range Program(program) {
+			got = append(got, problem.String())
+		}
+
+		if strings.Join(got, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("%q: expect\n%s\ngot\n%s", tt.input, strings.Join(tt.expected, "\n"), strings.Join(got, "\n"))
+		}
+	}
+}
@@ -1,40 +1,49 @@
 package evaluator
 
-import "xmonkey/object"
+import (
+	"sort"
+	"unicode/utf8"
+
+	"xmonkey/object"
+)
 
 var builtins = map[string]*object.Builtin{
 	"len": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1", len(args))
+				return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args))
 			}
 
 			switch arg := args[0].(type) {
 			case *object.Array:
-				return &object.Integer{Value: int64(len(arg.Elements))}
+				return &object.Integer{Value: int64(arg.Len())}
 
 			case *object.String:
-				return &object.Integer{Value: int64(len(arg.Value))}
+				// chars, not bytes, the same as s[i] and s[start:end] count
+				return &object.Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
+
+			case *object.Hash:
+				return &object.Integer{Value: int64(arg.Len())}
 
 			default:
-				return newError("argument to len not supported, got %s", args[0].Type())
+				return newKindError(object.TYPE_ERROR, "argument to len not supported, got %s", args[0].Type())
 			}
 		},
 	},
 
 	"first": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1", len(args))
+				return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args))
 			}
 
 			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to first must by ARRAT, got=%T", args[0].Type())
+				return newKindError(object.TYPE_ERROR, "argument to first must by ARRAT, got=%T", args[0].Type())
 			}
 
 			arr := args[0].(*object.Array)
-			if len(arr.Elements) > 0 {
-				return arr.Elements[0]
+			if arr.Len() > 0 {
+				return arr.At(0)
 			}
 
 			return NULL
@@ -42,19 +51,19 @@ var builtins = map[string]*object.Builtin{
 	},
 
 	"last": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1", len(args))
+				return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args))
 			}
 
 			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to last must by ARRAT, got=%T", args[0].Type())
+				return newKindError(object.TYPE_ERROR, "argument to last must by ARRAT, got=%T", args[0].Type())
 			}
 
 			arr := args[0].(*object.Array)
-			length := len(arr.Elements)
+			length := arr.Len()
 			if length > 0 {
-				return arr.Elements[length-1]
+				return arr.At(length - 1)
 			}
 
 			return NULL
@@ -62,21 +71,19 @@ var builtins = map[string]*object.Builtin{
 	},
 
 	"rest": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1", len(args))
+				return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args))
 			}
 
 			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to rest must by ARRAT, got=%T", args[0].Type())
+				return newKindError(object.TYPE_ERROR, "argument to rest must by ARRAT, got=%T", args[0].Type())
 			}
 
+			// the rest shares the elements of arr, building a list with rest and push is not quadratic
 			arr := args[0].(*object.Array)
-			length := len(arr.Elements)
-			if length > 0 {
-				newElements := make([]object.Object, length-1, length-1)
-				copy(newElements, arr.Elements[1:length])
-				return &object.Array{Elements: newElements}
+			if arr.Len() > 0 {
+				return arr.Rest()
 			}
 
 			return NULL
@@ -84,23 +91,82 @@ var builtins = map[string]*object.Builtin{
 	},
 
 	"push": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
 			if len(args) != 2 {
-				return newError("wrong number of arguments. got=%d, want=2", len(args))
+				return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=2", len(args))
 			}
 
 			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to push must by ARRAT, got=%T", args[0].Type())
+				return newKindError(object.TYPE_ERROR, "argument to push must by ARRAT, got=%T", args[0].Type())
 			}
 
 			arr := args[0].(*object.Array)
-			length := len(arr.Elements)
-
-			newElements := make([]object.Object, length+1, length+1)
-			copy(newElements, arr.Elements)
-			newElements[length] = args[1]
-
-			return &object.Array{Elements: newElements}
+			return arr.Push(args[1])
 		},
 	},
 }
+
+// builtinSignatures tell how each builtin is called and what it gives, lsp shows them on hover
+var builtinSignatures = map[string]string{
+	"len":   "len(x): the length of an array, string or hash",
+	"first": "first(arr): the first element, null if arr is empty",
+	"last":  "last(arr): the last element, null if arr is empty",
+	"rest":  "rest(arr): arr without its first element, null if arr is empty",
+	"push":  "push(arr, x): arr with x added at the end",
+
+	"map":      "map(arr, fn): [fn(arr[0]), fn(arr[1]), ...]",
+	"filter":   "filter(arr, fn): the elements for which fn is truthy",
+	"reduce":   "reduce(arr, fn) or reduce(arr, fn, initial): fn(acc, el) folded from the left",
+	"sort":     "sort(arr) or sort(arr, less): a stable sorted copy",
+	"reverse":  "reverse(arr): the elements in reverse order",
+	"concat":   "concat(arr, ...): the arrays joined",
+	"slice":    "slice(arr, start) or slice(arr, start, end): the elements from start to end, exclusive",
+	"contains": "contains(arr, x): whether an element equals x",
+	"index_of": "index_of(arr, x): the index of the first element equal to x, or -1",
+	"zip":      "zip(arr, ...): [[a[0], b[0], ...], ...], as long as the shortest",
+	"flatten":  "flatten(arr): arr with one level of nesting removed",
+	"range":    "range(end), range(start, end) or range(start, end, step): the integers up to end, exclusive",
+	"any":      "any(arr) or any(arr, fn): whether some element is truthy",
+	"all":      "all(arr) or all(arr, fn): whether every element is truthy",
+	"unique":   "unique(arr): the elements without repeats, the first occurrence kept",
+	"sum":      "sum(arr): the sum of the integers",
+
+	"keys":   "keys(h): the keys in insertion order",
+	"values": "values(h): the values in insertion order",
+	"has":    "has(h, k): whether h has the key k",
+	"delete": "delete(h, k): h without k",
+	"merge":  "merge(h, ...): the pairs of all hashes, a later one wins for the value",
+
+	"puts":       "puts(x, ...): writes every argument on its own line",
+	"print":      "print(x, ...): writes the arguments separated by a space, without newline",
+	"readline":   "readline(): the next line of input, null at the end",
+	"input":      "input() or input(prompt): writes prompt, then reads a line",
+	"read_file":  "read_file(path): the content of the file, inside the sandbox",
+	"write_file": "write_file(path, content): replaces the file with content, inside the sandbox",
+	"list_dir":   "list_dir(path): the sorted names in the directory, inside the sandbox",
+
+	"error": "error(message) or error(kind, message): the hash to throw",
+
+	"quote":   "quote(expr): the code of expr instead of its value",
+	"unquote": "unquote(expr): inside quote, the value of expr put into the code",
+}
+
+// BuiltinSignature is how the builtin name is called, name itself for one without a description
+func BuiltinSignature(name string) string {
+	if sig, ok := builtinSignatures[name]; ok {
+		return sig
+	}
+	return name
+}
+
+// BuiltinNames returns the names of all builtins sorted, for tools which do not run the code (lsp, check)
+// quote and unquote are not in builtins, Eval handles them itself, but they are there before any code all the same
+func BuiltinNames() []string {
+	names := make([]string, 0, len(builtins)+2)
+	for name := range builtins {
+		names = append(names, name)
+	}
+	names = append(names, "quote", "unquote")
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,537 @@
+package evaluator
+
+import (
+	"sort"
+
+	"xmonkey/object"
+)
+
+// the array library is registered in init, not in the builtins literal:
+// map/filter/... call back into applyFunction, which looks up builtins through evalIdentifier,
+// so referencing them from the literal would be an initialization cycle.
+func init() {
+	arrayBuiltins := map[string]object.BuiltinFunc{
+		"map":      builtinMap,
+		"filter":   builtinFilter,
+		"reduce":   builtinReduce,
+		"sort":     builtinSort,
+		"reverse":  builtinReverse,
+		"concat":   builtinConcat,
+		"slice":    builtinSlice,
+		"contains": builtinContains,
+		"index_of": builtinIndexOf,
+		"zip":      builtinZip,
+		"flatten":  builtinFlatten,
+		"range":    builtinRange,
+		"any":      builtinAny,
+		"all":      builtinAll,
+		"unique":   builtinUnique,
+		"sum":      builtinSum,
+	}
+
+	for name, fn := range arrayBuiltins {
+		builtins[name] = &object.Builtin{Fn: fn}
+	}
+}
+
+// arrayArg checks args[idx] is an array, name is the builtin for the error message.
+func arrayArg(name string, args []object.Object, idx int) (*object.Array, *object.Error) {
+	arr, ok := args[idx].(*object.Array)
+	if !ok {
+		return nil, newKindError(object.TYPE_ERROR, "argument to %s must be ARRAY, got %s", name, args[idx].Type())
+	}
+
+	return arr, nil
+}
+
+func integerArg(name string, args []object.Object, idx int) (int64, *object.Error) {
+	i, ok := args[idx].(*object.Integer)
+	if !ok {
+		return 0, newKindError(object.TYPE_ERROR, "argument to %s must be INTEGER, got %s", name, args[idx].Type())
+	}
+
+	return i.Value, nil
+}
+
+// map(arr, fn) returns [fn(arr[0]), fn(arr[1]), ...]
+func builtinMap(env *object.Environment, args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=2", len(args))
+	}
+
+	arr, err := arrayArg("map", args, 0)
+	if err != nil {
+		return err
+	}
+
+	result := make([]object.Object, 0, arr.Len())
+	for _, el := range arr.Elements() {
+		mapped := applyFunction(env, args[1], []object.Object{el})
+		if isError(mapped) {
+			return mapped
+		}
+
+		result = append(result, mapped)
+	}
+
+	return object.NewArray(result)
+}
+
+// filter(arr, fn) keeps the elements for which fn returns a truthy value
+func builtinFilter(env *object.Environment, args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=2", len(args))
+	}
+
+	arr, err := arrayArg("filter", args, 0)
+	if err != nil {
+		return err
+	}
+
+	result := []object.Object{}
+	for _, el := range arr.Elements() {
+		keep := applyFunction(env, args[1], []object.Object{el})
+		if isError(keep) {
+			return keep
+		}
+
+		if isTruthy(keep) {
+			result = append(result, el)
+		}
+	}
+
+	return object.NewArray(result)
+}
+
+// reduce(arr, fn, initial) folds from the left, fn(acc, el);
+// without initial the first element is the start value.
+func builtinReduce(env *object.Environment, args ...object.Object) object.Object {
+	if len(args) != 2 && len(args) != 3 {
+		return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=2 or 3", len(args))
+	}
+
+	arr, err := arrayArg("reduce", args, 0)
+	if err != nil {
+		return err
+	}
+
+	elements := arr.Elements()
+	var acc object.Object
+	if len(args) == 3 {
+		acc = args[2]
+	} else {
+		if len(elements) == 0 {
+			return newError("reduce of empty array with no initial value")
+		}
+		acc = elements[0]
+		elements = elements[1:]
+	}
+
+	for _, el := range elements {
+		acc = applyFunction(env, args[1], []object.Object{acc, el})
+		if isError(acc) {
+			return acc
+		}
+	}
+
+	return acc
+}
+
+// sort(arr) sorts integers or strings ascending,
+// sort(arr, fn) uses fn(a, b) as "a goes before b". The sort is stable.
+func builtinSort(env *object.Environment, args ...object.Object) object.Object {
+	if len(args) != 1 && len(args) != 2 {
+		return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1 or 2", len(args))
+	}
+
+	arr, err := arrayArg("sort", args, 0)
+	if err != nil {
+		return err
+	}
+
+	sorted := arr.Elements()
+
+	var less func(a, b object.Object) bool
+	var failed object.Object
+
+	if len(args) == 2 {
+		less = func(a, b object.Object) bool {
+			if failed != nil {
+				return false
+			}
+
+			result := applyFunction(env, args[1], []object.Object{a, b})
+			if isError(result) {
+				failed = result
+				return false
+			}
+
+			return isTruthy(result)
+		}
+	} else {
+		less = func(a, b object.Object) bool {
+			switch a := a.(type) {
+			case *object.Integer:
+				return a.Value < b.(*object.Integer).Value
+			case *object.String:
+				return a.Value < b.(*object.String).Value
+			}
+			return false
+		}
+
+		for _, el := range sorted {
+			if el.Type() != sorted[0].Type() ||
+				(el.Type() != object.INTEGER_OBJ && el.Type() != object.STRING_OBJ) {
+				return newKindError(object.TYPE_ERROR, "sort without comparator needs all INTEGER or all STRING, got %s", el.Type())
+			}
+		}
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool { return less(sorted[i], sorted[j]) })
+
+	if failed != nil {
+		return failed
+	}
+
+	return object.NewArray(sorted)
+}
+
+func builtinReverse(env *object.Environment, args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	arr, err := arrayArg("reverse", args, 0)
+	if err != nil {
+		return err
+	}
+
+	length := arr.Len()
+	reversed := make([]object.Object, length)
+	for i, el := range arr.Elements() {
+		reversed[length-1-i] = el
+	}
+
+	return object.NewArray(reversed)
+}
+
+// concat(a, b, ...) joins any number of arrays
+func builtinConcat(env *object.Environment, args ...object.Object) object.Object {
+	result := []object.Object{}
+
+	for i := range args {
+		arr, err := arrayArg("concat", args, i)
+		if err != nil {
+			return err
+		}
+
+		result = append(result, arr.Elements()...)
+	}
+
+	return object.NewArray(result)
+}
+
+// sliceBounds turns start/end which may count from the end (negative) into
+// valid bounds for a sequence of the given length, the same way python does.
+func sliceBounds(length, start, end int64) (int64, int64) {
+	clamp := func(i int64) int64 {
+		if i < 0 {
+			i += length
+		}
+		if i < 0 {
+			return 0
+		}
+		if i > length {
+			return length
+		}
+		return i
+	}
+
+	start, end = clamp(start), clamp(end)
+	if end < start {
+		end = start
+	}
+
+	return start, end
+}
+
+// slice(arr, start) or slice(arr, start, end), end is exclusive, negative counts from the end
+func builtinSlice(env *object.Environment, args ...object.Object) object.Object {
+	if len(args) != 2 && len(args) != 3 {
+		return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=2 or 3", len(args))
+	}
+
+	arr, err := arrayArg("slice", args, 0)
+	if err != nil {
+		return err
+	}
+
+	length := int64(arr.Len())
+
+	start, err := integerArg("slice", args, 1)
+	if err != nil {
+		return err
+	}
+
+	end := length
+	if len(args) == 3 {
+		end, err = integerArg("slice", args, 2)
+		if err != nil {
+			return err
+		}
+	}
+
+	start, end = sliceBounds(length, start, end)
+
+	return arr.Slice(int(start), int(end))
+}
+
+func indexOf(elements []object.Object, target object.Object) int {
+	for i, el := range elements {
+		if object.Equal(el, target) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func builtinContains(env *object.Environment, args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=2", len(args))
+	}
+
+	arr, err := arrayArg("contains", args, 0)
+	if err != nil {
+		return err
+	}
+
+	return nativeBoolToBooleanObject(indexOf(arr.Elements(), args[1]) >= 0)
+}
+
+// index_of(arr, x) is the index of the first element equal to x, or -1
+func builtinIndexOf(env *object.Environment, args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=2", len(args))
+	}
+
+	arr, err := arrayArg("index_of", args, 0)
+	if err != nil {
+		return err
+	}
+
+	return &object.Integer{Value: int64(indexOf(arr.Elements(), args[1]))}
+}
+
+// zip(a, b, ...) returns [[a[0], b[0], ...], ...], as long as the shortest array
+func builtinZip(env *object.Environment, args ...object.Object) object.Object {
+	if len(args) == 0 {
+		return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments. got=0, want at least 1")
+	}
+
+	arrays := make([]*object.Array, len(args))
+	shortest := -1
+	for i := range args {
+		arr, err := arrayArg("zip", args, i)
+		if err != nil {
+			return err
+		}
+
+		arrays[i] = arr
+		if shortest < 0 || arr.Len() < shortest {
+			shortest = arr.Len()
+		}
+	}
+
+	result := make([]object.Object, shortest)
+	for i := 0; i < shortest; i++ {
+		tuple := make([]object.Object, len(arrays))
+		for j, arr := range arrays {
+			tuple[j] = arr.At(i)
+		}
+
+		result[i] = object.NewArray(tuple)
+	}
+
+	return object.NewArray(result)
+}
+
+// flatten(arr) removes one level of nesting: [1, [2, 3], [[4]]] => [1, 2, 3, [4]]
+func builtinFlatten(env *object.Environment, args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	arr, err := arrayArg("flatten", args, 0)
+	if err != nil {
+		return err
+	}
+
+	result := []object.Object{}
+	for _, el := range arr.Elements() {
+		if inner, ok := el.(*object.Array); ok {
+			result = append(result, inner.Elements()...)
+		} else {
+			result = append(result, el)
+		}
+	}
+
+	return object.NewArray(result)
+}
+
+// range(end), range(start, end) or range(start, end, step), end is exclusive
+func builtinRange(env *object.Environment, args ...object.Object) object.Object {
+	if len(args) < 1 || len(args) > 3 {
+		return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1, 2 or 3", len(args))
+	}
+
+	bounds := make([]int64, len(args))
+	for i := range args {
+		v, err := integerArg("range", args, i)
+		if err != nil {
+			return err
+		}
+		bounds[i] = v
+	}
+
+	var start, end, step int64 = 0, bounds[0], 1
+	if len(bounds) > 1 {
+		start, end = bounds[0], bounds[1]
+	}
+	if len(bounds) > 2 {
+		step = bounds[2]
+	}
+
+	if step == 0 {
+		return newError("range step must not be zero")
+	}
+
+	n := rangeLength(start, end, step)
+	if n > maxRangeLength {
+		return newKindError(object.ARGUMENT_ERROR, "range(%d, %d, %d) is too large, it has more than %d elements", start, end, step, maxRangeLength)
+	}
+
+	result := make([]object.Object, n)
+	for i := range result {
+		result[i] = &object.Integer{Value: start}
+		start += step
+	}
+
+	return object.NewArray(result)
+}
+
+// maxRangeLength is the most elements range makes
+const maxRangeLength = 1 << 24
+
+// rangeLength is the number of elements of range(start, end, step), step is not zero.
+// It is counted in uint64, where end - start and -step do not overflow.
+func rangeLength(start, end, step int64) uint64 {
+	var distance, stride uint64
+	switch {
+	case step > 0 && start < end:
+		distance, stride = uint64(end)-uint64(start), uint64(step)
+	case step < 0 && start > end:
+		distance, stride = uint64(start)-uint64(end), -uint64(step)
+	default:
+		return 0
+	}
+
+	return (distance-1)/stride + 1
+}
+
+// anyOrAll is any(arr, fn) when want is true, all(arr, fn) when want is false:
+// stop at the first element whose truthiness is want.
+// Without fn the elements themselves are tested.
+func anyOrAll(env *object.Environment, name string, want bool, args []object.Object) object.Object {
+	if len(args) != 1 && len(args) != 2 {
+		return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1 or 2", len(args))
+	}
+
+	arr, err := arrayArg(name, args, 0)
+	if err != nil {
+		return err
+	}
+
+	for _, el := range arr.Elements() {
+		result := el
+		if len(args) == 2 {
+			result = applyFunction(env, args[1], []object.Object{el})
+			if isError(result) {
+				return result
+			}
+		}
+
+		if isTruthy(result) == want {
+			return nativeBoolToBooleanObject(want)
+		}
+	}
+
+	return nativeBoolToBooleanObject(!want)
+}
+
+func builtinAny(env *object.Environment, args ...object.Object) object.Object {
+	return anyOrAll(env, "any", true, args)
+}
+
+func builtinAll(env *object.Environment, args ...object.Object) object.Object {
+	return anyOrAll(env, "all", false, args)
+}
+
+// unique(arr) drops repeated elements, keeping the first occurrence
+func builtinUnique(env *object.Environment, args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	arr, err := arrayArg("unique", args, 0)
+	if err != nil {
+		return err
+	}
+
+	// the elements kept so far by hash, the ones with the same hash told apart with Equal;
+	// the elements which can not be hashed are only compared to each other
+	seen := map[object.HashKey][]object.Object{}
+	unhashable := []object.Object{}
+
+	result := []object.Object{}
+	for _, el := range arr.Elements() {
+		key, ok := object.AsHashable(el)
+		if !ok {
+			if indexOf(unhashable, el) < 0 {
+				unhashable = append(unhashable, el)
+				result = append(result, el)
+			}
+			continue
+		}
+
+		hash := key.GetHash()
+		if indexOf(seen[hash], el) < 0 {
+			seen[hash] = append(seen[hash], el)
+			result = append(result, el)
+		}
+	}
+
+	return object.NewArray(result)
+}
+
+func builtinSum(env *object.Environment, args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	arr, err := arrayArg("sum", args, 0)
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, el := range arr.Elements() {
+		i, ok := el.(*object.Integer)
+		if !ok {
+			return newKindError(object.TYPE_ERROR, "sum needs all INTEGER, got %s", el.Type())
+		}
+		total += i.Value
+	}
+
+	return &object.Integer{Value: total}
+}
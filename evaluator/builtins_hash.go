@@ -0,0 +1,138 @@
+package evaluator
+
+import "xmonkey/object"
+
+// the hash library, registered the same way as the array library.
+// None of them changes its argument, delete and merge return a new hash.
+func init() {
+	hashBuiltins := map[string]object.BuiltinFunc{
+		"keys":   builtinKeys,
+		"values": builtinValues,
+		"has":    builtinHas,
+		"delete": builtinDelete,
+		"merge":  builtinMerge,
+	}
+
+	for name, fn := range hashBuiltins {
+		builtins[name] = &object.Builtin{Fn: fn}
+	}
+}
+
+func hashArg(name string, args []object.Object, idx int) (*object.Hash, *object.Error) {
+	hash, ok := args[idx].(*object.Hash)
+	if !ok {
+		return nil, newKindError(object.TYPE_ERROR, "argument to %s must be HASH, got %s", name, args[idx].Type())
+	}
+
+	return hash, nil
+}
+
+func hashKeyArg(args []object.Object, idx int) (object.Hashable, *object.Error) {
+	key, ok := object.AsHashable(args[idx])
+	if !ok {
+		return nil, newKindError(object.TYPE_ERROR, "unusable as hash key: %s", args[idx].Type())
+	}
+
+	return key, nil
+}
+
+// keys(h) lists the keys in insertion order
+func builtinKeys(env *object.Environment, args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	hash, err := hashArg("keys", args, 0)
+	if err != nil {
+		return err
+	}
+
+	keys := []object.Object{}
+	for _, pair := range hash.OrderedPairs() {
+		keys = append(keys, pair.Key)
+	}
+
+	return object.NewArray(keys)
+}
+
+// values(h) lists the values in insertion order
+func builtinValues(env *object.Environment, args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	hash, err := hashArg("values", args, 0)
+	if err != nil {
+		return err
+	}
+
+	values := []object.Object{}
+	for _, pair := range hash.OrderedPairs() {
+		values = append(values, pair.Value)
+	}
+
+	return object.NewArray(values)
+}
+
+func builtinHas(env *object.Environment, args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=2", len(args))
+	}
+
+	hash, err := hashArg("has", args, 0)
+	if err != nil {
+		return err
+	}
+
+	key, err := hashKeyArg(args, 1)
+	if err != nil {
+		return err
+	}
+
+	_, ok := hash.Get(key)
+	return nativeBoolToBooleanObject(ok)
+}
+
+// delete(h, k) returns h without k
+func builtinDelete(env *object.Environment, args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=2", len(args))
+	}
+
+	hash, err := hashArg("delete", args, 0)
+	if err != nil {
+		return err
+	}
+
+	key, err := hashKeyArg(args, 1)
+	if err != nil {
+		return err
+	}
+
+	result := hash.Copy()
+	result.Delete(key)
+
+	return result
+}
+
+// merge(a, b, ...) returns a hash with the pairs of all arguments,
+// a later hash wins for the value, the first one wins for the position.
+func builtinMerge(env *object.Environment, args ...object.Object) object.Object {
+	if len(args) == 0 {
+		return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments. got=0, want at least 1")
+	}
+
+	result := object.NewHash()
+	for i := range args {
+		hash, err := hashArg("merge", args, i)
+		if err != nil {
+			return err
+		}
+
+		for _, pair := range hash.OrderedPairs() {
+			result.Set(pair.Key.(object.Hashable), pair.Value)
+		}
+	}
+
+	return result
+}
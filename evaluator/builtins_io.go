@@ -0,0 +1,226 @@
+package evaluator
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"xmonkey/object"
+)
+
+// Sandbox is what the file builtins are allowed to touch: only the files under Root.
+// Paths from scripts are always taken relative to Root, ".." can not climb out of it,
+// and neither can a symlink under Root which points outside.
+// ReadOnly keeps read_file and list_dir but disables write_file.
+type Sandbox struct {
+	Root     string
+	ReadOnly bool
+}
+
+func init() {
+	ioBuiltins := map[string]object.BuiltinFunc{
+		"puts":       builtinPuts,
+		"print":      builtinPrint,
+		"readline":   builtinReadline,
+		"input":      builtinInput,
+		"read_file":  builtinReadFile,
+		"write_file": builtinWriteFile,
+		"list_dir":   builtinListDir,
+	}
+
+	for name, fn := range ioBuiltins {
+		builtins[name] = &object.Builtin{Fn: fn}
+	}
+}
+
+// puts(a, b, ...) writes every argument on its own line
+func builtinPuts(env *object.Environment, args ...object.Object) object.Object {
+	for _, arg := range args {
+		if _, err := fmt.Fprintln(contextOf(env).Output, arg.Inspect()); err != nil {
+			return newKindError(object.IO_ERROR, "puts: %s", err)
+		}
+	}
+
+	return NULL
+}
+
+// print(a, b, ...) writes the arguments separated by a space, without newline
+func builtinPrint(env *object.Environment, args ...object.Object) object.Object {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		parts[i] = arg.Inspect()
+	}
+
+	if _, err := io.WriteString(contextOf(env).Output, strings.Join(parts, " ")); err != nil {
+		return newKindError(object.IO_ERROR, "print: %s", err)
+	}
+
+	return NULL
+}
+
+// readLine returns the next line without the line break, NULL at the end of input
+func readLine(env *object.Environment) object.Object {
+	line, err := contextOf(env).Input.ReadString('\n')
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			return newKindError(object.IO_ERROR, "readline: %s", err)
+		}
+
+		// the last line may have no line break
+		if line == "" {
+			return NULL
+		}
+	}
+
+	line = strings.TrimSuffix(line, "\n")
+	line = strings.TrimSuffix(line, "\r")
+
+	return &object.String{Value: line}
+}
+
+func builtinReadline(env *object.Environment, args ...object.Object) object.Object {
+	if len(args) != 0 {
+		return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=0", len(args))
+	}
+
+	return readLine(env)
+}
+
+// input(prompt) writes prompt, then reads a line
+func builtinInput(env *object.Environment, args ...object.Object) object.Object {
+	if len(args) > 1 {
+		return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=0 or 1", len(args))
+	}
+
+	if len(args) == 1 {
+		if _, err := io.WriteString(contextOf(env).Output, args[0].Inspect()); err != nil {
+			return newKindError(object.IO_ERROR, "input: %s", err)
+		}
+	}
+
+	return readLine(env)
+}
+
+// sandboxPath maps the path given by a script to a file inside the sandbox
+func sandboxPath(env *object.Environment, name string, args []object.Object, idx int, write bool) (string, *object.Error) {
+	sandbox := contextOf(env).Sandbox
+	if sandbox == nil {
+		return "", newKindError(object.IO_ERROR, "%s: file access is disabled", name)
+	}
+
+	if write && sandbox.ReadOnly {
+		return "", newKindError(object.IO_ERROR, "%s: file access is read only", name)
+	}
+
+	path, ok := args[idx].(*object.String)
+	if !ok {
+		return "", newKindError(object.TYPE_ERROR, "argument to %s must be STRING, got %s", name, args[idx].Type())
+	}
+
+	// cleaning it as an absolute path drops every leading "..", so it stays under Root
+	cleaned := filepath.Clean(string(filepath.Separator) + path.Value)
+	joined := filepath.Join(sandbox.Root, cleaned)
+
+	// the file the symlinks lead to has to be under Root as well
+	root, resolveErr := filepath.EvalSymlinks(sandbox.Root)
+	if resolveErr != nil {
+		return "", newKindError(object.IO_ERROR, "%s: %s", name, resolveErr)
+	}
+
+	resolved, resolveErr := filepath.EvalSymlinks(joined)
+	if resolveErr != nil && write && os.IsNotExist(resolveErr) {
+		// a new file, its directory has to be in the sandbox;
+		// a symlink to nowhere is not a new file, writing it would create its target wherever that is
+		if _, statErr := os.Lstat(joined); statErr == nil {
+			return "", newKindError(object.IO_ERROR, "%s: %s is a link to a missing file", name, path.Value)
+		}
+
+		var dir string
+		dir, resolveErr = filepath.EvalSymlinks(filepath.Dir(joined))
+		resolved = filepath.Join(dir, filepath.Base(joined))
+	}
+	if resolveErr != nil {
+		return "", newKindError(object.IO_ERROR, "%s: %s", name, resolveErr)
+	}
+
+	if rel, relErr := filepath.Rel(root, resolved); relErr != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", newKindError(object.IO_ERROR, "%s: %s is outside the sandbox", name, path.Value)
+	}
+
+	return resolved, nil
+}
+
+func builtinReadFile(env *object.Environment, args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	path, err := sandboxPath(env, "read_file", args, 0, false)
+	if err != nil {
+		return err
+	}
+
+	content, readErr := os.ReadFile(path)
+	if readErr != nil {
+		return newKindError(object.IO_ERROR, "read_file: %s", readErr)
+	}
+
+	return &object.String{Value: string(content)}
+}
+
+// write_file(path, content) replaces the file with content
+func builtinWriteFile(env *object.Environment, args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=2", len(args))
+	}
+
+	path, err := sandboxPath(env, "write_file", args, 0, true)
+	if err != nil {
+		return err
+	}
+
+	content, ok := args[1].(*object.String)
+	if !ok {
+		return newKindError(object.TYPE_ERROR, "argument to write_file must be STRING, got %s", args[1].Type())
+	}
+
+	if writeErr := os.WriteFile(path, []byte(content.Value), 0644); writeErr != nil {
+		return newKindError(object.IO_ERROR, "write_file: %s", writeErr)
+	}
+
+	return NULL
+}
+
+// list_dir(path) returns the sorted names in the directory
+func builtinListDir(env *object.Environment, args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	path, err := sandboxPath(env, "list_dir", args, 0, false)
+	if err != nil {
+		return err
+	}
+
+	entries, readErr := os.ReadDir(path)
+	if readErr != nil {
+		return newKindError(object.IO_ERROR, "list_dir: %s", readErr)
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+
+	elements := make([]object.Object, len(names))
+	for i, name := range names {
+		elements[i] = &object.String{Value: name}
+	}
+
+	return object.NewArray(elements)
+}
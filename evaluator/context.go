@@ -0,0 +1,105 @@
+package evaluator
+
+import (
+	"bufio"
+	"io"
+	"os"
+
+	"xmonkey/ast"
+	"xmonkey/object"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// the state of an evaluation
+// Context is what one evaluation keeps besides the names: the calls being evaluated, for the traces
+// of the errors, and where the io builtins read and write.
+// WithContext puts it on the env given to Eval, the envs made from that one share it,
+// so evaluations in different contexts can run at the same time.
+
+type Context struct {
+	// where puts and print write to
+	Output io.Writer
+	// where readline and input read from
+	Input *bufio.Reader
+	// what the file builtins may touch, file access is off while it is nil
+	Sandbox *Sandbox
+	// the scoping the program was resolved with
+	Scoping
+
+	// the calls being evaluated, the innermost last
+	calls []object.Frame
+}
+
+// NewContext writes to stdout and reads from stdin, without file access
+func NewContext() *Context {
+	return &Context{Output: os.Stdout, Input: bufio.NewReader(os.Stdin)}
+}
+
+// WithContext makes the code evaluated in env, and in the envs made from it afterwards, use ctx; it returns env
+func WithContext(env *object.Environment, ctx *Context) *object.Environment {
+	env.SetContext(ctx)
+	return env
+}
+
+// defaultContext is for the envs without a context, the one SetOutput, SetInput and SetSandbox change.
+// All those evaluations share it, they must not run at the same time.
+var defaultContext = NewContext()
+
+func contextOf(env *object.Environment) *Context {
+	if env != nil {
+		if ctx, ok := env.Context().(*Context); ok {
+			return ctx
+		}
+	}
+	return defaultContext
+}
+
+// SetOutput sets where puts and print write to, for the evaluations without a Context.
+func SetOutput(w io.Writer) {
+	defaultContext.Output = w
+}
+
+// SetInput sets where readline and input read from, for the evaluations without a Context.
+// If r is a *bufio.Reader it is used as it is, so the caller can keep reading from it too.
+func SetInput(r io.Reader) {
+	defaultContext.Input = bufio.NewReader(r)
+}
+
+// SetSandbox enables the file builtins inside s, nil disables them, for the evaluations without a Context.
+func SetSandbox(s *Sandbox) {
+	defaultContext.Sandbox = s
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// the call stack, for the traces of the errors
+// An error does not get its trace where it is made (newError does not know the evaluation),
+// but when it first leaves a call or is caught: the stack is still the one it was made in then.
+
+func (c *Context) pushFrame(call *ast.CallExpression, callee object.Object) {
+	// the name of the fn, or the name it was called by: add(1, 2), but not fn(x) { x }(1) or fns[0](1)
+	name := "<anonymous>"
+	if fn, ok := callee.(*object.Function); ok && fn.Name != "" {
+		name = fn.Name
+	} else if ident, ok := call.CallableName.(*ast.Identifier); ok {
+		name = ident.Name
+	}
+
+	c.calls = append(c.calls, object.Frame{Function: name, Line: call.Token.Line, Column: call.Token.Column})
+}
+
+func (c *Context) popFrame() {
+	c.calls = c.calls[:len(c.calls)-1]
+}
+
+// trace gives result the stack, the innermost call first, if it is an error which has no trace yet
+func (c *Context) trace(result object.Object) {
+	errObj, ok := result.(*object.Error)
+	if !ok || errObj.Trace != nil || len(c.calls) == 0 {
+		return
+	}
+
+	errObj.Trace = make([]object.Frame, len(c.calls))
+	for i, frame := range c.calls {
+		errObj.Trace[len(c.calls)-1-i] = frame
+	}
+}
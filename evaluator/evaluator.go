@@ -2,6 +2,7 @@ package evaluator
 
 import (
 	"fmt"
+	"unicode/utf8"
 
 	"xmonkey/ast"
 	"xmonkey/object"
@@ -22,7 +23,11 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return evalProgram(node, env)
 
 	case *ast.BlockStatement:
-		return evalBlockStatement(node, env)
+		if contextOf(env).LeakBlockScope {
+			return evalBlockStatement(node, env)
+		}
+		// the lets of the block end with it
+		return evalBlockStatement(node, object.NewSlottedEnv(env, node.Slots))
 
 	case *ast.ReturnStatement:
 		val := Eval(node.Expr, env)
@@ -39,14 +44,26 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return val
 		}
 
+		// let [a, b] = val; binds every name of the pattern
+		if node.Pattern != nil {
+			return destructure(node.Pattern, val, env)
+		}
+
 		// save the identifier to env
-		env.Set(node.Name.Name, val)
+		bind(env, node.Name, val)
 
 		return nil
 
 	case *ast.ExpressionStatement:
 		return Eval(node.Expr, env)
 
+	case *ast.ThrowStatement:
+		val := Eval(node.Expr, env)
+		if isError(val) {
+			return val
+		}
+		return throwValue(val)
+
 	case *ast.PrefixExpression:
 		// there could be many prefix op, however, here is only for only for ! -,
 		// the ast.node is returned by parsePrefixExpression in parser.go
@@ -59,6 +76,11 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.InfixExpression:
 		// ast.InfixExpression is from registerPrefix, here is +-*/ == !=
 		// not include function call, which is also infix op but with different parsefn
+		if node.Operator == "&&" || node.Operator == "||" || node.Operator == "??" {
+			// right side may not be evaluated at all
+			return evalLogicalExpression(node, env)
+		}
+
 		left := Eval(node.Left, env)
 		if isError(left) {
 			return left
@@ -75,6 +97,11 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		// eval will eventually goto the evalIdentifier,
 		// which will return object.Function(the concrete struct pointer of Object interface ) from store
 		// or builtin func in the builtins map
+		// quote(expr) is not a call at all, expr is not evaluated
+		if isCallTo(node, "quote") {
+			return quote(node, env)
+		}
+
 		fun := Eval(node.CallableName, env)
 		if isError(fun) {
 			return fun
@@ -87,7 +114,14 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 
 		// fun will have 2 types: object.Function or object.Builtin
-		return applyFunction(fun, actualParams)
+		// the call is on the stack while it runs, so an error raised inside knows where it came from
+		ctx := contextOf(env)
+		ctx.pushFrame(node, fun)
+		result := applyFunction(env, fun, actualParams)
+		ctx.trace(result)
+		ctx.popFrame()
+
+		return result
 
 	case *ast.Identifier:
 		// lookup from env
@@ -99,6 +133,11 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return left
 		}
 
+		// h?.["k"] stops at null, the index is not evaluated
+		if node.Optional && left == NULL {
+			return NULL
+		}
+
 		index := Eval(node.Index, env)
 		if isError(index) {
 			return index
@@ -106,10 +145,30 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 
 		return evalIndexExpresson(left, index)
 
+	case *ast.SliceExpression:
+		return evalSliceExpression(node, env)
+
 	// below can only appear on the right side of assignment =
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
 
+	case *ast.ConditionalExpression:
+		cond := Eval(node.Condition, env)
+		if isError(cond) {
+			return cond
+		}
+
+		if isTruthy(cond) {
+			return Eval(node.Consequence, env)
+		}
+		return Eval(node.Alternative, env)
+
+	case *ast.MatchExpression:
+		return evalMatchExpression(node, env)
+
+	case *ast.TryExpression:
+		return evalTryExpression(node, env)
+
 	case *ast.IntegerLiteral:
 		// returned is struct pointer, which implements the object.Object interface
 		return &object.Integer{Value: node.Value}
@@ -129,7 +188,24 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		// when define fn, there is no name for the fn, so no need to save to env.
 		// However, need bind the env to fn, which will used during the call (closure)
 		// no eval here, only return executable object.
-		return &object.Function{FormalParams: params, EnvWhenDefined: env, Body: body}
+		if node.Name == nil {
+			return &object.Function{FormalParams: params, EnvWhenDefined: env, Body: body}
+		}
+
+		// let fact = fn f(n) { ... f(n - 1) }, f is only seen by the fn itself
+		// (a declaration fn f(n) { } as a statement is bound by hoistFunctions instead)
+		fnEnv := object.NewEnclosedEnv(env)
+		if node.Name.Slot > 0 {
+			fnEnv = object.NewSlottedEnv(env, []string{node.Name.Name})
+		}
+		fn := &object.Function{Name: node.Name.Name, FormalParams: params, EnvWhenDefined: fnEnv, Body: body}
+		bind(fnEnv, node.Name, fn)
+
+		return fn
+
+	case *ast.MacroLiteral:
+		// DefineMacros takes the macros out of the program before it runs, those left are in the wrong place
+		return newError("macro can only be defined by a let at the top level")
 
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, env)
@@ -137,7 +213,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return elements[0]
 		}
 
-		return &object.Array{Elements: elements}
+		return object.NewArray(elements)
 
 	case *ast.HashLiteral:
 		return evalHashLiteral(node, env)
@@ -151,8 +227,10 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	var result object.Object
 
+	hoisted := hoistFunctions(program.Statements, env)
+
 	for _, stmt := range program.Statements {
-		result = Eval(stmt, env)
+		result = evalStatement(stmt, env, hoisted)
 
 		// will return for the first return or error
 		switch result := result.(type) {
@@ -169,8 +247,10 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
 	var result object.Object
 
+	hoisted := hoistFunctions(block.Statements, env)
+
 	for _, stmt := range block.Statements {
-		result = Eval(stmt, env)
+		result = evalStatement(stmt, env, hoisted)
 
 		if result != nil {
 			rt := result.Type()
@@ -183,6 +263,57 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 	return result
 }
 
+// functionDeclaration returns the fn of a statement like fn add(a, b) { a + b }
+func functionDeclaration(stmt ast.Statement) (*ast.FunctionLiteral, bool) {
+	exprStmt, ok := stmt.(*ast.ExpressionStatement)
+	if !ok {
+		return nil, false
+	}
+
+	fn, ok := exprStmt.Expr.(*ast.FunctionLiteral)
+	if !ok || fn.Name == nil {
+		return nil, false
+	}
+
+	return fn, true
+}
+
+// hoistFunctions binds the declared functions before any statement runs,
+// so they can call each other no matter in which order they are declared.
+// The result maps each declaration to its function.
+func hoistFunctions(stmts []ast.Statement, env *object.Environment) map[ast.Statement]*object.Function {
+	var hoisted map[ast.Statement]*object.Function
+
+	for _, stmt := range stmts {
+		if fn, ok := functionDeclaration(stmt); ok {
+			if hoisted == nil {
+				hoisted = map[ast.Statement]*object.Function{}
+			}
+
+			hoisted[stmt] = &object.Function{
+				Name:           fn.Name.Name,
+				FormalParams:   fn.FormalParams,
+				EnvWhenDefined: env,
+				Body:           fn.Body,
+			}
+			bind(env, fn.Name, hoisted[stmt])
+		}
+	}
+
+	return hoisted
+}
+
+// evalStatement is Eval, except a declaration binds (again) the function made by hoistFunctions
+func evalStatement(stmt ast.Statement, env *object.Environment, hoisted map[ast.Statement]*object.Function) object.Object {
+	if fn, ok := hoisted[stmt]; ok {
+		decl, _ := functionDeclaration(stmt)
+		bind(env, decl.Name, fn)
+		return fn
+	}
+
+	return Eval(stmt, env)
+}
+
 func evalStatements(stmts []ast.Statement, env *object.Environment) object.Object {
 	var result object.Object
 
@@ -214,8 +345,10 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 		return evalBangOperator(right)
 	case "-":
 		return evalMinusPrefixOperator(right)
+	case "~":
+		return evalTildePrefixOperator(right)
 	default:
-		return newError("unknown operator: %s%s", operator, right.Type())
+		return newKindError(object.TYPE_ERROR, "unknown operator: %s%s", operator, right.Type())
 	}
 }
 
@@ -239,7 +372,7 @@ func evalMinusPrefixOperator(right object.Object) object.Object {
 	}
 
 	if right.Type() != object.INTEGER_OBJ {
-		return newError("unknown operator: -%s", right.Type())
+		return newKindError(object.TYPE_ERROR, "unknown operator: -%s", right.Type())
 
 	}
 
@@ -252,23 +385,58 @@ func evalMinusPrefixOperator(right object.Object) object.Object {
 
 }
 
+// ~5 flips all the bits, only for integers
+func evalTildePrefixOperator(right object.Object) object.Object {
+	i, ok := right.(*object.Integer)
+	if !ok {
+		return newKindError(object.TYPE_ERROR, "unknown operator: ~%s", right.Type())
+	}
+
+	return &object.Integer{Value: ^i.Value}
+}
+
 func evalInfixExpression(op string, left, right object.Object) object.Object {
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfix(op, left, right)
 	case op == "==":
-		return nativeBoolToBooleanObject(left == right)
+		// value semantics, "a" == "a" and [1, [2]] == [1, [2]]
+		return nativeBoolToBooleanObject(object.Equal(left, right))
 	case op == "!=":
-		return nativeBoolToBooleanObject(left != right)
+		return nativeBoolToBooleanObject(!object.Equal(left, right))
 	case left.Type() != right.Type():
-		return newError("type mismatch: %s %s %s", left.Type(), op, right.Type())
+		return newKindError(object.TYPE_ERROR, "type mismatch: %s %s %s", left.Type(), op, right.Type())
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(op, left, right)
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), op, right.Type())
+		return newKindError(object.TYPE_ERROR, "unknown operator: %s %s %s", left.Type(), op, right.Type())
 	}
 }
 
+// && and || short-circuit and return the operand that decided the result, not a boolean:
+// 0 || "x" is 0 (0 is truthy), null || "x" is "x", null && f() is null and f is never called.
+// a ?? b is like ||, but only null (not false) makes it take b.
+func evalLogicalExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	if node.Operator == "??" && left != NULL {
+		return left
+	}
+
+	if node.Operator == "&&" && !isTruthy(left) {
+		return left
+	}
+
+	if node.Operator == "||" && isTruthy(left) {
+		return left
+	}
+
+	return Eval(node.Right, env)
+}
+
 func evalIntegerInfix(op string, left, right object.Object) object.Object {
 	leftVal := left.(*object.Integer).Value
 	rightVal := right.(*object.Integer).Value
@@ -281,20 +449,65 @@ func evalIntegerInfix(op string, left, right object.Object) object.Object {
 	case "*":
 		return &object.Integer{Value: leftVal * rightVal}
 	case "/":
+		if rightVal == 0 {
+			return newKindError(object.ZERO_DIVISION_ERROR, "division by zero: %d / 0", leftVal)
+		}
 		return &object.Integer{Value: leftVal / rightVal}
+	case "%":
+		if rightVal == 0 {
+			return newKindError(object.ZERO_DIVISION_ERROR, "division by zero: %d %% 0", leftVal)
+		}
+		return &object.Integer{Value: leftVal % rightVal}
+	case "**":
+		if rightVal < 0 {
+			return newKindError(object.ARGUMENT_ERROR, "negative exponent: %d ** %d", leftVal, rightVal)
+		}
+		return &object.Integer{Value: integerPower(leftVal, rightVal)}
+	case "&":
+		return &object.Integer{Value: leftVal & rightVal}
+	case "|":
+		return &object.Integer{Value: leftVal | rightVal}
+	case "^":
+		return &object.Integer{Value: leftVal ^ rightVal}
+	case "<<", ">>":
+		if rightVal < 0 || rightVal >= 64 {
+			return newKindError(object.ARGUMENT_ERROR, "invalid shift count: %d %s %d", leftVal, op, rightVal)
+		}
+		if op == "<<" {
+			return &object.Integer{Value: leftVal << uint(rightVal)}
+		}
+		return &object.Integer{Value: leftVal >> uint(rightVal)}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
 		return nativeBoolToBooleanObject(leftVal != rightVal)
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), op, right.Type())
+		return newKindError(object.TYPE_ERROR, "unknown operator: %s %s %s", left.Type(), op, right.Type())
 	}
 }
 
+// integerPower is base ** exp by squaring, exp is not negative. It wraps around on overflow like * does.
+func integerPower(base, exp int64) int64 {
+	result := int64(1)
+	for exp > 0 {
+		if exp&1 == 1 {
+			result *= base
+		}
+		base *= base
+		exp >>= 1
+	}
+
+	return result
+}
+
 func evalIfExpression(expr *ast.IfExpression, env *object.Environment) object.Object {
 	cond := Eval(expr.Condition, env)
 	if isError(cond) {
@@ -324,7 +537,12 @@ func isTruthy(obj object.Object) bool {
 }
 
 func newError(format string, a ...interface{}) *object.Error {
-	return &object.Error{Message: fmt.Sprintf(format, a...)}
+	return newKindError(object.RUNTIME_ERROR, format, a...)
+}
+
+// newKindError is newError with the kind a script can catch by
+func newKindError(kind, format string, a ...interface{}) *object.Error {
+	return &object.Error{Kind: kind, Message: fmt.Sprintf(format, a...)}
 }
 
 func isError(obj object.Object) bool {
@@ -336,8 +554,8 @@ func isError(obj object.Object) bool {
 }
 
 func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
-	// get value from env
-	if val, ok := env.Get(node.Name); ok {
+	// get value from env, from its slot if the program is resolved
+	if val, ok := lookup(env, node); ok {
 		return val
 	}
 
@@ -345,7 +563,7 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 		return builtin
 	}
 
-	return newError("identifier not found: %s", node.Name)
+	return newKindError(object.NAME_ERROR, "identifier not found: %s", node.Name)
 }
 
 // eval for each expr in the exps
@@ -364,39 +582,52 @@ func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Ob
 	return result
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// env is the env of the call, a builtin gets it for the Context
+func applyFunction(env *object.Environment, fn object.Object, args []object.Object) object.Object {
 	switch fun := fn.(type) {
 	case *object.Function:
 		// let foo = fn(a,b) { a + b}
 		// when eval this letStatement, will build the env: foo is the key, the value is &object.Function(see eval case for FunctionLiteral)
 		// when eval foo(2, 3), foo is the identifier, which eval in env (see eval case for CallExpression), and
 		// the result is the Function saved by letStatement
-		extendedEnv := createCallEnv(fun, args)
-		evaluated := Eval(fun.Body, extendedEnv)
+		if len(args) != len(fun.FormalParams) {
+			return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=%d", len(args), len(fun.FormalParams))
+		}
+
+		extendedEnv, err := createCallEnv(fun, args)
+		if err != nil {
+			return err
+		}
+		// the call env is already the scope of the body
+		evaluated := evalBlockStatement(fun.Body, extendedEnv)
 		return unwrapReturnValue(evaluated)
 
 	case *object.Builtin:
 		// returned from evalIdentifier
 		// Fn is func in golang, and will not be evaled, in the definition of Fn, there is no closure.
-		// so there is no env here, all infos should passed through the args, which will be evaled in the env
-		return fun.Fn(args...)
+		// all infos should passed through the args, which will be evaled in the env;
+		// env itself is only for the Context (where puts writes, ...)
+		return fun.Fn(env, args...)
 
 	default:
-		return newError("not a function: %s", fn.Type())
+		return newKindError(object.TYPE_ERROR, "not a function: %s", fn.Type())
 	}
 
 }
 
-func createCallEnv(fn *object.Function, args []object.Object) *object.Environment {
+func createCallEnv(fn *object.Function, args []object.Object) (*object.Environment, object.Object) {
 	// create call env(new env) based on fn define env (old env)
-	env := object.NewEnclosedEnv(fn.EnvWhenDefined)
+	env := object.NewSlottedEnv(fn.EnvWhenDefined, fn.Body.Slots)
 
 	// setup the new env(call env), name is from fn definition's params' name, value is evaled args' values
+	// a param like [x, y] destructures its arg, and fails the call if the arg has another shape
 	for paramIdx, param := range fn.FormalParams {
-		env.Set(param.Name, args[paramIdx])
+		if err := destructure(param, args[paramIdx], env); err != nil {
+			return nil, err
+		}
 	}
 
-	return env
+	return env, nil
 }
 
 func unwrapReturnValue(obj object.Object) object.Object {
@@ -407,14 +638,25 @@ func unwrapReturnValue(obj object.Object) object.Object {
 	return obj
 }
 
+// strings support + and the lexicographic comparisons, == and != are handled by object.Equal
 func evalStringInfixExpression(op string, left, right object.Object) object.Object {
-	if op != "+" {
-		return newError("unknown operator: %s %s %s", left.Type(), op, right.Type())
-	}
-
 	l := left.(*object.String).Value
 	r := right.(*object.String).Value
-	return &object.String{Value: l + r}
+
+	switch op {
+	case "+":
+		return &object.String{Value: l + r}
+	case "<":
+		return nativeBoolToBooleanObject(l < r)
+	case ">":
+		return nativeBoolToBooleanObject(l > r)
+	case "<=":
+		return nativeBoolToBooleanObject(l <= r)
+	case ">=":
+		return nativeBoolToBooleanObject(l >= r)
+	default:
+		return newKindError(object.TYPE_ERROR, "unknown operator: %s %s %s", left.Type(), op, right.Type())
+	}
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -423,66 +665,146 @@ func evalIndexExpresson(left, index object.Object) object.Object {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(left, index)
 
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalStringIndexExpression(left, index)
+
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
 
 	default:
-		return newError("index operator not supported: %s", left.Type())
+		return newKindError(object.TYPE_ERROR, "index operator not supported: %s", left.Type())
 	}
 }
 
 func evalArrayIndexExpression(array, index object.Object) object.Object {
 	arrayObject := array.(*object.Array)
 	idx := index.(*object.Integer).Value
-	max := int64(len(arrayObject.Elements) - 1)
+	max := int64(arrayObject.Len() - 1)
+
+	// arr[-1] is the last one
+	if idx < 0 {
+		idx += max + 1
+	}
+
+	if idx < 0 || idx > max {
+		return NULL
+	}
+
+	return arrayObject.At(int(idx))
+}
+
+// s[i] is a string of one char, i counts chars (not bytes) like len does
+func evalStringIndexExpression(str, index object.Object) object.Object {
+	value := []rune(str.(*object.String).Value)
+	idx := index.(*object.Integer).Value
+	max := int64(len(value) - 1)
+
+	if idx < 0 {
+		idx += max + 1
+	}
 
 	if idx < 0 || idx > max {
 		return NULL
 	}
 
-	return arrayObject.Elements[idx]
+	return &object.String{Value: string(value[idx])}
+}
+
+// arr[start:end] and s[start:end], end is exclusive, both may be omitted or negative,
+// out of range bounds are clamped instead of failing
+func evalSliceExpression(node *ast.SliceExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	if node.Optional && left == NULL {
+		return NULL
+	}
+
+	var length int64
+	switch left := left.(type) {
+	case *object.Array:
+		length = int64(left.Len())
+	case *object.String:
+		length = int64(utf8.RuneCountInString(left.Value))
+	default:
+		return newKindError(object.TYPE_ERROR, "slice operator not supported: %s", left.Type())
+	}
+
+	bound := func(node ast.Expression, omitted int64) (int64, object.Object) {
+		if node == nil {
+			return omitted, nil
+		}
+
+		value := Eval(node, env)
+		if isError(value) {
+			return 0, value
+		}
+
+		i, ok := value.(*object.Integer)
+		if !ok {
+			return 0, newKindError(object.TYPE_ERROR, "slice index must be INTEGER, got %s", value.Type())
+		}
+		return i.Value, nil
+	}
+
+	start, err := bound(node.Start, 0)
+	if err != nil {
+		return err
+	}
+	end, err := bound(node.End, length)
+	if err != nil {
+		return err
+	}
+
+	start, end = sliceBounds(length, start, end)
+
+	if str, ok := left.(*object.String); ok {
+		return &object.String{Value: string([]rune(str.Value)[start:end])}
+	}
+
+	return left.(*object.Array).Slice(int(start), int(end))
 }
 
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash()
 
-	for keyNode, valueNode := range node.Pairs {
+	// follow the source order, so the hash keeps the order of the literal
+	for _, keyNode := range node.Keys {
 		key := Eval(keyNode, env)
 		if isError(key) {
 			return key
 		}
 
-		hashKey, ok := key.(object.Hashable)
+		hashKey, ok := object.AsHashable(key)
 		if !ok {
-			return newError("unusable as hsh key: %s", key.Type())
+			return newKindError(object.TYPE_ERROR, "unusable as hsh key: %s", key.Type())
 		}
 
-		value := Eval(valueNode, env)
+		value := Eval(node.Pairs[keyNode], env)
 		if isError(value) {
 			return value
 		}
 
-		hashed := hashKey.GetHash()
-		pairs[hashed] = object.HashPair{Key: key, Value: value}
+		hash.Set(hashKey, value)
 	}
 
-	return &object.Hash{Pairs: pairs}
+	return hash
 }
 
 func evalHashIndexExpression(hash, index object.Object) object.Object {
 	hashObject := hash.(*object.Hash)
 
-	key, ok := index.(object.Hashable)
+	key, ok := object.AsHashable(index)
 	if !ok {
-		return newError("unusable as hash key: %s", index.Type())
+		return newKindError(object.TYPE_ERROR, "unusable as hash key: %s", index.Type())
 	}
 
-	// fmt.Printf("%+v\n", hashObject.Pairs)
-
-	pair, ok := hashObject.Pairs[key.GetHash()]
+	value, ok := hashObject.Get(key)
 	if !ok {
 		return NULL
 	}
 
-	return pair.Value
+	return value
 }
@@ -1,8 +1,15 @@
 package evaluator
 
 import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
+	"xmonkey/ast"
 	"xmonkey/lexer"
 	"xmonkey/object"
 	"xmonkey/parser"
@@ -33,6 +40,9 @@ func testEval(input string) object.Object {
 	p := parser.New(l)
 	program := p.ParseProgram()
 
+	// as run and the REPL do, TestResolve checks it gives what the names give
+	Resolve(program, Scoping{})
+
 	env := object.NewEnvironment()
 
 	return Eval(program, env)
@@ -72,6 +82,28 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"(1<2) == false", false},
 		{"(1>2) == true;", false},
 		{"(1>2) == false", true},
+		{"1 <= 1", true},
+		{"2 <= 1", false},
+		{"1 >= 2", false},
+		{"2 >= 2", true},
+		{`"a" == "a"`, true},
+		{`"a" != "a"`, false},
+		{`"a" == "b"`, false},
+		{`"a" < "b"`, true},
+		{`"b" > "ab"`, true},
+		{`"ab" <= "ab"`, true},
+		{`"a" >= "b"`, false},
+		{`1 == "1"`, false},
+		{`1 != "1"`, true},
+		{"[1, 2] == [1, 2]", true},
+		{"[1, [2, 3]] == [1, [2, 3]]", true},
+		{"[1, 2] == [2, 1]", false},
+		{"[1, 2] != [1, 2, 3]", true},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`{"a": 1} != {"b": 1}`, true},
+		{"let f = fn() { 1 }; f == f", true},
+		{"fn() { 1 } == fn() { 1 }", false},
 	}
 
 	for _, tt := range tests {
@@ -176,10 +208,15 @@ func TestErrorHandling(t *testing.T) {
 		{"if (10 > 1) { true - false}", "unknown operator: BOOLEAN - BOOLEAN"},
 		{"foobar", "identifier not found: foobar"},
 		{`"Hello" - "World"`, "unknown operator: STRING - STRING"},
+		{"[1] < [2]", "unknown operator: ARRAY < ARRAY"},
 		{
 			`{"name": "Monkey"}[fn(x) {x}]}`,
 			"unusable as hash key: FUNCTION",
 		},
+		{
+			`{"name": "Monkey"}[[1, fn(x) {x}]]`,
+			"unusable as hash key: ARRAY",
+		},
 	}
 
 	for _, tt := range tests {
@@ -351,13 +388,13 @@ func TestArrayLiterals(t *testing.T) {
 		t.Fatalf("object is not array. got=%T (%+v)", evaluated, evaluated)
 	}
 
-	if len(result.Elements) != 3 {
-		t.Fatalf("array has wrong num of elements. got=%d", len(result.Elements))
+	if result.Len() != 3 {
+		t.Fatalf("array has wrong num of elements. got=%d", result.Len())
 	}
 
-	testIntegerObject(t, result.Elements[0], 1)
-	testIntegerObject(t, result.Elements[1], 4)
-	testIntegerObject(t, result.Elements[2], 6)
+	testIntegerObject(t, result.At(0), 1)
+	testIntegerObject(t, result.At(1), 4)
+	testIntegerObject(t, result.At(2), 6)
 }
 
 func TestArrayIndexExpression(t *testing.T) {
@@ -370,6 +407,9 @@ func TestArrayIndexExpression(t *testing.T) {
 		{"[1,2,3][2]", 3},
 		{"let myArray = [1,2,3]; let b = myArray[0];  myArray[1]; ", 2},
 		{"[1,2,3][3]", nil},
+		{"[1,2,3][-1]", 3},
+		{"[1,2,3][-3]", 1},
+		{"[1,2,3][-4]", nil},
 		{"first([1,2,3])", 1},
 		{"last([1,2,3])", 3},
 		{"let a=3; let b = 4; let f = fn(x, y) { x*y + x + y};  last([1, 3*3, f(a,b)])", 19},
@@ -395,12 +435,12 @@ func TestArrayRest(t *testing.T) {
 		t.Fatalf("object is not array. got=%T (%+v)", evaluated, evaluated)
 	}
 
-	if len(result.Elements) != 2 {
-		t.Fatalf("array has wrong num of elements. got=%d", len(result.Elements))
+	if result.Len() != 2 {
+		t.Fatalf("array has wrong num of elements. got=%d", result.Len())
 	}
 
-	testIntegerObject(t, result.Elements[0], 3)
-	testIntegerObject(t, result.Elements[1], 10)
+	testIntegerObject(t, result.At(0), 3)
+	testIntegerObject(t, result.At(1), 10)
 }
 
 func TestArrayPush(t *testing.T) {
@@ -412,13 +452,13 @@ func TestArrayPush(t *testing.T) {
 		t.Fatalf("object is not array. got=%T (%+v)", evaluated, evaluated)
 	}
 
-	if len(result.Elements) != 3 {
-		t.Fatalf("array has wrong num of elements. got=%d", len(result.Elements))
+	if result.Len() != 3 {
+		t.Fatalf("array has wrong num of elements. got=%d", result.Len())
 	}
 
-	testIntegerObject(t, result.Elements[0], 3)
-	testIntegerObject(t, result.Elements[1], 10)
-	testIntegerObject(t, result.Elements[2], 99)
+	testIntegerObject(t, result.At(0), 3)
+	testIntegerObject(t, result.At(1), 10)
+	testIntegerObject(t, result.At(2), 99)
 }
 
 func TestHash1(t *testing.T) {
@@ -440,26 +480,30 @@ false: 6
 		t.Fatalf("Eval didn't return Hash. got=%T (%+v)", evaluated, evaluated)
 	}
 
-	expected := map[object.HashKey]int64{
-		(&object.String{Value: "one"}).GetHash():   1,
-		(&object.String{Value: "two"}).GetHash():   2,
-		(&object.String{Value: "three"}).GetHash(): 3,
-		(&object.Integer{Value: 4}).GetHash():      4,
-		TRUE.GetHash():                             5,
-		FALSE.GetHash():                            6,
+	expected := []struct {
+		key   object.Hashable
+		value int64
+	}{
+		{&object.String{Value: "one"}, 1},
+		{&object.String{Value: "two"}, 2},
+		{&object.String{Value: "three"}, 3},
+		{&object.Integer{Value: 4}, 4},
+		{TRUE, 5},
+		{FALSE, 6},
 	}
 
-	if len(result.Pairs) != len(expected) {
-		t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
+	if result.Len() != len(expected) {
+		t.Fatalf("Hash has wrong num of pairs. got=%d", result.Len())
 	}
 
-	for expectedKey, expectedValue := range expected {
-		pair, ok := result.Pairs[expectedKey]
+	for _, tt := range expected {
+		value, ok := result.Get(tt.key)
 		if !ok {
-			t.Errorf("no pair for given key in Pairs")
+			t.Errorf("no pair for given key %s", tt.key.Inspect())
+			continue
 		}
 
-		testIntegerObject(t, pair.Value, expectedValue)
+		testIntegerObject(t, value, tt.value)
 	}
 }
 
@@ -496,6 +540,22 @@ func TestHashIndex(t *testing.T) {
 			`{true: 7}[true]`,
 			7,
 		},
+		{
+			`{[1, "a"]: 8}[[1, "a"]]`,
+			8,
+		},
+		{
+			`{[1, [2, 3]]: 9}[[1, [2, 3]]]`,
+			9,
+		},
+		{
+			`{[1, 2]: 9}[[2, 1]]`,
+			nil,
+		},
+		{
+			`{[]: 10}[[]]`,
+			10,
+		},
 	}
 
 	for _, tt := range tests {
@@ -508,3 +568,961 @@ func TestHashIndex(t *testing.T) {
 		}
 	}
 }
+
+func TestArrayBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"map([1, 2, 3], fn(x) { x * 2 })", "[2,4,6]"},
+		{"map([], fn(x) { x })", "[]"},
+		{"filter([1, 2, 3, 4], fn(x) { x > 2 })", "[3,4]"},
+		{"reduce([1, 2, 3], fn(acc, x) { acc + x }, 10)", "16"},
+		{"reduce([1, 2, 3], fn(acc, x) { acc * x })", "6"},
+		{"reduce([], fn(acc, x) { acc })", "ERROR: reduce of empty array with no initial value"},
+		{"sort([3, 1, 2])", "[1,2,3]"},
+		{`sort(["b", "c", "a"])`, "[a,b,c]"},
+		{"sort([1, 3, 2], fn(a, b) { a > b })", "[3,2,1]"},
+		{`sort([1, "a"])`, "ERROR: sort without comparator needs all INTEGER or all STRING, got STRING"},
+		{"let a = [3, 1]; sort(a); a", "[3,1]"},
+		{"reverse([1, 2, 3])", "[3,2,1]"},
+		{"concat([1], [], [2, 3])", "[1,2,3]"},
+		{"slice([1, 2, 3, 4], 1, 3)", "[2,3]"},
+		{"slice([1, 2, 3, 4], -2)", "[3,4]"},
+		{"slice([1, 2, 3, 4], 3, 1)", "[]"},
+		{"contains([1, 2, 3], 2)", "true"},
+		{`contains(["a"], "a")`, "true"},
+		{"contains([[1, 2]], [1, 2])", "true"},
+		{"contains([1, 2, 3], 4)", "false"},
+		{`index_of([1, "b", 3], "b")`, "1"},
+		{"index_of([1, 2, 3], 4)", "-1"},
+		{"zip([1, 2, 3], [4, 5])", "[[1,4],[2,5]]"},
+		{"flatten([1, [2, 3], [[4]]])", "[1,2,3,[4]]"},
+		{"range(3)", "[0,1,2]"},
+		{"range(1, 4)", "[1,2,3]"},
+		{"range(5, 0, -2)", "[5,3,1]"},
+		{"range(1, 2, 0)", "ERROR: range step must not be zero"},
+		{"range(2, 2)", "[]"},
+		{"range(0, 5, -1)", "[]"},
+		{"range(9223372036854775800, 9223372036854775807, 5)", "[9223372036854775800,9223372036854775805]"},
+		{"range(-9223372036854775807 - 1, -9223372036854775807, 10)", "[-9223372036854775808]"},
+		{"range(-9223372036854775807, -9223372036854775807 - 1, -9223372036854775807 - 1)", "[-9223372036854775807]"},
+		{"range(-9223372036854775807 - 1, 9223372036854775807)", "ERROR: range(-9223372036854775808, 9223372036854775807, 1) is too large, it has more than 16777216 elements"},
+		{"try { range(1 << 30) } catch (e) { e[\"kind\"] }", "ArgumentError"},
+		{"any([1, 2, 3], fn(x) { x > 2 })", "true"},
+		{"any([1, 2, 3], fn(x) { x > 3 })", "false"},
+		{"all([1, 2, 3], fn(x) { x > 0 })", "true"},
+		{"all([true, false])", "false"},
+		{"unique([1, 2, 1, 3, 2])", "[1,2,3]"},
+		{`unique([1, true, "1", 1, true, [1], [1], {"a": [1]}, {"a": [1]}, [{}], [{}]])`, "[1,true,1,[1],{a: [1]},[{}]]"},
+		{"sum([1, 2, 3])", "6"},
+		{"sum([])", "0"},
+		{`sum([1, "a"])`, "ERROR: sum needs all INTEGER, got STRING"},
+		{"map(1, fn(x) { x })", "ERROR: argument to map must be ARRAY, got INTEGER"},
+		{"map([1], fn(x, y) { x })", "ERROR: wrong number of arguments. got=1, want=2"},
+		{"map([1, 2], fn(x) { x + true })", "ERROR: type mismatch: INTEGER + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated == nil {
+			t.Errorf("%s: got nil", tt.input)
+			continue
+		}
+
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s: got=%q, want=%q", tt.input, evaluated.Inspect(), tt.expected)
+		}
+	}
+}
+
+func TestHashBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"b": 1, "a": 2, 3: 3, true: 4}`, "{b: 1, a: 2, 3: 3, true: 4}"},
+		{`keys({"b": 1, "a": 2, "c": 3})`, "[b,a,c]"},
+		{`values({"b": 1, "a": 2, "c": 3})`, "[1,2,3]"},
+		{`len({"a": 1, "b": 2})`, "2"},
+		{`len({})`, "0"},
+		{`has({"a": 1}, "a")`, "true"},
+		{`has({"a": 1}, "b")`, "false"},
+		{`delete({"a": 1, "b": 2, "c": 3}, "b")`, "{a: 1, c: 3}"},
+		{`delete({"a": 1}, "z")`, "{a: 1}"},
+		{`let h = {"a": 1}; delete(h, "a"); h`, "{a: 1}"},
+		{`merge({"a": 1, "b": 2}, {"b": 20, "c": 30})`, "{a: 1, b: 20, c: 30}"},
+		{`keys(merge({"x": 1}, {}, {"y": 2}))`, "[x,y]"},
+		{`keys([1])`, "ERROR: argument to keys must be HASH, got ARRAY"},
+		{`has({}, fn(x) { x })`, "ERROR: unusable as hash key: FUNCTION"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated == nil {
+			t.Errorf("%s: got nil", tt.input)
+			continue
+		}
+
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s: got=%q, want=%q", tt.input, evaluated.Inspect(), tt.expected)
+		}
+	}
+}
+
+func TestIOBuiltins(t *testing.T) {
+	var out bytes.Buffer
+	SetOutput(&out)
+	SetInput(strings.NewReader("first line\nsecond\r\nlast"))
+	defer SetOutput(os.Stdout)
+	defer SetInput(os.Stdin)
+
+	tests := []struct {
+		input    string
+		expected string
+		output   string
+	}{
+		{`puts("a", 1, [2])`, "null", "a\n1\n[2]\n"},
+		{`print("a", 1); print("b")`, "null", "a 1b"},
+		{`readline()`, "first line", ""},
+		{`input("name? ")`, "second", "name? "},
+		{`readline()`, "last", ""},
+		{`readline()`, "null", ""},
+		{`readline(1)`, "ERROR: wrong number of arguments. got=1, want=0", ""},
+	}
+
+	for _, tt := range tests {
+		out.Reset()
+		evaluated := testEval(tt.input)
+
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s: got=%q, want=%q", tt.input, evaluated.Inspect(), tt.expected)
+		}
+
+		if out.String() != tt.output {
+			t.Errorf("%s: wrong output. got=%q, want=%q", tt.input, out.String(), tt.output)
+		}
+	}
+}
+
+func TestFileBuiltins(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	SetSandbox(nil)
+	if got := testEval(`read_file("a.txt")`).Inspect(); got != "ERROR: read_file: file access is disabled" {
+		t.Errorf("file access without sandbox. got=%q", got)
+	}
+
+	SetSandbox(&Sandbox{Root: root})
+	defer SetSandbox(nil)
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`read_file("a.txt")`, "hello"},
+		{`read_file("../../a.txt")`, "hello"},
+		{`write_file("b.txt", "bye"); read_file("/b.txt")`, "bye"},
+		{`list_dir(".")`, "[a.txt,b.txt]"},
+		{`write_file("c.txt", 1)`, "ERROR: argument to write_file must be STRING, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s: got=%q, want=%q", tt.input, evaluated.Inspect(), tt.expected)
+		}
+	}
+
+	SetSandbox(&Sandbox{Root: root, ReadOnly: true})
+	if got := testEval(`write_file("d.txt", "x")`).Inspect(); got != "ERROR: write_file: file access is read only" {
+		t.Errorf("write in read only sandbox. got=%q", got)
+	}
+}
+
+func TestSandboxSymlinks(t *testing.T) {
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	links := map[string]string{
+		"out":      outside,
+		"secret":   filepath.Join(outside, "secret.txt"),
+		"dangling": filepath.Join(outside, "new.txt"),
+		"inside":   filepath.Join(root, "a.txt"),
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Skipf("no symlinks here: %s", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	SetSandbox(&Sandbox{Root: root})
+	defer SetSandbox(nil)
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`read_file("secret")`, "ERROR: read_file: secret is outside the sandbox"},
+		{`read_file("out/secret.txt")`, "ERROR: read_file: out/secret.txt is outside the sandbox"},
+		{`list_dir("out")`, "ERROR: list_dir: out is outside the sandbox"},
+		{`write_file("out/new.txt", "x")`, "ERROR: write_file: out/new.txt is outside the sandbox"},
+		{`write_file("secret", "x")`, "ERROR: write_file: secret is outside the sandbox"},
+		{`write_file("dangling", "x")`, "ERROR: write_file: dangling is a link to a missing file"},
+		{`read_file("inside")`, "hello"},
+		{`write_file("new.txt", "x"); read_file("new.txt")`, "x"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s: got=%q, want=%q", tt.input, evaluated.Inspect(), tt.expected)
+		}
+	}
+
+	if content, _ := os.ReadFile(filepath.Join(outside, "secret.txt")); string(content) != "secret" {
+		t.Errorf("the file outside was written: %q", content)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "new.txt")); err == nil {
+		t.Errorf("a file was created outside")
+	}
+}
+
+func TestLogicalOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"true && true", "true"},
+		{"true && false", "false"},
+		{"false || true", "true"},
+		{"false || false", "false"},
+		{"1 < 2 && 2 < 3", "true"},
+		{"1 > 2 || 2 > 3", "false"},
+		{`1 && "x"`, "x"},
+		{`0 || "x"`, "0"},
+		{`{}["a"] || "default"`, "default"},
+		{`{}["a"] && "never"`, "null"},
+		{"false && missing", "false"},
+		{"true || missing()", "true"},
+		{"true && missing", "ERROR: identifier not found: missing"},
+		{"false || true && false", "false"},
+		{"true || true && false", "true"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s: got=%q, want=%q", tt.input, evaluated.Inspect(), tt.expected)
+		}
+	}
+}
+
+func TestArithmeticAndBitwiseOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"7 % 3", "1"},
+		{"-7 % 3", "-1"},
+		{"2 ** 10", "1024"},
+		{"2 ** 0", "1"},
+		{"2 ** 3 ** 2", "512"},
+		{"-2 ** 2", "-4"},
+		{"2 * 3 ** 2", "18"},
+		{"6 & 3", "2"},
+		{"6 | 3", "7"},
+		{"6 ^ 3", "5"},
+		{"~5", "-6"},
+		{"1 << 4", "16"},
+		{"-16 >> 2", "-4"},
+		{"1 + 1 << 2", "8"},
+		{"5 & 1 == 1", "true"},
+		{"1 | 2 ^ 3 & 4", "3"},
+		{"2 ** -1", "ERROR: negative exponent: 2 ** -1"},
+		{"1 << -1", "ERROR: invalid shift count: 1 << -1"},
+		{"1 >> 64", "ERROR: invalid shift count: 1 >> 64"},
+		{"1 % 0", "ERROR: division by zero: 1 % 0"},
+		{"1 / 0", "ERROR: division by zero: 1 / 0"},
+		{"~true", "ERROR: unknown operator: ~BOOLEAN"},
+		{`"a" % "b"`, "ERROR: unknown operator: STRING % STRING"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s: got=%q, want=%q", tt.input, evaluated.Inspect(), tt.expected)
+		}
+	}
+}
+
+func TestConditionalAndNullish(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"true ? 1 : 2", "1"},
+		{"false ? 1 : 2", "2"},
+		{"1 > 2 ? 1 : 3 > 2 ? 3 : 2", "3"},
+		{`len([]) == 0 ? "empty" : missing`, "empty"},
+		{"let abs = fn(x) { x < 0 ? -x : x }; abs(-3) + abs(4)", "7"},
+		{`{}["a"] ?? "default"`, "default"},
+		{`false ?? "default"`, "false"},
+		{`0 ?? missing`, "0"},
+		{`{}["a"] ?? {}["b"] ?? 3`, "3"},
+		{`let h = {"k": {"x": 1}}; h?.["k"]?.x`, "1"},
+		{`let h = {"k": 1}; h["missing"]?.x`, "null"},
+		{`let h = {"k": 1}; h["missing"]?.[missing]`, "null"},
+		{`let person = {"name": "Tom"}; person?.name`, "Tom"},
+		{`let h = {"k": 1}; h["missing"]["x"]`, "ERROR: index operator not supported: NULL"},
+		{"true ? missing : 1", "ERROR: identifier not found: missing"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s: got=%q, want=%q", tt.input, evaluated.Inspect(), tt.expected)
+		}
+	}
+}
+
+func TestMatchExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`match (1) { 1 => "one", _ => "other" }`, "one"},
+		{`match (5) { 1 => "one", _ => "other" }`, "other"},
+		{`match (-1) { -1 => "minus one", _ => "other" }`, "minus one"},
+		{`match ("a") { "a" => 1, "b" => 2 }`, "1"},
+		{`match (7) { n => n * 2 }`, "14"},
+		{`match ([1, 2]) { [1, 2] => "exact", _ => "other" }`, "exact"},
+		{`match ([1, 2, 3]) { [a, b] => a + b, [a, ...rest] => rest }`, "[2,3]"},
+		{`match ([1]) { [a, ...rest] => rest }`, "[]"},
+		{`match ([]) { [a, ..._] => a, [] => "empty" }`, "empty"},
+		{`match ([[1, 2], 3]) { [[a, b], c] => a + b + c }`, "6"},
+		{`match ({"x": 1, "y": 2}) { {"x": 0} => "zero", {"x": 1, y} => y }`, "2"},
+		{`match ({"name": "Tom", "tags": ["x"]}) { {name, "tags": [t]} => name + "-" + t }`, "Tom-x"},
+		{`match ({"x": 1}) { {"y": y} => y, _ => "no y" }`, "no y"},
+		{`match (3) { n if n > 5 => "big", n if n > 0 => "small", _ => "neg" }`, "small"},
+		{`let n = 10; match (1) { n => n }; n`, "10"},
+		{`let f = fn(x) { match (x) { [] => 0, [h, ...t] => h + f(t) } }; f([1, 2, 3, 4])`, "10"},
+		{`match (1) { 2 => 2 }`, "ERROR: no match arm for: 1"},
+		{`match ([1]) { [a, b] => a }`, "ERROR: no match arm for: [1]"},
+		{`match (1) { n if missing => n }`, "ERROR: identifier not found: missing"},
+		{`match (missing) { _ => 1 }`, "ERROR: identifier not found: missing"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s: got=%q, want=%q", tt.input, evaluated.Inspect(), tt.expected)
+		}
+	}
+}
+
+func TestDestructuring(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let [a, b] = [1, 2]; a + b", "3"},
+		{"let [a, ...rest] = [1, 2, 3]; rest", "[2,3]"},
+		{"let [_, b, ..._] = [1, 2, 3]; b", "2"},
+		{"let [[a, b], c] = [[1, 2], 3]; a * b * c", "6"},
+		{`let {name, "age": age} = {"name": "Tom", "age": 3, "x": 0}; name + ":" + (age > 2 ? "old" : "young")`, "Tom:old"},
+		{`let {"pos": [x, y]} = {"pos": [3, 4]}; x * x + y * y`, "25"},
+		{"let add = fn([x, y]) { x + y }; add([1, 2])", "3"},
+		{`let greet = fn({name}, greeting) { greeting + " " + name }; greet({"name": "Tom"}, "hi")`, "hi Tom"},
+		{"let head = fn([h, ..._]) { h }; map([[1, 2], [3]], head)", "[1,3]"},
+		{"let [a, b] = [1];", "ERROR: can not destructure [1] into [a, b]: expected 2 elements, got 1"},
+		{"let [a, ...b] = [];", "ERROR: can not destructure [] into [a, ...b]: expected at least 1 elements, got 0"},
+		{`let {name} = {"age": 3};`, `ERROR: can not destructure {age: 3} into {name:name}: missing key name`},
+		{"let [a] = 1;", "ERROR: can not destructure 1 into [a]: expected ARRAY, got INTEGER"},
+		{"let f = fn([x, y]) { x }; f([1])", "ERROR: can not destructure [1] into [x, y]: expected 2 elements, got 1"},
+		{"let f = fn({x}) { x }; f(1)", "ERROR: can not destructure 1 into {x:x}: expected HASH, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s: got=%q, want=%q", tt.input, evaluated.Inspect(), tt.expected)
+		}
+	}
+}
+
+func TestSliceAndStringIndex(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"[1, 2, 3, 4][1:3]", "[2,3]"},
+		{"[1, 2, 3, 4][:-1]", "[1,2,3]"},
+		{"[1, 2, 3, 4][2:]", "[3,4]"},
+		{"[1, 2, 3, 4][:]", "[1,2,3,4]"},
+		{"[1, 2, 3, 4][-2:]", "[3,4]"},
+		{"[1, 2, 3, 4][3:1]", "[]"},
+		{"[1, 2, 3, 4][-10:10]", "[1,2,3,4]"},
+		{"let a = [1, 2, 3]; let b = a[:]; push(b, 4); a", "[1,2,3]"},
+		{`"hello"[1:3]`, "el"},
+		{`"hello"[2:]`, "llo"},
+		{`"hello"[:-1]`, "hell"},
+		{`"hello"[0]`, "h"},
+		{`"hello"[-1]`, "o"},
+		{`"hello"[5]`, "null"},
+		{`let s = "abc"; s[len(s) - 1] == "c"`, "true"},
+		{`"héllo"[1]`, "é"},
+		{`"héllo"[-4]`, "é"},
+		{`"日本語"[2]`, "語"},
+		{`"日本語"[3]`, "null"},
+		{`"héllo"[1:3]`, "él"},
+		{`"日本語"[-2:]`, "本語"},
+		{`let s = "añb"; [len(s), s[len(s) - 1]]`, "[3,b]"},
+		{`let h = {"k": 1}; h["missing"]?.[1:]`, "null"},
+		{"let i = 1; [1, 2, 3][i:i + 1]", "[2]"},
+		{"[1, 2, 3][true ? 1 : 0:]", "[2,3]"},
+		{`[1, 2, 3]["a":]`, "ERROR: slice index must be INTEGER, got STRING"},
+		{`5[1:]`, "ERROR: slice operator not supported: INTEGER"},
+		{`[1, 2][missing:]`, "ERROR: identifier not found: missing"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s: got=%q, want=%q", tt.input, evaluated.Inspect(), tt.expected)
+		}
+	}
+}
+
+// push, rest and slices share the elements, the arrays and hashes they come from stay as they were
+func TestPersistentValues(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let a = [1, 2]; let b = push(a, 3); let c = push(a, 4); [a, b, c]", "[[1,2],[1,2,3],[1,2,4]]"},
+		{"let a = [1, 2, 3]; let r = rest(a); [push(r, 4), r, a]", "[[2,3,4],[2,3],[1,2,3]]"},
+		{"let a = [1, 2, 3]; let [x, ...xs] = a; [push(xs, 9), xs, a]", "[[2,3,9],[2,3],[1,2,3]]"},
+		{"let a = [1, 2, 3, 4]; let s = a[1:3]; [push(s, 9), a]", "[[2,3,9],[1,2,3,4]]"},
+		{"rest(rest(rest([1, 2, 3])))", "[]"},
+		{"push(rest([1]), 2)", "[2]"},
+		{`
+let build = fn(arr, i, n) { if (i == n) { arr } else { build(push(arr, i), i + 1, n) } };
+let total = fn(arr, acc) { if (len(arr) == 0) { acc } else { total(rest(arr), acc + first(arr)) } };
+let big = build([], 0, 2000);
+[len(big), big[0], big[1000], big[-1], total(big, 0), len(rest(big)), last(push(big, "x"))]`,
+			"[2000,0,1000,1999,1999000,1999,x]"},
+		{`let h = {"a": 1, "b": 2}; let d = delete(h, "a"); [h, d, len(h), len(d)]`, "[{a: 1, b: 2},{b: 2},2,1]"},
+		{`let h = {"a": 1}; let m = merge(h, {"b": 2}, {"a": 3}); [h, m]`, "[{a: 1},{a: 3, b: 2}]"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s: got=%q, want=%q", tt.input, evaluated.Inspect(), tt.expected)
+		}
+	}
+}
+
+func TestTryCatch(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`try { 1 } catch (e) { 2 }`, "1"},
+		{`try { throw "bad"; 1 } catch (e) { e["message"] }`, "bad"},
+		{`try { throw "bad" } catch (e) { e["kind"] }`, "Error"},
+		{`try { throw 42 } catch (e) { e["value"] + 1 }`, "43"},
+		{`try { throw error("ValueError", "bad input") } catch (e) { e["kind"] + ": " + e["message"] }`, "ValueError: bad input"},
+		{`try { missing } catch (e) { e["kind"] + ": " + e["message"] }`, "NameError: identifier not found: missing"},
+		{`try { 1 / 0 } catch (e) { e["kind"] }`, "ZeroDivisionError"},
+		{`try { 1 + "a" } catch (e) { e["kind"] }`, "TypeError"},
+		{`try { len(1, 2) } catch (e) { e["kind"] }`, "ArgumentError"},
+		{`try { read_file("a.txt") } catch (e) { e["kind"] }`, "IOError"},
+		{`try { 2 ** -1 } catch (e) { e["kind"] }`, "ArgumentError"},
+		{`try { 1 << -1 } catch (e) { e["kind"] }`, "ArgumentError"},
+		{`try { 1 >> 64 } catch (e) { e["kind"] }`, "ArgumentError"},
+		{`try { match (1) { 2 => 2 } } catch (e) { e["kind"] }`, "MatchError"},
+		{`try { throw "bad" } catch { "ignored" }`, "ignored"},
+		{`try { throw "bad" } catch (e) { 1 }; e`, "ERROR: identifier not found: e"},
+		{`let f = fn(x) { if (x < 0) { throw "negative" } x }; try { f(-1) } catch (e) { e["trace"] }`, "[{function: f, line: 1, column: 61}]"},
+		{`let inner = fn() { missing }; let outer = fn(a, b) { inner() }; try { outer(1, 2) } catch (e) { len(e["trace"]) }`, "2"},
+		{`try { try { throw "inner" } catch (e) { throw e } } catch (e) { e["message"] }`, "inner"},
+		{`try { try { throw error("KeyError", "k") } finally { 1 } } catch (e) { e["kind"] }`, "KeyError"},
+		{`let log = []; let r = try { 1 } finally { push(log, 1) }; r`, "1"},
+		{`try { 1 } finally { throw "from finally" }`, "ERROR: from finally"},
+		{`let f = fn() { try { return 1 } finally { 2 } }; f()`, "1"},
+		{`let f = fn() { try { throw "x" } catch (e) { return 2 }; 3 }; f()`, "2"},
+		{`try { throw "bad" } catch (e) { throw "again" }`, "ERROR: again"},
+		{`throw "uncaught"; 1`, "ERROR: uncaught"},
+		{`throw missing`, "ERROR: identifier not found: missing"},
+		{`error(1)`, "ERROR: argument to error must be STRING, got INTEGER"},
+		{`error("m")`, "{kind: Error, message: m}"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s: got=%q, want=%q", tt.input, evaluated.Inspect(), tt.expected)
+		}
+	}
+}
+
+func TestStackTrace(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"missing", "NameError: identifier not found: missing"},
+		{
+			`let inner = fn(x) {
+    x + missing
+};
+let outer = fn(x) {
+    inner(x * 2)
+};
+outer(1)`,
+			`Traceback (most recent call last):
+  line 7, column 6, in outer
+  line 5, column 10, in inner
+NameError: identifier not found: missing`,
+		},
+		{
+			`let check = fn(x) { if (x < 0) { throw error("ValueError", "negative") } x };
+map([1, -1], check)`,
+			`Traceback (most recent call last):
+  line 2, column 4, in map
+ValueError: negative`,
+		},
+		{
+			`let f = fn() { throw "deep" };
+let g = fn() { try { f() } catch (e) { throw e } };
+g()`,
+			`Traceback (most recent call last):
+  line 3, column 2, in g
+  line 2, column 23, in f
+Error: deep`,
+		},
+		{`fn(x) { x / 0 }(1)`, `Traceback (most recent call last):
+  line 1, column 16, in <anonymous>
+ZeroDivisionError: division by zero: 1 / 0`},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%s: not an error, got=%T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Traceback() != tt.expected {
+			t.Errorf("%s: traceback wrong.\ngot=\n%s\nwant=\n%s", tt.input, errObj.Traceback(), tt.expected)
+		}
+	}
+
+	// the stack is empty again after an error
+	if len(defaultContext.calls) != 0 {
+		t.Errorf("call stack not empty: %v", defaultContext.calls)
+	}
+}
+
+// evaluations in their own Context run at the same time without seeing each other's output or calls
+func TestContexts(t *testing.T) {
+	input := `let down = fn(n) { if (n == 0) { puts(name); missing } else { down(n - 1) } }; down(depth)`
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 8; i++ {
+		// each one has its own ast, Resolve writes into it; the parser is not safe to run at the same time
+		program := parser.New(lexer.New(input)).ParseProgram()
+		Resolve(program, Scoping{})
+
+		wg.Add(1)
+		go func(depth int) {
+			defer wg.Done()
+
+			var out bytes.Buffer
+			ctx := NewContext()
+			ctx.Output = &out
+
+			env := WithContext(object.NewEnvironment(), ctx)
+			env.Set("name", &object.String{Value: fmt.Sprintf("eval %d", depth)})
+			env.Set("depth", &object.Integer{Value: int64(depth * 10)})
+
+			for round := 0; round < 20; round++ {
+				out.Reset()
+				evaluated := Eval(program, env)
+
+				errObj, ok := evaluated.(*object.Error)
+				if !ok || len(errObj.Trace) != depth*10+1 {
+					t.Errorf("depth %d: wrong error. got=%s", depth*10, evaluated.Inspect())
+					return
+				}
+				if out.String() != fmt.Sprintf("eval %d\n", depth) {
+					t.Errorf("depth %d: wrong output. got=%q", depth*10, out.String())
+					return
+				}
+			}
+			if len(ctx.calls) != 0 {
+				t.Errorf("depth %d: call stack not empty: %v", depth*10, ctx.calls)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestNamedFunctions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn add(a, b) { a + b }; add(1, 2)", "3"},
+		// annotations are for the type checker, the evaluator does not look at them
+		{"fn add(a: int, b: int): int { a + b }; let x: int = add(1, 2); x", "3"},
+		{"add(1, 2); fn add(a, b) { a + b }", "fn add(a,b) {\n(a+b)\n}"},
+		{"let r = add(1, 2); fn add(a, b) { a + b }; r", "3"},
+		{`fn is_even(n) { n == 0 ? true : is_odd(n - 1) }
+fn is_odd(n) { n == 0 ? false : is_even(n - 1) }
+is_even(10)`, "true"},
+		{`let r = is_odd(7);
+fn is_odd(n) { n == 0 ? false : is_even(n - 1) }
+fn is_even(n) { n == 0 ? true : is_odd(n - 1) }
+r`, "true"},
+		{"let fact = fn f(n) { n < 2 ? 1 : n * f(n - 1) }; fact(5)", "120"},
+		{"let fact = fn f(n) { n }; f", "ERROR: identifier not found: f"},
+		{"fn outer() { inner(); fn inner() { 42 } }; outer()", "fn inner() {\n42\n}"},
+		{"fn outer() { let x = inner(); fn inner() { 42 }; x }; outer()", "42"},
+		{"fn outer() { fn inner() { 1 } }; inner", "ERROR: identifier not found: inner"},
+		{"let f = 1; fn f() { 2 }; f()", "2"},
+		{"fn f() { 2 }; let g = f; g == f", "true"},
+		{"map([1, 2, 3], fn double(x) { x * 2 })", "[2,4,6]"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s: got=%q, want=%q", tt.input, evaluated.Inspect(), tt.expected)
+		}
+	}
+
+	input := `fn inner() { missing }
+let alias = inner;
+alias()`
+	errObj, ok := testEval(input).(*object.Error)
+	if !ok {
+		t.Fatalf("not an error")
+	}
+	if len(errObj.Trace) != 1 || errObj.Trace[0].Function != "inner" {
+		t.Errorf("trace should name the fn. got=%v", errObj.Trace)
+	}
+}
+
+func TestBlockScope(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		leak     bool
+	}{
+		{"if (true) { let x = 1; }; x", "ERROR: identifier not found: x", false},
+		{"let x = 1; if (true) { let x = 2; x }", "2", false},
+		{"let x = 1; if (true) { let x = 2; }; x", "1", false},
+		{"let x = 1; if (false) { 0 } else { let x = 3; }; x", "1", false},
+		{"let f = fn() { if (true) { let y = 1; }; y }; f()", "ERROR: identifier not found: y", false},
+		{"let f = fn(a) { let b = a + 1; if (true) { a + b } }; f(1)", "3", false},
+		{"try { let t = 1; } finally { }; t", "ERROR: identifier not found: t", false},
+		{"if (true) { fn g() { 1 } }; g", "ERROR: identifier not found: g", false},
+		{"if (true) { let x = 1; }; x", "1", true},
+		{"let f = fn() { if (true) { let y = 1; }; y }; f()", "1", true},
+	}
+
+	// both scopings at the same time, each evaluation has its own in its Context
+	programs := make([]*ast.Program, len(tests))
+	for i, tt := range tests {
+		programs[i] = parser.New(lexer.New(tt.input)).ParseProgram()
+		Resolve(programs[i], Scoping{LeakBlockScope: tt.leak})
+	}
+
+	var wg sync.WaitGroup
+	for i, tt := range tests {
+		wg.Add(1)
+		go func(program *ast.Program, input, expected string, leak bool) {
+			defer wg.Done()
+
+			ctx := NewContext()
+			ctx.LeakBlockScope = leak
+
+			evaluated := Eval(program, WithContext(object.NewEnvironment(), ctx))
+			if evaluated.Inspect() != expected {
+				t.Errorf("%s (leak=%v): got=%q, want=%q", input, leak, evaluated.Inspect(), expected)
+			}
+		}(programs[i], tt.input, tt.expected, tt.leak)
+	}
+	wg.Wait()
+}
+
+func TestBuiltinSignatures(t *testing.T) {
+	for _, name := range BuiltinNames() {
+		if !strings.HasPrefix(BuiltinSignature(name), name+"(") {
+			t.Errorf("builtin %s has no signature, got=%q", name, BuiltinSignature(name))
+		}
+	}
+}
+
+func TestQuoteUnquote(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`quote(5)`, `5`},
+		{`quote(5 + 8)`, `(5+8)`},
+		{`quote(foobar + barfoo)`, `(foobar+barfoo)`},
+		{`quote(unquote(4))`, `4`},
+		{`quote(8 + unquote(4 + 4))`, `(8+8)`},
+		{`quote(unquote(4 - 8) * 2)`, `((-4)*2)`},
+		{`let foo = 8; quote(unquote(foo) + bar)`, `(8+bar)`},
+		{`quote(unquote(true == false))`, `false`},
+		{`quote(unquote("a") + b)`, `(a+b)`},
+		{`quote(unquote([1, [true]]))`, `[1,[true]]`},
+		{`quote(unquote(quote(4 + 4)))`, `(4+4)`},
+		{`let q = quote(4 + 4); quote(unquote(4 + 4) + unquote(q))`, `(8+(4+4))`},
+		// the quoted ast is not changed by unquote, every call gets its own
+		{`let f = fn(x) { quote(unquote(x)) }; f(1); f(2)`, `2`},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		quote, ok := evaluated.(*object.Quote)
+		if !ok {
+			t.Errorf("%s: expect *object.Quote, got %T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if quote.Node == nil {
+			t.Errorf("%s: quote.Node is nil", tt.input)
+			continue
+		}
+		if quote.Node.String() != tt.expected {
+			t.Errorf("%s: got=%q, want=%q", tt.input, quote.Node.String(), tt.expected)
+		}
+	}
+
+	errors := []struct {
+		input    string
+		expected string
+	}{
+		{`quote(1, 2)`, "ERROR: wrong number of arguments to quote. got=2, want=1"},
+		{`quote(unquote())`, "ERROR: wrong number of arguments to unquote. got=0, want=1"},
+		{`quote(unquote(x))`, "ERROR: identifier not found: x"},
+		{`quote(unquote(fn() {}))`, "ERROR: can not unquote FUNCTION into code"},
+		{`unquote(1)`, "ERROR: identifier not found: unquote"},
+		{`macro(a) { a }`, "ERROR: macro can only be defined by a let at the top level"},
+	}
+
+	for _, tt := range errors {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s: got=%q, want=%q", tt.input, evaluated.Inspect(), tt.expected)
+		}
+	}
+}
+
+func TestDefineMacros(t *testing.T) {
+	input := `
+	let number = 1;
+	let function = fn(x, y) { x + y };
+	let mymacro = macro(x, y) { x + y; };
+	`
+
+	env := object.NewEnvironment()
+	program := parser.New(lexer.New(input)).ParseProgram()
+
+	DefineMacros(program, env)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("wrong number of statements. got=%d", len(program.Statements))
+	}
+	if _, ok := env.Get("number"); ok {
+		t.Errorf("number should not be defined")
+	}
+	if _, ok := env.Get("function"); ok {
+		t.Errorf("function should not be defined")
+	}
+
+	obj, ok := env.Get("mymacro")
+	if !ok {
+		t.Fatalf("macro not in environment")
+	}
+	macro, ok := obj.(*object.Macro)
+	if !ok {
+		t.Fatalf("object is not Macro. got=%T (%+v)", obj, obj)
+	}
+	if len(macro.Parameters) != 2 || macro.Parameters[0].Name != "x" || macro.Parameters[1].Name != "y" {
+		t.Errorf("wrong params %v", macro.Parameters)
+	}
+	if macro.Body.String() != "(x+y)" {
+		t.Errorf("body is not %q. got=%q", "(x+y)", macro.Body.String())
+	}
+}
+
+func TestExpandMacros(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let infix = macro() { quote(1 + 2) }; infix()`, `(1+2)`},
+		{`let reverse = macro(a, b) { quote(unquote(b) - unquote(a)) }; reverse(2 + 2, 10 - 5)`, `((10-5)-(2+2))`},
+		{`let unless = macro(cond, then, otherwise) {
+			quote(if (!(unquote(cond))) { unquote(then) } else { unquote(otherwise) })
+		};
+		unless(10 > 5, puts("not greater"), puts("greater"))`,
+			`if (!(10 > 5)) { puts("not greater") } else { puts("greater") }`},
+		{`let twice = macro(x) { quote(unquote(x) + unquote(x)) }; twice(twice(1))`, `((1+1)+(1+1))`},
+		{`let m = macro(x) { let y = 2; quote(unquote(x) * unquote(y)) }; fn() { m(a) }`, `fn() { a * 2 }`},
+	}
+
+	for _, tt := range tests {
+		expected := parser.New(lexer.New(tt.expected)).ParseProgram()
+
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		env := object.NewEnvironment()
+		DefineMacros(program, env)
+		expanded, err := ExpandMacros(program, env)
+		if err != nil {
+			t.Errorf("%s: %s", tt.input, err.Inspect())
+			continue
+		}
+
+		if expanded.String() != expected.String() {
+			t.Errorf("not equal. want=%q, got=%q", expected.String(), expanded.String())
+		}
+	}
+
+	errors := []struct {
+		input    string
+		expected string
+	}{
+		{`let m = macro(a) { a }; m(1, 2)`, "ERROR: wrong number of arguments to macro m. got=2, want=1"},
+		{`let m = macro(a) { 1 }; m(x)`, "ERROR: macro m must return a quote, got INTEGER"},
+		{`let m = macro(a) { let b = 1; }; m(x)`, "ERROR: macro m must return a quote, got NULL"},
+		{`let m = macro(a) { quote(unquote(b)) }; m(x)`, "ERROR: identifier not found: b"},
+	}
+
+	for _, tt := range errors {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		env := object.NewEnvironment()
+		DefineMacros(program, env)
+		_, err := ExpandMacros(program, env)
+		if err == nil {
+			t.Errorf("%s: expect error %q", tt.input, tt.expected)
+			continue
+		}
+		if err.Inspect() != tt.expected {
+			t.Errorf("%s: got=%q, want=%q", tt.input, err.Inspect(), tt.expected)
+		}
+		if len(err.Trace) == 0 || err.Trace[0].Function != "m" {
+			t.Errorf("%s: expect the macro call in the trace, got %v", tt.input, err.Trace)
+		}
+	}
+
+	// what is expanded runs
+	input := `let unless = macro(cond, then, otherwise) { quote(if (!(unquote(cond))) { unquote(then) } else { unquote(otherwise) }) };
+	unless(1 > 2, "yes", "no")`
+	program := parser.New(lexer.New(input)).ParseProgram()
+	macros := object.NewEnvironment()
+	DefineMacros(program, macros)
+	if _, err := ExpandMacros(program, macros); err != nil {
+		t.Fatalf("expand: %s", err.Inspect())
+	}
+	if evaluated := Eval(program, object.NewEnvironment()); evaluated.Inspect() != "yes" {
+		t.Errorf("got=%q, want=%q", evaluated.Inspect(), "yes")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	input := `let g = 1;
+let f = fn(a, [b, c]) {
+	let d = a;
+	if (true) { let e = d; fn() { e + b + g } }
+}`
+	program := parser.New(lexer.New(input)).ParseProgram()
+	Resolve(program, Scoping{})
+
+	// the names in the innermost fn: e, b and g, where they are from there
+	var idents []*ast.Identifier
+	ast.Inspect(program, func(node ast.Node) bool {
+		if ident, ok := node.(*ast.Identifier); ok {
+			idents = append(idents, ident)
+		}
+		return true
+	})
+	got := map[string][2]int{}
+	for _, ident := range idents[len(idents)-3:] {
+		got[ident.Name] = [2]int{ident.Depth, ident.Slot}
+	}
+	// the fn call env, the if block, the call of f with a, b, c, d, then the program
+	want := map[string][2]int{"e": {1, 1}, "b": {2, 2}, "g": {3, 0}}
+	for name, place := range want {
+		if got[name] != place {
+			t.Errorf("%s: expect (depth, slot) %v, got %v", name, place, got[name])
+		}
+	}
+
+	fn := program.Statements[1].(*ast.LetStatement).Expr.(*ast.FunctionLiteral)
+	if strings.Join(fn.Body.Slots, ",") != "a,b,c,d" {
+		t.Errorf("expect the call slots a,b,c,d, got %v", fn.Body.Slots)
+	}
+}
+
+// a resolved program gives the same results as the one looked up by name
+func TestResolveSameResults(t *testing.T) {
+	tests := []struct {
+		input string
+		leak  bool
+	}{
+		{`let x = 1; let f = fn() { x }; let x = 2; f()`, false},
+		{`let f = fn() { let x = 1; if (true) { let y = x; let x = 2; [y, x] } }; f()`, false},
+		{`let f = fn() { let x = 1; if (true) { let x = 2; }; x }; f()`, false},
+		{`let f = fn() { let x = 1; if (true) { let x = 2; }; x }; f()`, true},
+		{`let x = "global"; let f = fn() { let g = fn() { x }; let r = g(); let x = "local"; [r, g()] }; f()`, false},
+		{`let f = fn() { g(); }; let g = fn() { h() }; fn h() { 3 }; f()`, false},
+		{`let f = fn(n) { if (n < 2) { return n; }; f(n - 1) + f(n - 2) }; f(15)`, false},
+		{`let fact = fn self(n) { if (n < 2) { 1 } else { n * self(n - 1) } }; fact(10)`, false},
+		{`let fact = fn self(n) { 1 }; self`, false},
+		{`let make = fn(n) { fn(m) { fn(k) { n + m + k } } }; make(1)(2)(3)`, false},
+		{`let f = fn([a, [b, ...c]], {"k": d}) { [a, b, c, d] }; f([1, [2, 3, 4]], {"k": 5})`, false},
+		{`let f = fn(v) { match (v) { [x, y] if x > y => x, [x, y] => y, {"k": x} => x, x => -x } }; [f([1, 2]), f([3, 1]), f({"k": 9}), f(4)]`, false},
+		{`let f = fn() { try { throw error("K", "m") } catch (e) { let k = e["kind"]; k } }; f()`, false},
+		{`let f = fn() { try { throw 1 } catch (e) { let k = e["value"]; }; k }; f()`, true},
+		{`let f = fn() { try { throw 1 } catch (e) { let k = e["value"]; k + 1 } }; f()`, true},
+		{`let f = fn() { let e = "outer"; try { 1 } catch (e) { e }; e }; f()`, false},
+		{`let f = fn(x) { let _ = x; let [_, y] = [1, 2]; [_, y] }; f(7)`, false},
+		{`let f = fn(x) { quote(unquote(x) + y) }; f(1)`, false},
+		{`let f = fn() { undefined }; f()`, false},
+		{`let f = fn() { let a = b; let b = 1; a }; f()`, false},
+		{`let counter = fn() { let n = 0; fn() { n + 1 } }; let c = counter(); [c(), c()]`, false},
+		{`let apply = fn(f, xs) { map(xs, f) }; let k = 10; apply(fn(x) { x * k }, [1, 2, 3])`, false},
+	}
+
+	for _, tt := range tests {
+		ctx := NewContext()
+		ctx.LeakBlockScope = tt.leak
+
+		byName := Eval(parser.New(lexer.New(tt.input)).ParseProgram(), WithContext(object.NewEnvironment(), ctx))
+
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		Resolve(program, ctx.Scoping)
+		resolved := Eval(program, WithContext(object.NewEnvironment(), ctx))
+
+		if resolved.Inspect() != byName.Inspect() {
+			t.Errorf("%s (leak=%v): resolved=%q, by name=%q", tt.input, tt.leak, resolved.Inspect(), byName.Inspect())
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package evaluator
+
+import (
+	"xmonkey/ast"
+	"xmonkey/object"
+)
+
+// a caught error is a hash for the script:
+// {"kind": "NameError", "message": "identifier not found: x", "value": null,
+//  "trace": [{"function": "f", "line": 3, "column": 2}]}
+// throw takes such a hash back, so catch (e) { throw e } keeps kind, message and trace.
+func init() {
+	builtins["error"] = &object.Builtin{Fn: builtinError}
+}
+
+// error(message) or error(kind, message) builds the hash to throw
+func builtinError(env *object.Environment, args ...object.Object) object.Object {
+	if len(args) != 1 && len(args) != 2 {
+		return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1 or 2", len(args))
+	}
+
+	for i, arg := range args {
+		if arg.Type() != object.STRING_OBJ {
+			return newKindError(object.TYPE_ERROR, "argument to error must be STRING, got %s", args[i].Type())
+		}
+	}
+
+	kind, message := &object.String{Value: object.THROWN_ERROR}, args[0]
+	if len(args) == 2 {
+		kind, message = args[0].(*object.String), args[1]
+	}
+
+	hash := object.NewHash()
+	hash.Set(&object.String{Value: "kind"}, kind)
+	hash.Set(&object.String{Value: "message"}, message)
+
+	return hash
+}
+
+// throwValue turns what throw got into the error.
+// A string is the message, a hash may have kind, message, value and trace,
+// anything else becomes the value with its Inspect as the message.
+func throwValue(val object.Object) *object.Error {
+	// throw if (false) { 1 }
+	if val == nil {
+		val = NULL
+	}
+
+	errObj := &object.Error{Kind: object.THROWN_ERROR, Message: val.Inspect(), Value: val}
+
+	hash, ok := val.(*object.Hash)
+	if !ok {
+		return errObj
+	}
+
+	field := func(name string) (object.Object, bool) {
+		return hash.Get(&object.String{Value: name})
+	}
+
+	if kind, ok := field("kind"); ok && kind.Type() == object.STRING_OBJ {
+		errObj.Kind = kind.(*object.String).Value
+	}
+	if message, ok := field("message"); ok && message.Type() == object.STRING_OBJ {
+		errObj.Message = message.(*object.String).Value
+	}
+	if value, ok := field("value"); ok {
+		errObj.Value = value
+	}
+	// rethrown, the trace goes on from where it was first thrown (not nil, it is not traced again)
+	if trace, ok := field("trace"); ok {
+		if arr, ok := trace.(*object.Array); ok {
+			errObj.Trace = []object.Frame{}
+			for _, el := range arr.Elements() {
+				if frame, ok := el.(*object.Hash); ok {
+					errObj.Trace = append(errObj.Trace, hashFrame(frame))
+				}
+			}
+		}
+	}
+
+	return errObj
+}
+
+// hashFrame reads back a frame of errorHash, missing fields stay zero
+func hashFrame(h *object.Hash) object.Frame {
+	var frame object.Frame
+
+	if name, ok := h.Get(&object.String{Value: "function"}); ok {
+		if name, ok := name.(*object.String); ok {
+			frame.Function = name.Value
+		}
+	}
+	if line, ok := h.Get(&object.String{Value: "line"}); ok {
+		if line, ok := line.(*object.Integer); ok {
+			frame.Line = int(line.Value)
+		}
+	}
+	if column, ok := h.Get(&object.String{Value: "column"}); ok {
+		if column, ok := column.(*object.Integer); ok {
+			frame.Column = int(column.Value)
+		}
+	}
+
+	return frame
+}
+
+// errorHash is what catch (e) binds e to
+func errorHash(errObj *object.Error) *object.Hash {
+	kind := errObj.Kind
+	if kind == "" {
+		kind = object.RUNTIME_ERROR
+	}
+
+	value := errObj.Value
+	if value == nil {
+		value = NULL
+	}
+
+	trace := make([]object.Object, len(errObj.Trace))
+	for i, frame := range errObj.Trace {
+		h := object.NewHash()
+		h.Set(&object.String{Value: "function"}, &object.String{Value: frame.Function})
+		h.Set(&object.String{Value: "line"}, &object.Integer{Value: int64(frame.Line)})
+		h.Set(&object.String{Value: "column"}, &object.Integer{Value: int64(frame.Column)})
+		trace[i] = h
+	}
+
+	hash := object.NewHash()
+	hash.Set(&object.String{Value: "kind"}, &object.String{Value: kind})
+	hash.Set(&object.String{Value: "message"}, &object.String{Value: errObj.Message})
+	hash.Set(&object.String{Value: "value"}, value)
+	hash.Set(&object.String{Value: "trace"}, object.NewArray(trace))
+
+	return hash
+}
+
+// try evaluates to the block, or to the catch block if the block failed.
+// finally runs in any case, its value is dropped unless it fails or returns.
+func evalTryExpression(node *ast.TryExpression, env *object.Environment) object.Object {
+	result := Eval(node.Block, env)
+
+	if errObj, ok := result.(*object.Error); ok && node.Catch != nil {
+		// an error of this call has not left it, it is traced here
+		contextOf(env).trace(errObj)
+
+		// e is only visible inside catch
+		catchEnv := object.NewSlottedEnv(env, node.CatchSlots)
+		if node.CatchParam != nil {
+			bind(catchEnv, node.CatchParam, errorHash(errObj))
+		}
+
+		result = Eval(node.Catch, catchEnv)
+	}
+
+	if node.Finally != nil {
+		final := Eval(node.Finally, env)
+		if final != nil {
+			rt := final.Type()
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+				return final
+			}
+		}
+	}
+
+	return result
+}
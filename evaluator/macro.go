@@ -0,0 +1,224 @@
+package evaluator
+
+import (
+	"strconv"
+
+	"xmonkey/ast"
+	"xmonkey/object"
+	"xmonkey/token"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// quote/unquote and macros
+// quote(expr) is the ast of expr instead of its value, unquote(expr) inside it is evaluated
+// and put back into the ast: quote(1 + unquote(2 + 3)) is QUOTE((1+5)).
+//
+// let unless = macro(cond, then, otherwise) { quote(if (!(unquote(cond))) { unquote(then) } else { unquote(otherwise) }) };
+//
+// before the program runs, DefineMacros takes the macros out of it and ExpandMacros replaces
+// every unless(a, b, c) by the quote the macro body returns, the args are passed as quotes of their ast.
+
+// quote is called by Eval for quote(expr), before the args are evaluated
+func quote(call *ast.CallExpression, env *object.Environment) object.Object {
+	if len(call.ActualParams) != 1 {
+		return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments to quote. got=%d, want=1", len(call.ActualParams))
+	}
+
+	// the ast of the call is run again and again (a quote in a fn, a macro called twice),
+	// the unquotes are replaced in a copy of it
+	node, err := evalUnquoteCalls(ast.Copy(call.ActualParams[0]), env)
+	if err != nil {
+		return err
+	}
+	return &object.Quote{Node: node}
+}
+
+func evalUnquoteCalls(quoted ast.Node, env *object.Environment) (ast.Node, *object.Error) {
+	var err *object.Error
+
+	node := ast.Modify(quoted, func(node ast.Node) ast.Node {
+		call, ok := node.(*ast.CallExpression)
+		if !ok || err != nil || !isCallTo(call, "unquote") {
+			return node
+		}
+
+		if len(call.ActualParams) != 1 {
+			err = newKindError(object.ARGUMENT_ERROR, "wrong number of arguments to unquote. got=%d, want=1", len(call.ActualParams))
+			return node
+		}
+
+		unquoted := Eval(call.ActualParams[0], env)
+		if isError(unquoted) {
+			err = unquoted.(*object.Error)
+			return node
+		}
+
+		var converted ast.Node
+		converted, err = ObjectToNode(unquoted, call.Token)
+		if err != nil {
+			return node
+		}
+		return converted
+	})
+
+	return node, err
+}
+
+func isCallTo(call *ast.CallExpression, name string) bool {
+	ident, ok := call.CallableName.(*ast.Identifier)
+	return ok && ident.Name == name
+}
+
+// ObjectToNode turns a value back into code, with the position of at:
+// the value of an unquote, or a constant the optimizer computed ahead of time
+func ObjectToNode(obj object.Object, at token.Token) (ast.Node, *object.Error) {
+	tok := func(typ token.TokenType, raw string) token.Token {
+		return token.Token{Type: typ, RawString: raw, Line: at.Line, Column: at.Column}
+	}
+
+	switch obj := obj.(type) {
+	case *object.Quote:
+		// a macro may unquote an arg twice, each place gets its own copy so the ast stays a tree
+		return ast.Copy(obj.Node), nil
+
+	case *object.Integer:
+		if obj.Value < 0 {
+			// -5 is the prefix - on 5, as the parser makes it
+			value := -obj.Value
+			literal := &ast.IntegerLiteral{Token: tok(token.INT, strconv.FormatUint(uint64(value), 10)), Value: value}
+			return &ast.PrefixExpression{Token: tok(token.MINUS, "-"), Operator: "-", Right: literal}, nil
+		}
+		return &ast.IntegerLiteral{Token: tok(token.INT, strconv.FormatInt(obj.Value, 10)), Value: obj.Value}, nil
+
+	case *object.Boolean:
+		if obj.Value {
+			return &ast.Boolean{Token: tok(token.TRUE, "true"), Value: true}, nil
+		}
+		return &ast.Boolean{Token: tok(token.FALSE, "false"), Value: false}, nil
+
+	case *object.String:
+		return &ast.StringLiteral{Token: tok(token.STRING, obj.Value), Value: obj.Value}, nil
+
+	case *object.Array:
+		array := &ast.ArrayLiteral{Token: tok(token.LBRACKET, "["), Elements: []ast.Expression{}}
+		for _, el := range obj.Elements() {
+			node, err := ObjectToNode(el, at)
+			if err != nil {
+				return nil, err
+			}
+			array.Elements = append(array.Elements, node.(ast.Expression))
+		}
+		return array, nil
+
+	case *object.Hash:
+		hash := &ast.HashLiteral{Token: tok(token.LBRACE, "{"), Pairs: map[ast.Expression]ast.Expression{}, Keys: []ast.Expression{}}
+		for _, pair := range obj.OrderedPairs() {
+			key, err := ObjectToNode(pair.Key, at)
+			if err != nil {
+				return nil, err
+			}
+			value, err := ObjectToNode(pair.Value, at)
+			if err != nil {
+				return nil, err
+			}
+			hash.Keys = append(hash.Keys, key.(ast.Expression))
+			hash.Pairs[key.(ast.Expression)] = value.(ast.Expression)
+		}
+		return hash, nil
+	}
+
+	return nil, newKindError(object.TYPE_ERROR, "can not unquote %s into code", obj.Type())
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// DefineMacros takes the let m = macro(...) statements out of the top level of program, and binds them in env
+func DefineMacros(program *ast.Program, env *object.Environment) {
+	stmts := []ast.Statement{}
+
+	for _, stmt := range program.Statements {
+		let, ok := stmt.(*ast.LetStatement)
+		if !ok || let.Name == nil {
+			stmts = append(stmts, stmt)
+			continue
+		}
+		macro, ok := let.Expr.(*ast.MacroLiteral)
+		if !ok {
+			stmts = append(stmts, stmt)
+			continue
+		}
+
+		env.Set(let.Name.Name, &object.Macro{Parameters: macro.Parameters, Body: macro.Body, Env: env})
+	}
+
+	program.Statements = stmts
+}
+
+// ExpandMacros replaces the calls of the macros in env by the code they return.
+// A call in the args of another is expanded first, the code a macro returns is not expanded again.
+func ExpandMacros(program ast.Node, env *object.Environment) (ast.Node, *object.Error) {
+	var err *object.Error
+
+	expanded := ast.Modify(program, func(node ast.Node) ast.Node {
+		call, ok := node.(*ast.CallExpression)
+		if !ok || err != nil {
+			return node
+		}
+		ident, ok := call.CallableName.(*ast.Identifier)
+		if !ok {
+			return node
+		}
+		obj, ok := env.Get(ident.Name)
+		if !ok {
+			return node
+		}
+		macro, ok := obj.(*object.Macro)
+		if !ok {
+			return node
+		}
+
+		var quoted ast.Node
+		quoted, err = expandMacro(call, macro)
+		if err != nil {
+			return node
+		}
+		return quoted
+	})
+
+	return expanded, err
+}
+
+func expandMacro(call *ast.CallExpression, macro *object.Macro) (node ast.Node, err *object.Error) {
+	ctx := contextOf(macro.Env)
+	ctx.pushFrame(call, macro)
+	defer func() {
+		if err != nil {
+			ctx.trace(err)
+		}
+		ctx.popFrame()
+	}()
+
+	if len(call.ActualParams) != len(macro.Parameters) {
+		return nil, newKindError(object.ARGUMENT_ERROR, "wrong number of arguments to macro %s. got=%d, want=%d",
+			call.CallableName, len(call.ActualParams), len(macro.Parameters))
+	}
+
+	env := object.NewEnclosedEnv(macro.Env)
+	for i, param := range macro.Parameters {
+		env.Set(param.Name, &object.Quote{Node: call.ActualParams[i]})
+	}
+
+	evaluated := unwrapReturnValue(evalBlockStatement(macro.Body, env))
+	if evaluated == nil {
+		evaluated = NULL
+	}
+	if isError(evaluated) {
+		return nil, evaluated.(*object.Error)
+	}
+
+	quoted, ok := evaluated.(*object.Quote)
+	if !ok {
+		return nil, newKindError(object.TYPE_ERROR, "macro %s must return a quote, got %s", call.CallableName, evaluated.Type())
+	}
+	return quoted.Node, nil
+}
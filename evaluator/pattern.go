@@ -0,0 +1,151 @@
+package evaluator
+
+import (
+	"fmt"
+
+	"xmonkey/ast"
+	"xmonkey/object"
+)
+
+// evalMatchExpression tries the arms in order, every arm binds its names in its own env,
+// so a failed arm leaves nothing behind.
+func evalMatchExpression(node *ast.MatchExpression, env *object.Environment) object.Object {
+	subject := Eval(node.Subject, env)
+	if isError(subject) {
+		return subject
+	}
+
+	for _, arm := range node.Arms {
+		armEnv := object.NewSlottedEnv(env, arm.Slots)
+
+		mismatch, err := matchPattern(arm.Pattern, subject, armEnv)
+		if err != nil {
+			return err
+		}
+		if mismatch != "" {
+			continue
+		}
+
+		if arm.Guard != nil {
+			guard := Eval(arm.Guard, armEnv)
+			if isError(guard) {
+				return guard
+			}
+			if !isTruthy(guard) {
+				continue
+			}
+		}
+
+		return Eval(arm.Body, armEnv)
+	}
+
+	return newKindError(object.MATCH_ERROR, "no match arm for: %s", subject.Inspect())
+}
+
+// matchPattern checks value against pattern and binds the names of the pattern in env.
+// mismatch is empty when value matches, otherwise it says why not;
+// err is set when evaluating a literal of the pattern failed.
+// On a mismatch some names may already be bound, callers use a fresh env or give up.
+func matchPattern(pattern ast.Pattern, value object.Object, env *object.Environment) (string, object.Object) {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		// _ matches anything without binding
+		if pattern.Name != "_" {
+			bind(env, pattern, value)
+		}
+		return "", nil
+
+	case *ast.LiteralPattern:
+		expected := Eval(pattern.Value, env)
+		if isError(expected) {
+			return "", expected
+		}
+
+		if !object.Equal(expected, value) {
+			return fmt.Sprintf("expected %s, got %s", expected.Inspect(), value.Inspect()), nil
+		}
+		return "", nil
+
+	case *ast.ArrayPattern:
+		return matchArrayPattern(pattern, value, env)
+
+	case *ast.HashPattern:
+		return matchHashPattern(pattern, value, env)
+	}
+
+	return "", newError("unknown pattern: %s", pattern.String())
+}
+
+func matchArrayPattern(pattern *ast.ArrayPattern, value object.Object, env *object.Environment) (string, object.Object) {
+	arr, ok := value.(*object.Array)
+	if !ok {
+		return fmt.Sprintf("expected ARRAY, got %s", value.Type()), nil
+	}
+
+	length, want := arr.Len(), len(pattern.Elements)
+	if pattern.Rest == nil && length != want {
+		return fmt.Sprintf("expected %d elements, got %d", want, length), nil
+	}
+	if pattern.Rest != nil && length < want {
+		return fmt.Sprintf("expected at least %d elements, got %d", want, length), nil
+	}
+
+	for i, el := range pattern.Elements {
+		mismatch, err := matchPattern(el, arr.At(i), env)
+		if mismatch != "" || err != nil {
+			return mismatch, err
+		}
+	}
+
+	if pattern.Rest != nil && pattern.Rest.Name != "_" {
+		bind(env, pattern.Rest, arr.Slice(want, length))
+	}
+
+	return "", nil
+}
+
+func matchHashPattern(pattern *ast.HashPattern, value object.Object, env *object.Environment) (string, object.Object) {
+	hash, ok := value.(*object.Hash)
+	if !ok {
+		return fmt.Sprintf("expected HASH, got %s", value.Type()), nil
+	}
+
+	for i, keyNode := range pattern.Keys {
+		key := Eval(keyNode, env)
+		if isError(key) {
+			return "", key
+		}
+
+		hashKey, ok := object.AsHashable(key)
+		if !ok {
+			return "", newKindError(object.TYPE_ERROR, "unusable as hash key: %s", key.Type())
+		}
+
+		v, ok := hash.Get(hashKey)
+		if !ok {
+			return fmt.Sprintf("missing key %s", key.Inspect()), nil
+		}
+
+		mismatch, err := matchPattern(pattern.Values[i], v, env)
+		if mismatch != "" || err != nil {
+			return mismatch, err
+		}
+	}
+
+	return "", nil
+}
+
+// destructure binds the names of pattern for let and fn params,
+// unlike match there is no other arm to try, so a mismatch is an error.
+func destructure(pattern ast.Pattern, value object.Object, env *object.Environment) object.Object {
+	mismatch, err := matchPattern(pattern, value, env)
+	if err != nil {
+		return err
+	}
+
+	if mismatch != "" {
+		return newKindError(object.MATCH_ERROR, "can not destructure %s into %s: %s", value.Inspect(), pattern.String(), mismatch)
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+package evaluator
+
+import (
+	"xmonkey/ast"
+	"xmonkey/object"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// resolving the names
+// Without Resolve every name is looked up by name, from the env it is used in out to the global one.
+// Resolve gives each name the place of its value ahead of time (ast.Identifier Depth and Slot):
+// a name bound in a fn, block, match arm or catch is a slot of the env Depth levels out,
+// a global (bound at the top level, or a builtin) is looked up by name, Depth levels out.
+//
+// The scopes are the envs Eval makes, as WalkScopes finds them.
+// A name refers to the innermost scope binding it anywhere, before or after the use;
+// if that slot is still empty when the name is used, the lookup goes on by name outside,
+// as it did before Resolve, so a use before the let finds the same value (or none).
+
+// Scoping is how the names of a program are scoped. Eval takes it from the Context,
+// Resolve and analysis.Resolve have to be given the same.
+type Scoping struct {
+	// LeakBlockScope makes the blocks of if/else and try run in the enclosing env, as they used to,
+	// so a let inside an if is still seen after it. Off by default: a block has its own env.
+	LeakBlockScope bool
+}
+
+// Resolve annotates program for Eval with scoping, it has to run again when the program or the scoping changes
+func Resolve(program *ast.Program, scoping Scoping) {
+	r := &resolver{scopes: map[*Scope]*scope{}}
+	WalkScopes(program, scoping, r)
+
+	for _, use := range r.uses {
+		use.scope.resolve(use.ident)
+	}
+	for _, s := range r.slotted {
+		*s.names = s.scope.names
+	}
+}
+
+// scope is an env of the program, the global one binds by name
+type scope struct {
+	outer  *scope
+	global bool
+	names  []string
+}
+
+// declare gives a name bound in s its slot, the same one each time it is bound
+func (s *scope) declare(ident *ast.Identifier) {
+	ident.Depth, ident.Slot = 0, s.slot(ident.Name)
+	if s.global || ident.Slot > 0 {
+		return
+	}
+	s.names = append(s.names, ident.Name)
+	ident.Slot = len(s.names)
+}
+
+func (s *scope) slot(name string) int {
+	for i, n := range s.names {
+		if n == name {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func (s *scope) resolve(ident *ast.Identifier) {
+	depth := 0
+	for ; !s.global; s = s.outer {
+		if slot := s.slot(ident.Name); slot > 0 {
+			ident.Depth, ident.Slot = depth, slot
+			return
+		}
+		depth++
+	}
+	ident.Depth, ident.Slot = depth, 0
+}
+
+// resolver is the ScopeVisitor of Resolve
+type resolver struct {
+	// the scopes of WalkScopes, none for what is inside a macro
+	scopes map[*Scope]*scope
+
+	// the names used, resolved once all scopes know all their names
+	uses []struct {
+		ident *ast.Identifier
+		scope *scope
+	}
+
+	// the Slots of the blocks, calls, arms and catches, set at the end as well
+	slotted []struct {
+		names *[]string
+		scope *scope
+	}
+}
+
+func (r *resolver) Scope(s *Scope) {
+	if s.Outer == nil {
+		r.scopes[s] = &scope{global: true}
+		return
+	}
+
+	outer := r.scopes[s.Outer]
+	if outer == nil {
+		return
+	}
+
+	var names *[]string
+	switch node := s.Node.(type) {
+	case *ast.BlockStatement:
+		names = &node.Slots
+	case *ast.FunctionLiteral:
+		names = &node.Body.Slots
+	case *ast.MatchArm:
+		names = &node.Slots
+	case *ast.TryExpression:
+		names = &node.CatchSlots
+	case *ast.MacroLiteral:
+		// a macro left in the program fails when it runs, DefineMacros takes the others out
+		return
+	}
+
+	r.scopes[s] = &scope{outer: outer}
+	if names != nil {
+		r.slotted = append(r.slotted, struct {
+			names *[]string
+			scope *scope
+		}{names, r.scopes[s]})
+	}
+}
+
+func (r *resolver) Bind(s *Scope, ident *ast.Identifier, kind BindingKind, value ast.Expression) {
+	if bound := r.scopes[s]; bound != nil {
+		bound.declare(ident)
+	}
+}
+
+func (r *resolver) Use(s *Scope, ident *ast.Identifier) {
+	if used := r.scopes[s]; used != nil {
+		r.uses = append(r.uses, struct {
+			ident *ast.Identifier
+			scope *scope
+		}{ident, used})
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// binding and looking up, with or without Resolve
+
+// bind sets the value of the name ident binds in env
+func bind(env *object.Environment, ident *ast.Identifier, val object.Object) {
+	if ident.Slot > 0 && ident.Slot <= env.NumSlots() {
+		env.SetSlot(ident.Slot-1, val)
+		return
+	}
+	env.Set(ident.Name, val)
+}
+
+// lookup finds the value of the name ident uses in env, see Resolve
+func lookup(env *object.Environment, ident *ast.Identifier) (object.Object, bool) {
+	scope := env.Outer(ident.Depth)
+	if scope == nil {
+		// not resolved for this env, the names are still right
+		return env.Get(ident.Name)
+	}
+
+	if ident.Slot == 0 {
+		return scope.Get(ident.Name)
+	}
+
+	if val := scope.Slot(ident.Slot - 1); val != nil {
+		return val, true
+	}
+	// used before it is bound, a name outside may have it
+	if outer := scope.Outer(1); outer != nil {
+		return outer.Get(ident.Name)
+	}
+	return nil, false
+}
@@ -0,0 +1,312 @@
+package evaluator
+
+import (
+	"xmonkey/ast"
+	"xmonkey/token"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// the scopes of a program
+// WalkScopes finds the envs Eval makes for a program, the names bound in each and the names used, without running it.
+// Resolve gives the names their slots from it, analysis.Resolve its symbols: both see the same scopes.
+//
+// The scopes are the program, one per call of a fn (params and lets of the body),
+// one per if/else/try/finally block unless Scoping.LeakBlockScope, one per match arm, one for the catch param,
+// and one for the name of a named fn expression.
+// The fn declarations of a block are bound before its statements, as hoistFunctions does.
+// The bodies of fns and macros are walked after the code around them, they run when called:
+// by then the names bound later in the outer scopes are bound too.
+
+// BindingKind says how a name gets bound
+type BindingKind string
+
+const (
+	LET_BINDING      BindingKind = "let"
+	PARAM_BINDING    BindingKind = "param"
+	FUNCTION_BINDING BindingKind = "fn"
+	CATCH_BINDING    BindingKind = "catch"
+	MATCH_BINDING    BindingKind = "match"
+)
+
+// Scope is an env Eval makes, as found in the source
+type Scope struct {
+	// the scope around this one, nil for the program
+	Outer *Scope
+	// what the env is made for: the *ast.Program, the *ast.BlockStatement of an if/else/try/finally,
+	// the *ast.FunctionLiteral or *ast.MacroLiteral of a call, an *ast.MatchArm,
+	// the *ast.TryExpression of a catch param, the *ast.Identifier naming a fn expression
+	Node ast.Node
+	// where the scope starts and ends, End is zero for the program, which never ends
+	Start, End token.Token
+}
+
+// ScopeVisitor is told what WalkScopes finds, in the order it finds it
+type ScopeVisitor interface {
+	// Scope is a new scope, inside scope.Outer
+	Scope(scope *Scope)
+	// Bind is ident binding a name in scope; value is what is bound when it is known:
+	// the value of `let x = value`, the literal of a fn, nil for params and patterns
+	Bind(scope *Scope, ident *ast.Identifier, kind BindingKind, value ast.Expression)
+	// Use is ident used as a value in scope
+	Use(scope *Scope, ident *ast.Identifier)
+}
+
+// WalkScopes tells v the scopes program has when it runs with scoping
+func WalkScopes(program *ast.Program, scoping Scoping, v ScopeVisitor) {
+	w := &scopeWalker{scoping: scoping, visitor: v}
+
+	s := w.newScope(nil, program, token.Token{Line: 1, Column: 1}, token.Token{})
+	w.statements(program.Statements, s)
+
+	for len(w.deferred) > 0 {
+		next := w.deferred[0]
+		w.deferred = w.deferred[1:]
+		next()
+	}
+}
+
+type scopeWalker struct {
+	scoping Scoping
+	visitor ScopeVisitor
+
+	// fn and macro bodies waiting for the code around them
+	deferred []func()
+	// declarations already bound by hoisting
+	hoisted map[*ast.FunctionLiteral]bool
+}
+
+func (w *scopeWalker) newScope(outer *Scope, node ast.Node, start, end token.Token) *Scope {
+	s := &Scope{Outer: outer, Node: node, Start: start, End: end}
+	w.visitor.Scope(s)
+	return s
+}
+
+func (w *scopeWalker) bind(s *Scope, ident *ast.Identifier, kind BindingKind, value ast.Expression) {
+	if ident != nil {
+		w.visitor.Bind(s, ident, kind, value)
+	}
+}
+
+func (w *scopeWalker) statements(stmts []ast.Statement, s *Scope) {
+	for _, stmt := range stmts {
+		if fn, ok := functionDeclaration(stmt); ok {
+			if w.hoisted == nil {
+				w.hoisted = map[*ast.FunctionLiteral]bool{}
+			}
+			w.hoisted[fn] = true
+			w.bind(s, fn.Name, FUNCTION_BINDING, fn)
+		}
+	}
+
+	for _, stmt := range stmts {
+		w.statement(stmt, s)
+	}
+}
+
+func (w *scopeWalker) statement(stmt ast.Statement, s *Scope) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		w.expression(stmt.Expr, s)
+		if stmt.Pattern != nil {
+			w.pattern(stmt.Pattern, LET_BINDING, s)
+		} else {
+			w.bind(s, stmt.Name, LET_BINDING, stmt.Expr)
+		}
+
+	case *ast.ReturnStatement:
+		w.expression(stmt.Expr, s)
+
+	case *ast.ThrowStatement:
+		w.expression(stmt.Expr, s)
+
+	case *ast.ExpressionStatement:
+		w.expression(stmt.Expr, s)
+
+	case *ast.BlockStatement:
+		w.block(stmt, s)
+	}
+}
+
+func (w *scopeWalker) block(block *ast.BlockStatement, s *Scope) {
+	if block == nil {
+		return
+	}
+	if w.scoping.LeakBlockScope {
+		w.statements(block.Statements, s)
+		return
+	}
+	w.statements(block.Statements, w.newScope(s, block, block.Token, block.Rbrace))
+}
+
+// pattern binds the names of a let, param or match arm pattern, _ is not bound;
+// the expressions inside it (literals, hash keys) are used first
+func (w *scopeWalker) pattern(pattern ast.Pattern, kind BindingKind, s *Scope) {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		if pattern.Name != "_" {
+			w.bind(s, pattern, kind, nil)
+		}
+
+	case *ast.LiteralPattern:
+		w.expression(pattern.Value, s)
+
+	case *ast.ArrayPattern:
+		for _, el := range pattern.Elements {
+			w.pattern(el, kind, s)
+		}
+		if pattern.Rest != nil && pattern.Rest.Name != "_" {
+			w.bind(s, pattern.Rest, kind, nil)
+		}
+
+	case *ast.HashPattern:
+		for i, key := range pattern.Keys {
+			w.expression(key, s)
+			w.pattern(pattern.Values[i], kind, s)
+		}
+	}
+}
+
+func (w *scopeWalker) expression(expr ast.Expression, s *Scope) {
+	switch expr := expr.(type) {
+	case *ast.Identifier:
+		w.visitor.Use(s, expr)
+
+	case *ast.ArrayLiteral:
+		for _, el := range expr.Elements {
+			w.expression(el, s)
+		}
+
+	case *ast.HashLiteral:
+		for _, key := range expr.Keys {
+			w.expression(key, s)
+			w.expression(expr.Pairs[key], s)
+		}
+
+	case *ast.PrefixExpression:
+		w.expression(expr.Right, s)
+
+	case *ast.InfixExpression:
+		w.expression(expr.Left, s)
+		w.expression(expr.Right, s)
+
+	case *ast.ConditionalExpression:
+		w.expression(expr.Condition, s)
+		w.expression(expr.Consequence, s)
+		w.expression(expr.Alternative, s)
+
+	case *ast.CallExpression:
+		w.expression(expr.CallableName, s)
+		if isCallTo(expr, "quote") {
+			w.quoted(expr.ActualParams, s)
+			return
+		}
+		for _, arg := range expr.ActualParams {
+			w.expression(arg, s)
+		}
+
+	case *ast.IndexExpression:
+		w.expression(expr.Left, s)
+		w.expression(expr.Index, s)
+
+	case *ast.SliceExpression:
+		w.expression(expr.Left, s)
+		if expr.Start != nil {
+			w.expression(expr.Start, s)
+		}
+		if expr.End != nil {
+			w.expression(expr.End, s)
+		}
+
+	case *ast.IfExpression:
+		w.expression(expr.Condition, s)
+		w.block(expr.Consequence, s)
+		w.block(expr.Alternative, s)
+
+	case *ast.TryExpression:
+		w.block(expr.Block, s)
+		if expr.Catch != nil {
+			// with LeakBlockScope the lets of the catch block are bound next to the param
+			start := expr.Catch.Token
+			if expr.CatchParam != nil {
+				start = expr.CatchParam.Token
+			}
+			catch := w.newScope(s, expr, start, expr.Catch.Rbrace)
+			w.bind(catch, expr.CatchParam, CATCH_BINDING, nil)
+			w.block(expr.Catch, catch)
+		}
+		w.block(expr.Finally, s)
+
+	case *ast.MatchExpression:
+		w.expression(expr.Subject, s)
+		for i, arm := range expr.Arms {
+			end := expr.Rbrace
+			if i+1 < len(expr.Arms) {
+				end = expr.Arms[i+1].Token
+			}
+
+			armScope := w.newScope(s, arm, arm.Token, end)
+			w.pattern(arm.Pattern, MATCH_BINDING, armScope)
+			if arm.Guard != nil {
+				w.expression(arm.Guard, armScope)
+			}
+			w.expression(arm.Body, armScope)
+		}
+
+	case *ast.FunctionLiteral:
+		w.function(expr, s)
+
+	case *ast.MacroLiteral:
+		w.macro(expr, s)
+	}
+}
+
+// quoted uses only the unquote(...) inside quote(...),
+// the rest is code for later, its names mean something where the quote ends up, not here
+func (w *scopeWalker) quoted(args []ast.Expression, s *Scope) {
+	for _, arg := range args {
+		ast.Inspect(arg, func(node ast.Node) bool {
+			if call, ok := node.(*ast.CallExpression); ok && isCallTo(call, "unquote") {
+				w.expression(call, s)
+				return false
+			}
+			return true
+		})
+	}
+}
+
+// function makes the scope of the calls of fn now, and walks its body once the code around it is done.
+// let fact = fn f(n) { ... } binds f in an env of its own, see Eval; a declaration is bound by statements.
+func (w *scopeWalker) function(fn *ast.FunctionLiteral, s *Scope) {
+	if fn.Body == nil {
+		return
+	}
+
+	if fn.Name != nil && !w.hoisted[fn] {
+		s = w.newScope(s, fn.Name, fn.Token, fn.Body.Rbrace)
+		w.bind(s, fn.Name, FUNCTION_BINDING, fn)
+	}
+
+	// the body runs in the call env directly, no scope of its own
+	call := w.newScope(s, fn, fn.Token, fn.Body.Rbrace)
+	w.deferred = append(w.deferred, func() {
+		for _, param := range fn.FormalParams {
+			w.pattern(param, PARAM_BINDING, call)
+		}
+		w.statements(fn.Body.Statements, call)
+	})
+}
+
+// macro is walked like a fn, its params hold the quoted args of a call
+func (w *scopeWalker) macro(macro *ast.MacroLiteral, s *Scope) {
+	if macro.Body == nil {
+		return
+	}
+
+	call := w.newScope(s, macro, macro.Token, macro.Body.Rbrace)
+	w.deferred = append(w.deferred, func() {
+		for _, param := range macro.Parameters {
+			w.bind(call, param, PARAM_BINDING, nil)
+		}
+		w.statements(macro.Body.Statements, call)
+	})
+}
@@ -0,0 +1,634 @@
+// Package format prints monkey source in the canonical layout:
+// 4 spaces indentation, one statement per line, spaces around infix operators,
+// and parentheses only where the precedence needs them.
+//
+// The output parses back to the same ast, and formatting it again changes nothing.
+package format
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strings"
+
+	"xmonkey/ast"
+	"xmonkey/lexer"
+	"xmonkey/parser"
+	"xmonkey/token"
+)
+
+// Source formats the source code, keeping its // comments and (at most one) blank line between statements.
+// The error lists the parser errors if src does not parse.
+func Source(src string) (string, error) {
+	l := lexer.New(src)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return "", errors.New(strings.Join(p.Errors(), "\n"))
+	}
+
+	pr := &printer{comments: l.Comments(), lines: strings.Split(src, "\n")}
+	pr.statements(program.Statements, math.MaxInt32)
+
+	return pr.out.String(), nil
+}
+
+// Node formats a node built or changed in code, there are no comments or blank lines to keep.
+func Node(node ast.Node) string {
+	pr := &printer{}
+
+	switch node := node.(type) {
+	case *ast.Program:
+		pr.statements(node.Statements, 0)
+	case *ast.BlockStatement:
+		pr.block(node)
+	case ast.Statement:
+		pr.statement(node)
+	case ast.Expression:
+		pr.expr(node, parser.LOWEST)
+	}
+
+	return pr.out.String()
+}
+
+type printer struct {
+	out    bytes.Buffer
+	indent int
+
+	// the comments not printed yet, in source order
+	comments []token.Token
+
+	// the source lines, nil for Node
+	lines []string
+
+	// the line of the last statement or comment printed
+	prevLine int
+
+	// the source line the code of the last printed line starts at, 0 if that line is a comment
+	codeLine int
+}
+
+func (p *printer) writeIndent() {
+	p.out.WriteString(strings.Repeat("    ", p.indent))
+}
+
+func (p *printer) lastByte() byte {
+	if p.out.Len() == 0 {
+		return 0
+	}
+	return p.out.Bytes()[p.out.Len()-1]
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// comments and blank lines, both only known from the source
+
+// flushComments prints the comments before line.
+// A comment that followed some code in the source goes back to the end of the last printed line
+// if that line is code from before the comment, the others get their own line.
+func (p *printer) flushComments(line int) {
+	for len(p.comments) > 0 && p.comments[0].Line < line {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+
+		if p.trailing(c) && p.lastByte() == '\n' && p.codeLine > 0 && p.codeLine <= c.Line {
+			p.out.Truncate(p.out.Len() - 1)
+			p.out.WriteString("  " + c.RawString + "\n")
+		} else {
+			p.blankLine(c.Line)
+			p.writeIndent()
+			p.out.WriteString(c.RawString + "\n")
+		}
+
+		p.prevLine = c.Line
+		p.codeLine = 0
+	}
+}
+
+func (p *printer) hasCommentsBefore(line int) bool {
+	return len(p.comments) > 0 && p.comments[0].Line < line
+}
+
+// hasCommentsInside tells if a comment is between the lines of open and close,
+// a // comment on the line of open is after it (it goes to the end of the line), one on the line of close before it
+func (p *printer) hasCommentsInside(open, close token.Token) bool {
+	for _, c := range p.comments {
+		if c.Line >= close.Line {
+			break
+		}
+		if c.Line >= open.Line {
+			return true
+		}
+	}
+	return false
+}
+
+// startLine starts a line of code which begins at line in the source
+func (p *printer) startLine(line int) {
+	p.writeIndent()
+	p.codeLine = line
+	p.prevLine = line
+}
+
+// there is code before the comment on its line
+func (p *printer) trailing(c token.Token) bool {
+	if p.lines == nil || c.Line > len(p.lines) {
+		return false
+	}
+
+	return strings.TrimSpace(p.lines[c.Line-1][:c.Column-1]) != ""
+}
+
+// blankLine keeps one blank line before what starts at line, if the source had one,
+// but not at the start of a block
+func (p *printer) blankLine(line int) {
+	if p.lines == nil || line < 2 || line-1 <= p.prevLine || line-1 > len(p.lines) {
+		return
+	}
+
+	if strings.TrimSpace(p.lines[line-2]) != "" {
+		return
+	}
+
+	out := p.out.Bytes()
+	if len(out) < 2 || strings.IndexByte("{[(\n", out[len(out)-2]) >= 0 {
+		return
+	}
+
+	p.out.WriteString("\n")
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// statements
+
+func statementToken(stmt ast.Statement) token.Token {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		return stmt.Token
+	case *ast.ReturnStatement:
+		return stmt.Token
+	case *ast.ThrowStatement:
+		return stmt.Token
+	case *ast.ExpressionStatement:
+		return stmt.Token
+	case *ast.BlockStatement:
+		return stmt.Token
+	}
+
+	return token.Token{}
+}
+
+// statements prints one statement per line, then the comments before end (the line of the closing brace).
+//
+// let, return and throw always end with ;
+// an expression statement needs it only when it is not the last one,
+// and after a } only if the next statement would continue it: fn f() { };(1)
+func (p *printer) statements(stmts []ast.Statement, end int) {
+	// where the ; goes, if the next statement needs it
+	semi := -1
+
+	for i, stmt := range stmts {
+		line := statementToken(stmt).Line
+
+		p.flushComments(line)
+		p.blankLine(line)
+		p.startLine(line)
+
+		start := p.out.Len()
+		p.statement(stmt)
+
+		if semi >= 0 && strings.IndexByte("([-", p.out.Bytes()[start]) >= 0 {
+			p.insert(semi, ";")
+		}
+		semi = -1
+
+		if _, ok := stmt.(*ast.ExpressionStatement); ok && i < len(stmts)-1 {
+			if p.lastByte() == '}' {
+				semi = p.out.Len()
+			} else {
+				p.out.WriteString(";")
+			}
+		}
+
+		p.out.WriteString("\n")
+		p.prevLine = line
+	}
+
+	p.flushComments(end)
+}
+
+func (p *printer) insert(pos int, s string) {
+	out := p.out.Bytes()
+
+	var b bytes.Buffer
+	b.Write(out[:pos])
+	b.WriteString(s)
+	b.Write(out[pos:])
+
+	p.out = b
+}
+
+func (p *printer) statement(stmt ast.Statement) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		p.out.WriteString("let ")
+		if stmt.Pattern != nil {
+			p.pattern(stmt.Pattern)
+		} else {
+			p.out.WriteString(stmt.Name.Name)
+		}
+		// the String of a type is already in the canonical form
+		if stmt.Type != nil {
+			p.out.WriteString(": " + stmt.Type.String())
+		}
+		p.out.WriteString(" = ")
+		p.expr(stmt.Expr, parser.LOWEST)
+		p.out.WriteString(";")
+
+	case *ast.ReturnStatement:
+		p.out.WriteString("return ")
+		p.expr(stmt.Expr, parser.LOWEST)
+		p.out.WriteString(";")
+
+	case *ast.ThrowStatement:
+		p.out.WriteString("throw ")
+		p.expr(stmt.Expr, parser.LOWEST)
+		p.out.WriteString(";")
+
+	case *ast.ExpressionStatement:
+		p.expr(stmt.Expr, parser.LOWEST)
+
+	case *ast.BlockStatement:
+		p.block(stmt)
+	}
+}
+
+// { statements }, or {} if there is nothing inside
+func (p *printer) block(block *ast.BlockStatement) {
+	p.out.WriteString("{")
+
+	if len(block.Statements) == 0 && !p.hasCommentsBefore(block.Rbrace.Line) {
+		p.out.WriteString("}")
+		return
+	}
+
+	p.out.WriteString("\n")
+	p.indent++
+	p.statements(block.Statements, block.Rbrace.Line)
+	p.indent--
+
+	p.writeIndent()
+	p.out.WriteString("}")
+	p.codeLine = block.Rbrace.Line
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// expressions
+
+// precedence of the expression as an operand, the same levels the parser uses
+func precedence(expr ast.Expression) int {
+	switch expr := expr.(type) {
+	case *ast.InfixExpression:
+		return parser.Precedence(expr.Operator)
+	case *ast.PrefixExpression:
+		return parser.PREFIX
+	case *ast.ConditionalExpression:
+		return parser.TERNARY
+	case *ast.CallExpression:
+		return parser.CALL
+	case *ast.IndexExpression, *ast.SliceExpression:
+		return parser.INDEX
+	}
+
+	// literals, names and the expressions starting with a keyword never need parentheses
+	return parser.INDEX + 1
+}
+
+// expr prints expr in parentheses if it binds looser than min
+func (p *printer) expr(expr ast.Expression, min int) {
+	if precedence(expr) < min {
+		p.out.WriteString("(")
+		p.expr(expr, parser.LOWEST)
+		p.out.WriteString(")")
+		return
+	}
+
+	switch expr := expr.(type) {
+	case *ast.Identifier:
+		p.out.WriteString(expr.Name)
+
+	case *ast.IntegerLiteral, *ast.Boolean:
+		p.out.WriteString(expr.String())
+
+	case *ast.StringLiteral:
+		p.out.WriteString(`"` + expr.Value + `"`)
+
+	case *ast.ArrayLiteral:
+		p.list("[", "]", expr.Token, expr.Rbracket, expr.Elements, p.element)
+
+	case *ast.HashLiteral:
+		p.list("{", "}", expr.Token, expr.Rbrace, expr.Keys, func(key ast.Expression) {
+			p.expr(key, parser.LOWEST)
+			p.out.WriteString(": ")
+			p.expr(expr.Pairs[key], parser.LOWEST)
+		})
+
+	case *ast.PrefixExpression:
+		p.out.WriteString(expr.Operator)
+		p.expr(expr.Right, parser.PREFIX)
+
+	case *ast.InfixExpression:
+		p.infix(expr)
+
+	case *ast.ConditionalExpression:
+		// a ? b : c ? d : e is a ? b : (c ? d : e), only the condition may need parentheses
+		p.expr(expr.Condition, parser.TERNARY+1)
+		p.out.WriteString(" ? ")
+		p.expr(expr.Consequence, parser.LOWEST)
+		p.out.WriteString(" : ")
+		p.expr(expr.Alternative, parser.LOWEST)
+
+	case *ast.CallExpression:
+		p.expr(expr.CallableName, parser.CALL)
+		p.list("(", ")", expr.Token, expr.Rparen, expr.ActualParams, p.element)
+
+	case *ast.IndexExpression:
+		p.expr(expr.Left, parser.CALL)
+		if field, ok := expr.Index.(*ast.StringLiteral); ok && expr.Optional && field.Token.Type == token.IDENT {
+			// obj?.field
+			p.out.WriteString("?." + field.Value)
+			return
+		}
+		if expr.Optional {
+			p.out.WriteString("?.")
+		}
+		p.out.WriteString("[")
+		p.expr(expr.Index, parser.LOWEST)
+		p.out.WriteString("]")
+
+	case *ast.SliceExpression:
+		p.expr(expr.Left, parser.CALL)
+		if expr.Optional {
+			p.out.WriteString("?.")
+		}
+		p.out.WriteString("[")
+		if expr.Start != nil {
+			p.expr(expr.Start, parser.LOWEST)
+		}
+		p.out.WriteString(":")
+		if expr.End != nil {
+			p.expr(expr.End, parser.LOWEST)
+		}
+		p.out.WriteString("]")
+
+	case *ast.FunctionLiteral:
+		p.out.WriteString("fn")
+		if expr.Name != nil {
+			p.out.WriteString(" " + expr.Name.Name)
+		}
+		p.out.WriteString("(")
+		for i, param := range expr.FormalParams {
+			if i > 0 {
+				p.out.WriteString(", ")
+			}
+			p.pattern(param)
+			if expr.ParamTypes != nil && expr.ParamTypes[i] != nil {
+				p.out.WriteString(": " + expr.ParamTypes[i].String())
+			}
+		}
+		p.out.WriteString(")")
+		if expr.ReturnType != nil {
+			p.out.WriteString(": " + expr.ReturnType.String())
+		}
+		p.out.WriteString(" ")
+		p.block(expr.Body)
+
+	case *ast.MacroLiteral:
+		p.out.WriteString("macro(")
+		for i, param := range expr.Parameters {
+			if i > 0 {
+				p.out.WriteString(", ")
+			}
+			p.out.WriteString(param.Name)
+		}
+		p.out.WriteString(") ")
+		p.block(expr.Body)
+
+	case *ast.IfExpression:
+		p.out.WriteString("if (")
+		p.expr(expr.Condition, parser.LOWEST)
+		p.out.WriteString(") ")
+		p.block(expr.Consequence)
+		if expr.Alternative != nil {
+			p.out.WriteString(" else ")
+			p.block(expr.Alternative)
+		}
+
+	case *ast.TryExpression:
+		p.out.WriteString("try ")
+		p.block(expr.Block)
+		if expr.Catch != nil {
+			p.out.WriteString(" catch ")
+			if expr.CatchParam != nil {
+				p.out.WriteString("(" + expr.CatchParam.Name + ") ")
+			}
+			p.block(expr.Catch)
+		}
+		if expr.Finally != nil {
+			p.out.WriteString(" finally ")
+			p.block(expr.Finally)
+		}
+
+	case *ast.MatchExpression:
+		p.match(expr)
+
+	case ast.Pattern:
+		p.pattern(expr)
+	}
+}
+
+func (p *printer) element(expr ast.Expression) {
+	p.expr(expr, parser.LOWEST)
+}
+
+// list prints the elements between open and close on one line: [1, 2],
+// or one element a line when there are comments inside, so each stays with the element it is next to:
+//
+//	[
+//	    1,  // one
+//	    // two
+//	    2
+//	]
+func (p *printer) list(open, close string, openTok, closeTok token.Token, elements []ast.Expression, print func(ast.Expression)) {
+	p.out.WriteString(open)
+
+	if !p.hasCommentsInside(openTok, closeTok) {
+		for i, el := range elements {
+			if i > 0 {
+				p.out.WriteString(", ")
+			}
+			print(el)
+		}
+		p.out.WriteString(close)
+		return
+	}
+
+	p.out.WriteString("\n")
+	p.indent++
+	for i, el := range elements {
+		line := startToken(el).Line
+
+		p.flushComments(line)
+		p.startLine(line)
+		print(el)
+		if i < len(elements)-1 {
+			p.out.WriteString(",")
+		}
+		p.out.WriteString("\n")
+	}
+	p.flushComments(closeTok.Line)
+	p.indent--
+
+	p.writeIndent()
+	p.out.WriteString(close)
+	p.codeLine = closeTok.Line
+}
+
+// startToken is the first token of expr in the source, the token of an infix or a call is in the middle
+func startToken(expr ast.Expression) token.Token {
+	switch expr := expr.(type) {
+	case *ast.InfixExpression:
+		return startToken(expr.Left)
+	case *ast.CallExpression:
+		return startToken(expr.CallableName)
+	case *ast.IndexExpression:
+		return startToken(expr.Left)
+	case *ast.SliceExpression:
+		return startToken(expr.Left)
+	case *ast.ConditionalExpression:
+		return startToken(expr.Condition)
+	case *ast.Identifier:
+		return expr.Token
+	case *ast.IntegerLiteral:
+		return expr.Token
+	case *ast.Boolean:
+		return expr.Token
+	case *ast.StringLiteral:
+		return expr.Token
+	case *ast.ArrayLiteral:
+		return expr.Token
+	case *ast.HashLiteral:
+		return expr.Token
+	case *ast.PrefixExpression:
+		return expr.Token
+	case *ast.FunctionLiteral:
+		return expr.Token
+	case *ast.MacroLiteral:
+		return expr.Token
+	case *ast.IfExpression:
+		return expr.Token
+	case *ast.TryExpression:
+		return expr.Token
+	case *ast.MatchExpression:
+		return expr.Token
+	}
+
+	return token.Token{}
+}
+
+func (p *printer) infix(expr *ast.InfixExpression) {
+	prec := parser.Precedence(expr.Operator)
+
+	// left associative: a - (b - c) needs the parentheses, (a - b) - c does not;
+	// ** is the other way round
+	left, right := prec, prec+1
+	if expr.Operator == token.POWER {
+		left, right = prec+1, prec
+	}
+
+	p.expr(expr.Left, left)
+	p.out.WriteString(" " + expr.Operator + " ")
+	p.expr(expr.Right, right)
+}
+
+//	match (x) {
+//	    pattern => body,
+//	}
+func (p *printer) match(expr *ast.MatchExpression) {
+	p.out.WriteString("match (")
+	p.expr(expr.Subject, parser.LOWEST)
+	p.out.WriteString(") {")
+
+	if len(expr.Arms) == 0 {
+		p.out.WriteString("}")
+		return
+	}
+
+	p.out.WriteString("\n")
+	p.indent++
+	for _, arm := range expr.Arms {
+		p.flushComments(arm.Token.Line)
+		p.startLine(arm.Token.Line)
+		p.pattern(arm.Pattern)
+		if arm.Guard != nil {
+			p.out.WriteString(" if ")
+			p.expr(arm.Guard, parser.LOWEST)
+		}
+		p.out.WriteString(" => ")
+		p.expr(arm.Body, parser.LOWEST)
+		p.out.WriteString(",\n")
+	}
+	p.flushComments(expr.Rbrace.Line)
+	p.indent--
+
+	p.writeIndent()
+	p.out.WriteString("}")
+	p.codeLine = expr.Rbrace.Line
+}
+
+func (p *printer) pattern(pattern ast.Pattern) {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		p.out.WriteString(pattern.Name)
+
+	case *ast.LiteralPattern:
+		p.expr(pattern.Value, parser.LOWEST)
+
+	case *ast.ArrayPattern:
+		p.out.WriteString("[")
+		for i, el := range pattern.Elements {
+			if i > 0 {
+				p.out.WriteString(", ")
+			}
+			p.pattern(el)
+		}
+		if pattern.Rest != nil {
+			if len(pattern.Elements) > 0 {
+				p.out.WriteString(", ")
+			}
+			p.out.WriteString("..." + pattern.Rest.Name)
+		}
+		p.out.WriteString("]")
+
+	case *ast.HashPattern:
+		p.out.WriteString("{")
+		for i, key := range pattern.Keys {
+			if i > 0 {
+				p.out.WriteString(", ")
+			}
+
+			// {name} is short for {"name": name}
+			field, ok := key.(*ast.StringLiteral)
+			ident, isIdent := pattern.Values[i].(*ast.Identifier)
+			if ok && isIdent && field.Token.Type == token.IDENT && ident.Name == field.Value {
+				p.out.WriteString(ident.Name)
+				continue
+			}
+
+			p.expr(key, parser.LOWEST)
+			p.out.WriteString(": ")
+			p.pattern(pattern.Values[i])
+		}
+		p.out.WriteString("}")
+	}
+}
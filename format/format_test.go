@@ -0,0 +1,124 @@
+package format
+
+import (
+	"testing"
+
+	"xmonkey/lexer"
+	"xmonkey/parser"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x=1+2*3", "let x = 1 + 2 * 3;\n"},
+		{"let x = ((1 + 2)) * 3", "let x = (1 + 2) * 3;\n"},
+		{"a - (b - c); (a - b) - c", "a - (b - c);\na - b - c\n"},
+		{"2 ** (3 ** 2); (2 ** 3) ** 2", "2 ** 3 ** 2;\n(2 ** 3) ** 2\n"},
+		{"-(a + b); -(a * b); (-a) * b", "-(a + b);\n-(a * b);\n-a * b\n"},
+		{"(-a)[0]; (f)(x); (a + b)(c); f(x)[0]", "(-a)[0];\nf(x);\n(a + b)(c);\nf(x)[0]\n"},
+		{"a ? (b ? c : d) : (e ? f : g); (a ? b : c) ? d : e", "a ? b ? c : d : e ? f : g;\n(a ? b : c) ? d : e\n"},
+		{"(a && b) || c; a && (b || c)", "a && b || c;\na && (b || c)\n"},
+		{"x&1==0; (x & 1) == 0", "x & 1 == 0;\nx & 1 == 0\n"},
+		{`h?.name; h?.["name"]; a[1:]; a[:-1]; a?.[:2]`, "h?.name;\nh?.[\"name\"];\na[1:];\na[:-1];\na?.[:2]\n"},
+		{`let {name, "age": [a, ...r]} = p`, "let {name, \"age\": [a, ...r]} = p;\n"},
+		{"let f = fn(x) { x }", "let f = fn(x) {\n    x\n};\n"},
+		{"fn f() { }", "fn f() {}\n"},
+		{"let x :int=1; fn f(a:[ int ],b):{string:fn( int,bool ):any} { }", "let x: int = 1;\nfn f(a: [int], b): {string: fn(int, bool): any} {}\n"},
+		{"if (a) { 1 } else { let b = 2; b }", "if (a) {\n    1\n} else {\n    let b = 2;\n    b\n}\n"},
+		{"match (x) { 1 => \"one\", [h, ...t] if h > 0 => t }", "match (x) {\n    1 => \"one\",\n    [h, ...t] if h > 0 => t,\n}\n"},
+		{"try { f() } catch { 0 }", "try {\n    f()\n} catch {\n    0\n}\n"},
+		{"throw   error(\"E\", \"m\")", "throw error(\"E\", \"m\");\n"},
+
+		// semicolons: only where the next statement would continue the previous one
+		{"fn f() { 1 }\nf()", "fn f() {\n    1\n}\nf()\n"},
+		{"if (a) { 1 }; (b)", "if (a) {\n    1\n}\nb\n"},
+		{"if (a) { 1 }; -b", "if (a) {\n    1\n};\n-b\n"},
+		{"if (a) { 1 }; [1]", "if (a) {\n    1\n};\n[1]\n"},
+
+		// comments and blank lines
+		{"// top\nlet a = 1; // one\n\n\n// two\nlet b = 2;\n// end", "// top\nlet a = 1;  // one\n\n// two\nlet b = 2;\n// end\n"},
+		{"fn f() { // starts\n  1 // last\n  // after\n}", "fn f() {  // starts\n    1  // last\n    // after\n}\n"},
+		{"fn f() {\n// only\n}", "fn f() {\n    // only\n}\n"},
+		{"let a = 1; let b = 2;\nlet c = 3;", "let a = 1;\nlet b = 2;\nlet c = 3;\n"},
+		{"\n\nlet a = 1;", "let a = 1;\n"},
+		{"let h = {\n \"a\": 1, // trailing a\n // inside hash\n \"b\": 2 }", "let h = {\n    \"a\": 1,  // trailing a\n    // inside hash\n    \"b\": 2\n};\n"},
+		{"f(1, // one\n  2) // after", "f(\n    1,  // one\n    2\n)  // after\n"},
+		{"let a = [1, 2 // two\n]; // end", "let a = [\n    1,\n    2  // two\n];  // end\n"},
+		{"match (x) {\n  // zero\n  0 => \"z\", // first\n\n  // rest\n  _ => x\n  // last\n}", "match (x) {\n    // zero\n    0 => \"z\",  // first\n\n    // rest\n    _ => x,\n    // last\n}\n"},
+		{"// a\nlet b = [\n// c\n1]", "// a\nlet b = [\n    // c\n    1\n];\n"},
+	}
+
+	for _, tt := range tests {
+		got, err := Source(tt.input)
+		if err != nil {
+			t.Errorf("%q: %s", tt.input, err)
+			continue
+		}
+
+		if got != tt.expected {
+			t.Errorf("%q:\ngot=\n%s\nwant=\n%s", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestSourceError(t *testing.T) {
+	_, err := Source("let = 1")
+	if err == nil || err.Error() != "expect next token to be IDENT. got = instead\nno prefix parse function for = found" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+}
+
+// the output parses to the same ast, and formatting it again changes nothing
+func TestRoundTrip(t *testing.T) {
+	inputs := []string{
+		`let add = fn(a, b) { a + b }; add(1, 2) * -add(3, 4) / 5 % 6`,
+		`let fib = fn(n) { if (n < 2) { return n; } fib(n - 1) + fib(n - 2) }; fib(10)`,
+		`fn is_even(n) { n == 0 ? true : is_odd(n - 1) } fn is_odd(n) { n == 0 ? false : is_even(n - 1) }`,
+		`let h = {"a": [1, 2, {"b": 3}], 1: true, false: "x"}; h["a"][2]["b"] ?? h?.c?.[0]`,
+		`~1 << 2 >> 3 | 4 ^ 5 & 6 <= 7 != (8 >= 9) || !true && false`,
+		`-2 ** 2 + (-2) ** 2 - 2 ** -2`,
+		`let [a, [b, ...c], {d, "e": f}] = x; fn g([h, ..._], {i}) { h + i }`,
+		`let r = try { throw error("K", "m"); 1 } catch (e) { e["kind"] } finally { puts("done") };`,
+		`match (v) { 0 => "zero", -1 => "minus", [x, ...xs] if x > 0 => xs, {"k": [y]} => y, _ => null }`,
+		`s[1:3] + s[:2] + s[-1:] + a?.[1:]`,
+		`fn(x) { x }(1); (fn(x) { x })(2); if (a) { b } else { c }[0]`,
+		`let n: int = 1; let f: fn(int): int = fn(x: int): int { x + n }; fn g(s: string, t): [null] { [] }`,
+		"// comment\nlet a = 1; // trailing\n\nfn f() {\n  // inside\n  a\n}\n// end",
+		"let h = {\n \"a\": 1, // trailing a\n // inside hash\n \"b\": [2, // two\n 3], \"c\": {\"d\": 4, // d\n}, // c\n}",
+		"let r = match (x) {\n  // zero\n  0 => \"z\", // first\n  [a, ...b] if a > 0 => f(a, // arg\n   b),\n  _ => if (x) { // block\n   x }, // default\n} // end",
+		"puts(fn(x) { x }, // fn\n  {\"k\": [ // open\n  1]})",
+	}
+
+	for _, input := range inputs {
+		formatted, err := Source(input)
+		if err != nil {
+			t.Errorf("%q: %s", input, err)
+			continue
+		}
+
+		if want, got := parse(t, input), parse(t, formatted); got != want {
+			t.Errorf("%q: ast changed.\nformatted=\n%s\nwant=%s\ngot=%s", input, formatted, want, got)
+		}
+
+		again, err := Source(formatted)
+		if err != nil {
+			t.Errorf("%q: formatted does not parse: %s", formatted, err)
+			continue
+		}
+		if again != formatted {
+			t.Errorf("not idempotent.\nfirst=\n%s\nsecond=\n%s", formatted, again)
+		}
+	}
+}
+
+func parse(t *testing.T, input string) string {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("%q: parser errors %v", input, p.Errors())
+	}
+
+	return program.String()
+}
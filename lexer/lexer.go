@@ -1,22 +1,41 @@
 package lexer
 
-import "xmonkey/token"
+import (
+	"strings"
+
+	"xmonkey/token"
+)
 
 type Lexer struct {
 	input        string
 	position     int
 	readPosition int
 	ch           byte
+
+	// where ch is, for the positions of the tokens
+	line   int
+	column int
+
+	// the // comments skipped so far, the parser never sees them
+	comments []token.Token
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	l := &Lexer{input: input, line: 1}
 	l.readChar()
 
 	return l
 }
 
 func (l *Lexer) readChar() {
+	// the char being left decides where the next one is
+	if l.ch == '\n' {
+		l.line++
+		l.column = 1
+	} else {
+		l.column++
+	}
+
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -41,6 +60,8 @@ func (l *Lexer) NextToken() token.Token {
 
 	l.skipWhitespace()
 
+	line, column := l.line, l.column
+
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
@@ -50,6 +71,9 @@ func (l *Lexer) NextToken() token.Token {
 			l.readChar()
 			// tok = token.Token{Type: token.EQ, Literal: string(ch) + string(l.ch)}
 			tok = token.Token{Type: token.EQ, RawString: "=="}
+		} else if l.peekChar() == '>' {
+			l.readChar()
+			tok = token.Token{Type: token.ARROW, RawString: "=>"}
 		} else {
 			tok = newToken(token.ASSIGN, l.ch)
 		}
@@ -69,11 +93,52 @@ func (l *Lexer) NextToken() token.Token {
 	case '/':
 		tok = newToken(token.SLASH, l.ch)
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		if l.peekChar() == '*' {
+			l.readChar()
+			tok = token.Token{Type: token.POWER, RawString: "**"}
+		} else {
+			tok = newToken(token.ASTERISK, l.ch)
+		}
+	case '%':
+		tok = newToken(token.PERCENT, l.ch)
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok = token.Token{Type: token.LT_EQ, RawString: "<="}
+		} else if l.peekChar() == '<' {
+			l.readChar()
+			tok = token.Token{Type: token.SHIFT_LEFT, RawString: "<<"}
+		} else {
+			tok = newToken(token.LT, l.ch)
+		}
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok = token.Token{Type: token.GT_EQ, RawString: ">="}
+		} else if l.peekChar() == '>' {
+			l.readChar()
+			tok = token.Token{Type: token.SHIFT_RIGHT, RawString: ">>"}
+		} else {
+			tok = newToken(token.GT, l.ch)
+		}
+	case '&':
+		if l.peekChar() == '&' {
+			l.readChar()
+			tok = token.Token{Type: token.AND, RawString: "&&"}
+		} else {
+			tok = newToken(token.BIT_AND, l.ch)
+		}
+	case '|':
+		if l.peekChar() == '|' {
+			l.readChar()
+			tok = token.Token{Type: token.OR, RawString: "||"}
+		} else {
+			tok = newToken(token.BIT_OR, l.ch)
+		}
+	case '^':
+		tok = newToken(token.BIT_XOR, l.ch)
+	case '~':
+		tok = newToken(token.TILDE, l.ch)
 	case ';':
 		tok = newToken(token.SEMICOLON, l.ch)
 	case ',':
@@ -100,6 +165,27 @@ func (l *Lexer) NextToken() token.Token {
 	case ':':
 		tok = newToken(token.COLON, l.ch)
 
+	case '.':
+		// a single . is not used (yet)
+		if strings.HasPrefix(l.input[l.position:], "...") {
+			l.readChar()
+			l.readChar()
+			tok = token.Token{Type: token.ELLIPSIS, RawString: "..."}
+		} else {
+			tok = newToken(token.ILLEGAL, l.ch)
+		}
+
+	case '?':
+		if l.peekChar() == '?' {
+			l.readChar()
+			tok = token.Token{Type: token.NULLISH, RawString: "??"}
+		} else if l.peekChar() == '.' {
+			l.readChar()
+			tok = token.Token{Type: token.OPTIONAL_CHAIN, RawString: "?."}
+		} else {
+			tok = newToken(token.QUESTION, l.ch)
+		}
+
 	default:
 		if isLetter(l.ch) {
 			tok.RawString = l.readIdentifier()
@@ -107,11 +193,13 @@ func (l *Lexer) NextToken() token.Token {
 			// keywords is subset of identifier
 			// true/false is also keywords
 			tok.Type = token.LookupIdent(tok.RawString)
+			tok.Line, tok.Column = line, column
 			return tok
 		} else if isDigit(l.ch) {
 			// readNumber returns string, and in parser will convert to integer
 			tok.RawString = l.readNumber()
 			tok.Type = token.INT
+			tok.Line, tok.Column = line, column
 			return tok
 		} else {
 			tok = newToken(token.ILLEGAL, l.ch)
@@ -119,6 +207,7 @@ func (l *Lexer) NextToken() token.Token {
 	}
 
 	l.readChar()
+	tok.Line, tok.Column = line, column
 	return tok
 }
 
@@ -166,10 +255,39 @@ func isLetter(ch byte) bool {
 		ch == '_'
 }
 
+// Comments returns the // comments read so far, in source order.
+// RawString is the whole comment without the line break, starting with //.
+func (l *Lexer) Comments() []token.Token {
+	return l.comments
+}
+
+// skips the blanks and the comments in between
 func (l *Lexer) skipWhitespace() {
-	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
+	for {
+		switch {
+		case l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r':
+			l.readChar()
+
+		case l.ch == '/' && l.peekChar() == '/':
+			l.readComment()
+
+		default:
+			return
+		}
+	}
+}
+
+// a comment runs to the end of the line
+func (l *Lexer) readComment() {
+	tok := token.Token{Type: token.COMMENT, Line: l.line, Column: l.column}
+
+	position := l.position
+	for l.ch != '\n' && l.ch != 0 {
 		l.readChar()
 	}
+
+	tok.RawString = strings.TrimRight(l.input[position:l.position], "\r")
+	l.comments = append(l.comments, tok)
 }
 
 func isDigit(ch byte) bool {
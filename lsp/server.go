@@ -0,0 +1,430 @@
+// Package lsp is a language server for editors, `xmonkey lsp` speaks it over stdin/stdout.
+// It offers diagnostics from the parser, go to definition, find references,
+// hover and completion, all answered by the analysis package.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"xmonkey/analysis"
+	"xmonkey/ast"
+	"xmonkey/evaluator"
+	"xmonkey/lexer"
+	"xmonkey/object"
+	"xmonkey/parser"
+	"xmonkey/token"
+)
+
+// document is an open file
+type document struct {
+	// of the last text which parsed, so navigation still works while a line is half typed
+	info  *analysis.Info
+	lines lines
+}
+
+// Server answers one editor
+type Server struct {
+	in   *bufio.Reader
+	out  io.Writer
+	docs map[string]*document
+
+	shutdown bool
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{in: bufio.NewReader(in), out: out, docs: map[string]*document{}}
+}
+
+// Serve handles messages until exit or the end of the input
+func (s *Server) Serve() error {
+	for {
+		msg, err := readMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if errors.Is(err, errParse) {
+			// the id could not be read, the answer has none
+			if err := writeMessage(s.out, &response{JSONRPC: "2.0", Error: &responseError{Code: parseError, Message: err.Error()}}); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return fmt.Errorf("exit before shutdown")
+			}
+			return nil
+		}
+
+		if err := s.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) handle(msg *message) error {
+	result, rpcErr := s.dispatch(msg)
+
+	// a notification gets no answer, even when it failed
+	if msg.ID == nil {
+		return nil
+	}
+
+	return writeMessage(s.out, &response{JSONRPC: "2.0", ID: msg.ID, Result: result, Error: rpcErr})
+}
+
+func (s *Server) dispatch(msg *message) (interface{}, *responseError) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				// 1: the client always sends the full text
+				"textDocumentSync":   1,
+				"definitionProvider": true,
+				"referencesProvider": true,
+				"hoverProvider":      true,
+				"completionProvider": map[string]interface{}{},
+			},
+			"serverInfo": map[string]string{"name": "xmonkey"},
+		}, nil
+
+	case "initialized":
+		return nil, nil
+
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var params DidOpenParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, badParams(err)
+		}
+		return nil, s.update(params.TextDocument.URI, params.TextDocument.Text)
+
+	case "textDocument/didChange":
+		var params DidChangeParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, badParams(err)
+		}
+		if len(params.ContentChanges) == 0 {
+			return nil, nil
+		}
+		return nil, s.update(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+
+	case "textDocument/didClose":
+		var params DidCloseParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, badParams(err)
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return nil, nil
+
+	case "textDocument/definition":
+		var params TextDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, badParams(err)
+		}
+		return s.definition(params), nil
+
+	case "textDocument/references":
+		var params ReferenceParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, badParams(err)
+		}
+		return s.references(params), nil
+
+	case "textDocument/hover":
+		var params TextDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, badParams(err)
+		}
+		return s.hover(params), nil
+
+	case "textDocument/completion":
+		var params TextDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, badParams(err)
+		}
+		return s.completion(params), nil
+	}
+
+	// unknown notifications ($/cancelRequest...) are dropped by handle, the answer only goes to requests
+	return nil, &responseError{Code: methodNotFound, Message: "method not supported: " + msg.Method}
+}
+
+func badParams(err error) *responseError {
+	return &responseError{Code: invalidParams, Message: err.Error()}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// documents and diagnostics
+
+// update parses the new text of a document and publishes its diagnostics
+func (s *Server) update(uri, text string) *responseError {
+	doc, ok := s.docs[uri]
+	if !ok {
+		doc = &document{}
+		s.docs[uri] = doc
+	}
+
+	p := parser.New(lexer.New(text))
+	program := p.ParseProgram()
+	lines := splitLines(text)
+
+	diagnostics := []Diagnostic{}
+	for i, msg := range p.Errors() {
+		diagnostics = append(diagnostics, Diagnostic{
+			Range:    lines.tokenRange(p.ErrorTokens()[i]),
+			Severity: SeverityError,
+			Source:   "xmonkey",
+			Message:  msg,
+		})
+	}
+
+	if len(diagnostics) == 0 {
+		doc.info = analysis.Resolve(program, evaluator.Scoping{})
+		doc.lines = lines
+	}
+
+	err := writeMessage(s.out, &notification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params:  &PublishDiagnosticsParams{URI: uri, Diagnostics: diagnostics},
+	})
+	if err != nil {
+		return &responseError{Code: internalError, Message: err.Error()}
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// positions
+// A token is at a line and a byte column, both from 1; a protocol Position is at a line
+// and a character counted in UTF-16 code units, both from 0. They are converted here and only here.
+
+// lines is a text split at its newlines
+type lines []string
+
+func splitLines(text string) lines {
+	return strings.Split(text, "\n")
+}
+
+// position is where the byte at line, column (from 1, as in a token) is for the client
+func (l lines) position(line, column int) Position {
+	if line < 1 {
+		line = 1
+	}
+	if column < 1 {
+		column = 1
+	}
+
+	text := ""
+	if line <= len(l) {
+		text = l[line-1]
+	}
+
+	// past the end of the line every byte is one unit
+	character := 0
+	for i := 0; i < column-1; {
+		if i >= len(text) {
+			character += column - 1 - i
+			break
+		}
+		r, size := utf8.DecodeRuneInString(text[i:])
+		character += utf16Len(r)
+		i += size
+	}
+
+	return Position{Line: line - 1, Character: character}
+}
+
+// column is the line and byte column (from 1, as in a token) of a position of the client,
+// a position inside a character is the start of it
+func (l lines) column(pos Position) (int, int) {
+	text := ""
+	if pos.Line >= 0 && pos.Line < len(l) {
+		text = l[pos.Line]
+	}
+
+	column, character := 1, 0
+	for column-1 < len(text) {
+		r, size := utf8.DecodeRuneInString(text[column-1:])
+		if character+utf16Len(r) > pos.Character {
+			return pos.Line + 1, column
+		}
+		character += utf16Len(r)
+		column += size
+	}
+
+	return pos.Line + 1, column + pos.Character - character
+}
+
+// utf16Len is the number of UTF-16 code units of r, an invalid byte counts as one
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+func (l lines) tokenRange(tok token.Token) Range {
+	width := len(tok.RawString)
+	if tok.Type == token.STRING {
+		width += 2
+	}
+	if width == 0 {
+		width = 1
+	}
+
+	start := l.position(tok.Line, tok.Column)
+	end := l.position(tok.Line, tok.Column+width)
+	if tok.Line < 1 || tok.Column < 1 {
+		// no position, the first width characters
+		end = Position{Line: start.Line, Character: start.Character + width}
+	}
+	return Range{Start: start, End: end}
+}
+
+func (l lines) identRange(ident *ast.Identifier) Range {
+	return l.tokenRange(ident.Token)
+}
+
+// symbolAt is the identifier and its symbol under the cursor, both nil when there is nothing to tell
+func (s *Server) symbolAt(params TextDocumentPositionParams) (*document, *ast.Identifier, *analysis.Symbol) {
+	doc, ok := s.docs[params.TextDocument.URI]
+	if !ok || doc.info == nil {
+		return nil, nil, nil
+	}
+
+	line, column := doc.lines.column(params.Position)
+	ident := doc.info.IdentAt(line, column)
+	// the cursor may be right after the name
+	if ident == nil && column > 1 {
+		ident = doc.info.IdentAt(line, column-1)
+	}
+	if ident == nil {
+		return doc, nil, nil
+	}
+
+	if sym, ok := doc.info.Defs[ident]; ok {
+		return doc, ident, sym
+	}
+	return doc, ident, doc.info.Uses[ident]
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// requests
+
+func (s *Server) definition(params TextDocumentPositionParams) interface{} {
+	doc, _, sym := s.symbolAt(params)
+	if sym == nil || sym.Decl == nil {
+		return nil
+	}
+
+	return []Location{{URI: params.TextDocument.URI, Range: doc.lines.identRange(sym.Decl)}}
+}
+
+func (s *Server) references(params ReferenceParams) interface{} {
+	doc, _, sym := s.symbolAt(params.TextDocumentPositionParams)
+	if sym == nil || sym.Decl == nil {
+		return nil
+	}
+
+	locations := []Location{}
+	if params.Context.IncludeDeclaration {
+		locations = append(locations, Location{URI: params.TextDocument.URI, Range: doc.lines.identRange(sym.Decl)})
+	}
+	for _, ref := range sym.Refs {
+		locations = append(locations, Location{URI: params.TextDocument.URI, Range: doc.lines.identRange(ref)})
+	}
+
+	return locations
+}
+
+func (s *Server) hover(params TextDocumentPositionParams) interface{} {
+	doc, ident, sym := s.symbolAt(params)
+	if sym == nil {
+		return nil
+	}
+
+	return &Hover{
+		Contents: MarkupContent{Kind: "markdown", Value: "```\n" + describe(doc.info, sym) + "\n```"},
+		Range:    doc.lines.identRange(ident),
+	}
+}
+
+// describe is what hover shows: the kind of binding, the name and what is known of the value
+func describe(info *analysis.Info, sym *analysis.Symbol) string {
+	if sym.Kind == analysis.BUILTIN {
+		return "builtin " + evaluator.BuiltinSignature(sym.Name)
+	}
+
+	if fn, ok := sym.Value.(*ast.FunctionLiteral); ok {
+		params := []string{}
+		for _, param := range fn.FormalParams {
+			params = append(params, param.String())
+		}
+		return fmt.Sprintf("%s %s: fn(%s)", sym.Kind, sym.Name, strings.Join(params, ", "))
+	}
+
+	if sym.Value != nil {
+		typ := info.TypeOf(sym.Value)
+		if typ == "" {
+			return fmt.Sprintf("%s %s", sym.Kind, sym.Name)
+		}
+		// small literals say more than their type
+		if text, ok := literalText(sym.Value); ok && len(text) <= 40 {
+			return fmt.Sprintf("%s %s: %s = %s", sym.Kind, sym.Name, typ, text)
+		}
+		return fmt.Sprintf("%s %s: %s", sym.Kind, sym.Name, typ)
+	}
+
+	return fmt.Sprintf("%s %s", sym.Kind, sym.Name)
+}
+
+func literalText(expr ast.Expression) (string, bool) {
+	switch expr := expr.(type) {
+	case *ast.IntegerLiteral, *ast.Boolean:
+		return expr.String(), true
+	case *ast.StringLiteral:
+		return strconv.Quote(expr.Value), true
+	}
+	return "", false
+}
+
+func (s *Server) completion(params TextDocumentPositionParams) interface{} {
+	doc, ok := s.docs[params.TextDocument.URI]
+	if !ok || doc.info == nil {
+		return []CompletionItem{}
+	}
+
+	items := []CompletionItem{}
+	for _, sym := range doc.info.Visible(doc.lines.column(params.Position)) {
+		kind := CompletionVariable
+		_, isFn := sym.Value.(*ast.FunctionLiteral)
+		if isFn || sym.Kind == analysis.BUILTIN {
+			kind = CompletionFunction
+		}
+
+		detail := string(sym.Kind)
+		if typ := doc.info.TypeOf(sym.Value); typ != "" && typ != object.FUNCTION_OBJ {
+			detail += " " + string(typ)
+		}
+
+		items = append(items, CompletionItem{Label: sym.Name, Kind: kind, Detail: detail})
+	}
+
+	return items
+}
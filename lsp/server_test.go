@@ -0,0 +1,297 @@
+package lsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const uri = "file:///tmp/test.mk"
+
+func didOpen(text string) string {
+	params, _ := json.Marshal(map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri, "text": text},
+	})
+	return `{"jsonrpc":"2.0","method":"textDocument/didOpen","params":` + string(params) + `}`
+}
+
+func request(id int, method string, line, character int) string {
+	params, _ := json.Marshal(map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     map[string]int{"line": line, "character": character},
+		"context":      map[string]bool{"includeDeclaration": true},
+	})
+	idText, _ := json.Marshal(id)
+	return `{"jsonrpc":"2.0","id":` + string(idText) + `,"method":"` + method + `","params":` + string(params) + `}`
+}
+
+func frame(msg string) string {
+	return "Content-Length: " + strconv.Itoa(len(msg)) + "\r\n\r\n" + msg
+}
+
+// results runs a session, the replies are keyed by request id, the diagnostics of all publishes come second
+func results(t *testing.T, msgs ...string) (map[float64]interface{}, []interface{}) {
+	t.Helper()
+
+	var in bytes.Buffer
+	for _, msg := range msgs {
+		in.WriteString(frame(msg))
+	}
+
+	var out bytes.Buffer
+	if err := NewServer(&in, &out).Serve(); err != nil {
+		t.Fatalf("serve: %s", err)
+	}
+
+	byID := map[float64]interface{}{}
+	var diagnostics []interface{}
+	for _, body := range strings.Split(out.String(), "Content-Length: ")[1:] {
+		body = body[strings.Index(body, "\r\n\r\n")+4:]
+
+		reply := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(body), &reply); err != nil {
+			t.Fatalf("bad reply %q: %s", body, err)
+		}
+
+		if reply["method"] == "textDocument/publishDiagnostics" {
+			params := reply["params"].(map[string]interface{})
+			diagnostics = append(diagnostics, params["diagnostics"].([]interface{})...)
+			continue
+		}
+		if reply["error"] != nil {
+			byID[reply["id"].(float64)] = reply["error"]
+			continue
+		}
+		byID[reply["id"].(float64)] = reply["result"]
+	}
+
+	return byID, diagnostics
+}
+
+func compact(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+const shutdown = `{"jsonrpc":"2.0","id":99,"method":"shutdown"}`
+const exit = `{"jsonrpc":"2.0","method":"exit"}`
+
+func TestInitialize(t *testing.T) {
+	byID, _ := results(t, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`, shutdown, exit)
+
+	caps := compact(byID[1])
+	for _, want := range []string{`"definitionProvider":true`, `"hoverProvider":true`, `"referencesProvider":true`, `"textDocumentSync":1`, `"completionProvider":{}`} {
+		if !strings.Contains(caps, want) {
+			t.Errorf("expect %s in %s", want, caps)
+		}
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	_, diagnostics := results(t, didOpen("let x = 1;\nlet = 2;"), shutdown, exit)
+
+	if len(diagnostics) == 0 {
+		t.Fatalf("expect diagnostics")
+	}
+
+	got := compact(diagnostics[0])
+	want := `{"message":"expect next token to be IDENT. got = instead","range":{"end":{"character":5,"line":1},"start":{"character":4,"line":1}},"severity":1,"source":"xmonkey"}`
+	if got != want {
+		t.Errorf("expect\n%s\ngot\n%s", want, got)
+	}
+
+	_, diagnostics = results(t, didOpen("let x = 1;"), shutdown, exit)
+	if len(diagnostics) != 0 {
+		t.Errorf("expect no diagnostics, got %s", compact(diagnostics))
+	}
+}
+
+func TestNavigation(t *testing.T) {
+	src := `let total = 10;
+let add = fn(n) { total + n };
+add(total);
+len(total)`
+
+	byID, _ := results(t,
+		didOpen(src),
+		request(1, "textDocument/definition", 2, 5),
+		request(2, "textDocument/references", 0, 6),
+		request(3, "textDocument/hover", 2, 1),
+		request(4, "textDocument/hover", 3, 9),
+		request(5, "textDocument/hover", 3, 1),
+		request(6, "textDocument/definition", 3, 1),
+		request(7, "textDocument/completion", 1, 24),
+		request(8, "textDocument/hover", 1, 14),
+		shutdown, exit,
+	)
+
+	tests := []struct {
+		id       float64
+		expected string
+	}{
+		{1, `[{"range":{"end":{"character":9,"line":0},"start":{"character":4,"line":0}},"uri":"file:///tmp/test.mk"}]`},
+		{2, `[{"range":{"end":{"character":9,"line":0},"start":{"character":4,"line":0}},"uri":"file:///tmp/test.mk"},` +
+			`{"range":{"end":{"character":23,"line":1},"start":{"character":18,"line":1}},"uri":"file:///tmp/test.mk"},` +
+			`{"range":{"end":{"character":9,"line":2},"start":{"character":4,"line":2}},"uri":"file:///tmp/test.mk"},` +
+			`{"range":{"end":{"character":9,"line":3},"start":{"character":4,"line":3}},"uri":"file:///tmp/test.mk"}]`},
+		{3, `{"contents":{"kind":"markdown","value":"` + "```\\nlet add: fn(n)\\n```" + `"},"range":{"end":{"character":3,"line":2},"start":{"character":0,"line":2}}}`},
+		{4, `{"contents":{"kind":"markdown","value":"` + "```\\nlet total: INTEGER = 10\\n```" + `"},"range":{"end":{"character":9,"line":3},"start":{"character":4,"line":3}}}`},
+		{5, `{"contents":{"kind":"markdown","value":"` + "```\\nbuiltin len(x): the length of an array, string or hash\\n```" + `"},"range":{"end":{"character":3,"line":3},"start":{"character":0,"line":3}}}`},
+		{6, `null`},
+		{8, `{"contents":{"kind":"markdown","value":"` + "```\\nparam n\\n```" + `"},"range":{"end":{"character":14,"line":1},"start":{"character":13,"line":1}}}`},
+	}
+
+	for _, tt := range tests {
+		if got := compact(byID[tt.id]); got != tt.expected {
+			t.Errorf("request %v: expect\n%s\ngot\n%s", tt.id, tt.expected, got)
+		}
+	}
+
+	items := map[string]string{}
+	for _, item := range byID[7].([]interface{}) {
+		item := item.(map[string]interface{})
+		items[item["label"].(string)] = item["detail"].(string)
+	}
+	for name, detail := range map[string]string{"total": "let INTEGER", "n": "param", "len": "builtin", "add": "let"} {
+		if items[name] != detail {
+			t.Errorf("completion: expect %s with detail %q, got %q", name, detail, items[name])
+		}
+	}
+}
+
+func TestKeepInfoWhileEditing(t *testing.T) {
+	change := `{"jsonrpc":"2.0","method":"textDocument/didChange","params":{"textDocument":{"uri":"` + uri + `"},"contentChanges":[{"text":"let x = 1;\nx +"}]}}`
+
+	byID, diagnostics := results(t, didOpen("let x = 1;\nx"), change, request(1, "textDocument/definition", 1, 0), shutdown, exit)
+
+	if len(diagnostics) == 0 {
+		t.Errorf("expect the half typed line to be reported")
+	}
+
+	want := `[{"range":{"end":{"character":5,"line":0},"start":{"character":4,"line":0}},"uri":"file:///tmp/test.mk"}]`
+	if got := compact(byID[1]); got != want {
+		t.Errorf("expect the last good parse to answer, got %s", got)
+	}
+}
+
+func TestUnknownMethod(t *testing.T) {
+	byID, _ := results(t, `{"jsonrpc":"2.0","id":1,"method":"workspace/symbol","params":{}}`, `{"jsonrpc":"2.0","method":"$/cancelRequest","params":{}}`, shutdown, exit)
+
+	want := `{"code":-32601,"message":"method not supported: workspace/symbol"}`
+	if got := compact(byID[1]); got != want {
+		t.Errorf("expect %s, got %s", want, got)
+	}
+	if len(byID) != 2 {
+		t.Errorf("notifications get no answer, got %d replies", len(byID))
+	}
+}
+
+func TestBadMessages(t *testing.T) {
+	defer func(max int) { maxMessageSize = max }(maxMessageSize)
+	maxMessageSize = 100
+
+	// a body which is not json, then one over the limit, and the server still answers the next request
+	input := frame(`{"jsonrpc":`) + frame(`{"jsonrpc":"2.0","id":2,"params":"`+strings.Repeat("x", 100)+`"}`) +
+		frame(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`) + frame(shutdown) + frame(exit)
+
+	var out bytes.Buffer
+	if err := NewServer(strings.NewReader(input), &out).Serve(); err != nil {
+		t.Fatalf("serve: %s", err)
+	}
+
+	replies := strings.Split(out.String(), "Content-Length: ")[1:]
+	if len(replies) != 4 {
+		t.Fatalf("expected 4 replies, got %d: %s", len(replies), out.String())
+	}
+	for _, reply := range replies[:2] {
+		if !strings.Contains(reply, `"id":null`) || !strings.Contains(reply, `"code":-32700`) {
+			t.Errorf("expected a parse error, got %s", reply)
+		}
+	}
+	if !strings.Contains(replies[2], `"id":1`) || !strings.Contains(replies[2], `"capabilities"`) {
+		t.Errorf("expected the initialize result, got %s", replies[2])
+	}
+
+	// without a valid length the messages can not be told apart, that ends the session
+	for _, length := range []string{"-1", "x"} {
+		in := "Content-Length: " + length + "\r\n\r\n" + `{}`
+		if err := NewServer(strings.NewReader(in), &bytes.Buffer{}).Serve(); err == nil {
+			t.Errorf("Content-Length %s: expected an error", length)
+		}
+	}
+}
+
+func TestUTF16Positions(t *testing.T) {
+	// é is 2 bytes and 1 UTF-16 unit, 😀 is 4 bytes and 2 units: total starts at byte 22, character 19
+	src := "let s = \"é😀\"; let total = 1;\ntotal"
+
+	byID, _ := results(t,
+		didOpen(src),
+		request(1, "textDocument/definition", 1, 0),
+		request(2, "textDocument/hover", 0, 20),
+		shutdown, exit,
+	)
+
+	want := `[{"range":{"end":{"character":24,"line":0},"start":{"character":19,"line":0}},"uri":"file:///tmp/test.mk"}]`
+	if got := compact(byID[1]); got != want {
+		t.Errorf("definition: expect\n%s\ngot\n%s", want, got)
+	}
+
+	want = `{"contents":{"kind":"markdown","value":"` + "```\\nlet total: INTEGER = 1\\n```" + `"},"range":{"end":{"character":24,"line":0},"start":{"character":19,"line":0}}}`
+	if got := compact(byID[2]); got != want {
+		t.Errorf("hover: expect\n%s\ngot\n%s", want, got)
+	}
+
+	_, diagnostics := results(t, didOpen("\"😀\"; let = 2;"), shutdown, exit)
+	if len(diagnostics) == 0 {
+		t.Fatalf("expect diagnostics")
+	}
+	want = `{"end":{"character":11,"line":0},"start":{"character":10,"line":0}}`
+	if got := compact(diagnostics[0].(map[string]interface{})["range"]); got != want {
+		t.Errorf("diagnostic: expect %s, got %s", want, got)
+	}
+}
+
+func TestLinesColumn(t *testing.T) {
+	l := splitLines("a😀b\nxy")
+
+	tests := []struct {
+		pos          Position
+		line, column int
+	}{
+		{Position{Line: 0, Character: 0}, 1, 1},
+		{Position{Line: 0, Character: 1}, 1, 2},
+		// inside the surrogate pair of 😀 is the start of it
+		{Position{Line: 0, Character: 2}, 1, 2},
+		{Position{Line: 0, Character: 3}, 1, 6},
+		{Position{Line: 0, Character: 5}, 1, 8},
+		{Position{Line: 1, Character: 1}, 2, 2},
+		{Position{Line: 5, Character: 2}, 6, 3},
+	}
+
+	for _, tt := range tests {
+		line, column := l.column(tt.pos)
+		if line != tt.line || column != tt.column {
+			t.Errorf("%v: expect %d:%d, got %d:%d", tt.pos, tt.line, tt.column, line, column)
+		}
+		if tt.pos.Character != 2 && l.position(line, column) != tt.pos {
+			t.Errorf("%d:%d: expect %v back, got %v", line, column, tt.pos, l.position(line, column))
+		}
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("closed")
+}
+
+func TestPublishFailure(t *testing.T) {
+	err := NewServer(strings.NewReader(""), failingWriter{}).update(uri, "let x = 1;")
+	if err == nil || err.Code != internalError {
+		t.Errorf("expect an internal error, got %v", err)
+	}
+}
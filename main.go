@@ -1,14 +1,31 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
 	"os/user"
 
+	"xmonkey/ast"
+	"xmonkey/check"
+	"xmonkey/evaluator"
+	"xmonkey/format"
+	"xmonkey/lexer"
+	"xmonkey/lsp"
+	"xmonkey/object"
+	"xmonkey/optimize"
+	"xmonkey/parser"
 	"xmonkey/repl"
+	"xmonkey/types"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
+
 	u, err := user.Current()
 	if err != nil {
 		panic(err)
@@ -19,3 +36,240 @@ func main() {
 	repl.Start(os.Stdin, os.Stdout)
 
 }
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage:\n")
+	fmt.Fprintf(os.Stderr, "  xmonkey                      start the REPL\n")
+	fmt.Fprintf(os.Stderr, "  xmonkey run [flags] file     run a script\n")
+	fmt.Fprintf(os.Stderr, "  xmonkey fmt [-w] file...     format scripts\n")
+	fmt.Fprintf(os.Stderr, "  xmonkey check file...        report undefined names, unused lets, wrong arity... without running\n")
+	fmt.Fprintf(os.Stderr, "  xmonkey ast [--json] [--optimize] file\n")
+	fmt.Fprintf(os.Stderr, "                               print the parsed program, as json with positions, or optimized\n")
+	fmt.Fprintf(os.Stderr, "  xmonkey lsp                  language server on stdin/stdout, for editors\n")
+}
+
+// runCommand runs a sub command, the result is the exit code
+func runCommand(cmd string, args []string) int {
+	switch cmd {
+	case "run":
+		return runScript(args)
+	case "fmt":
+		return formatFiles(args)
+	case "check":
+		return checkFiles(args)
+	case "ast":
+		return printAST(args)
+	case "lsp":
+		return serveLSP()
+	default:
+		usage()
+		return 2
+	}
+}
+
+// xmonkey run [-files dir] [-readonly] [-leak-block-scope] [-no-types] file
+func runScript(args []string) int {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	files := flags.String("files", "", "directory the script may access with read_file/write_file/list_dir, none if empty")
+	readOnly := flags.Bool("readonly", false, "only allow reading in the -files directory")
+	leakBlockScope := flags.Bool("leak-block-scope", false, "let inside if/else and try blocks stays visible after the block (old behavior)")
+	noTypes := flags.Bool("no-types", false, "run without checking the types first")
+	noOptimize := flags.Bool("no-optimize", false, "run the code as it is written, without folding constants and inlining")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		usage()
+		return 2
+	}
+
+	src, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	ctx := evaluator.NewContext()
+	ctx.LeakBlockScope = *leakBlockScope
+	if *files != "" {
+		ctx.Sandbox = &evaluator.Sandbox{Root: *files, ReadOnly: *readOnly}
+	}
+
+	p := parser.New(lexer.New(string(src)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		for _, msg := range p.Errors() {
+			fmt.Fprintln(os.Stderr, msg)
+		}
+		return 1
+	}
+
+	// the macros are expanded first, the types are checked on the code which runs
+	macros := evaluator.WithContext(object.NewEnvironment(), ctx)
+	evaluator.DefineMacros(program, macros)
+	if _, errObj := evaluator.ExpandMacros(program, macros); errObj != nil {
+		fmt.Fprintln(os.Stderr, errObj.Traceback())
+		return 1
+	}
+
+	if !*noTypes {
+		typeErrors := types.Check(program, ctx.Scoping)
+		for _, err := range typeErrors {
+			fmt.Fprintf(os.Stderr, "%s:%s\n", flags.Arg(0), err)
+		}
+		if len(typeErrors) != 0 {
+			return 1
+		}
+	}
+
+	if !*noOptimize {
+		optimize.Program(program, ctx.Scoping)
+	}
+	evaluator.Resolve(program, ctx.Scoping)
+
+	result := evaluator.Eval(program, evaluator.WithContext(object.NewEnvironment(), ctx))
+	if errObj, ok := result.(*object.Error); ok {
+		fmt.Fprintln(os.Stderr, errObj.Traceback())
+		return 1
+	}
+
+	return 0
+}
+
+// xmonkey fmt [-w] file...
+func formatFiles(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := flags.Bool("w", false, "write the result back to the file instead of printing it")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		usage()
+		return 2
+	}
+
+	code := 0
+	for _, name := range flags.Args() {
+		src, err := os.ReadFile(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 1
+			continue
+		}
+
+		formatted, err := format.Source(string(src))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s:\n%s\n", name, err)
+			code = 1
+			continue
+		}
+
+		if !*write {
+			fmt.Print(formatted)
+			continue
+		}
+
+		if formatted != string(src) {
+			if err := os.WriteFile(name, []byte(formatted), 0644); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				code = 1
+			}
+		}
+	}
+
+	return code
+}
+
+// xmonkey check file..., exits 1 if any file has a problem
+func checkFiles(args []string) int {
+	if len(args) == 0 {
+		usage()
+		return 2
+	}
+
+	code := 0
+	for _, name := range args {
+		src, err := os.ReadFile(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 1
+			continue
+		}
+
+		p := parser.New(lexer.New(string(src)))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			for i, msg := range p.Errors() {
+				tok := p.ErrorTokens()[i]
+				fmt.Printf("%s:%d:%d: %s\n", name, tok.Line, tok.Column, msg)
+			}
+			code = 1
+			continue
+		}
+
+		for _, problem := range check.Program(program) {
+			fmt.Printf("%s:%s\n", name, problem)
+			code = 1
+		}
+	}
+
+	return code
+}
+
+// xmonkey ast [--json] file
+func printAST(args []string) int {
+	flags := flag.NewFlagSet("ast", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the ast as json, every node with its kind and token positions")
+	optimized := flags.Bool("optimize", false, "print the program as run will run it, after folding constants and inlining")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		usage()
+		return 2
+	}
+
+	src, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	p := parser.New(lexer.New(string(src)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		for _, msg := range p.Errors() {
+			fmt.Fprintln(os.Stderr, msg)
+		}
+		return 1
+	}
+
+	if *optimized {
+		optimize.Program(program, evaluator.Scoping{})
+	}
+
+	if !*asJSON {
+		fmt.Println(program.String())
+		return 0
+	}
+
+	data, err := ast.EncodeJSON(program)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var out bytes.Buffer
+	json.Indent(&out, data, "", "  ")
+	out.WriteString("\n")
+	out.WriteTo(os.Stdout)
+
+	return 0
+}
+
+// xmonkey lsp, stdout belongs to the protocol, so complaints go to stderr
+func serveLSP() int {
+	if err := lsp.NewServer(os.Stdin, os.Stdout).Serve(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
@@ -1,32 +1,91 @@
 package object
 
+// Environment binds names to values, by name in store, or in slots for the names evaluator.Resolve
+// gave a number: names[i] is the name of slots[i], a nil slot is not bound yet.
+// store is only made on the first Set, most envs of a resolved program never need it.
 type Environment struct {
 	outer *Environment
 	store map[string]Object
+
+	names []string
+	slots []Object
+
+	// what the evaluation keeps besides the names (an evaluator.Context), shared with the envs made from this one
+	context interface{}
 }
 
 func NewEnvironment() *Environment {
-	s := make(map[string]Object)
-	return &Environment{store: s, outer: nil}
+	return &Environment{}
 }
 
 func (r *Environment) Get(name string) (Object, bool) {
-	obj, ok := r.store[name]
-	if !ok && r.outer != nil {
-		obj, ok = r.outer.Get(name)
+	for env := r; env != nil; env = env.outer {
+		if obj, ok := env.store[name]; ok {
+			return obj, true
+		}
+		for i, n := range env.names {
+			if n == name && env.slots[i] != nil {
+				return env.slots[i], true
+			}
+		}
 	}
 
-	return obj, ok
+	return nil, false
 }
 
 func (r *Environment) Set(name string, val Object) Object {
+	if r.store == nil {
+		r.store = make(map[string]Object)
+	}
 	r.store[name] = val
 	return val
 }
 
 func NewEnclosedEnv(outer *Environment) *Environment {
-	env := NewEnvironment()
-	env.outer = outer
+	return &Environment{outer: outer, context: outer.context}
+}
 
+// NewSlottedEnv is NewEnclosedEnv with an empty slot for each of names
+func NewSlottedEnv(outer *Environment, names []string) *Environment {
+	env := &Environment{outer: outer, names: names, context: outer.context}
+	if len(names) != 0 {
+		env.slots = make([]Object, len(names))
+	}
 	return env
 }
+
+// Outer is the env depth levels out, r itself for 0, nil past the outermost one
+func (r *Environment) Outer(depth int) *Environment {
+	env := r
+	for ; depth > 0 && env != nil; depth-- {
+		env = env.outer
+	}
+	return env
+}
+
+// Slot is the value in slot i, nil if it is not bound yet (or there is no such slot)
+func (r *Environment) Slot(i int) Object {
+	if i >= len(r.slots) {
+		return nil
+	}
+	return r.slots[i]
+}
+
+func (r *Environment) NumSlots() int {
+	return len(r.slots)
+}
+
+func (r *Environment) SetSlot(i int, val Object) Object {
+	r.slots[i] = val
+	return val
+}
+
+// Context is what SetContext put on r or on an env r was made from, nil if none did
+func (r *Environment) Context() interface{} {
+	return r.context
+}
+
+// SetContext gives ctx to r and to the envs made from r afterwards
+func (r *Environment) SetContext(ctx interface{}) {
+	r.context = ctx
+}
@@ -0,0 +1,80 @@
+package object
+
+// Equal reports whether a and b hold the same value.
+// Integer, Boolean, String and NULL compare by value, Array compares element by element,
+// Hash compares the pairs regardless of their order,
+// everything else (functions, builtins, ...) only equals itself.
+func Equal(a, b Object) bool {
+	return equal(a, b, map[[2]Object]bool{})
+}
+
+// comparing is the pairs of arrays/hashes being compared further up the recursion:
+// if a container holds itself, meeting the same pair again is taken as equal instead of looping forever.
+func equal(a, b Object, comparing map[[2]Object]bool) bool {
+	if a == b {
+		return true
+	}
+
+	if a == nil || b == nil || a.Type() != b.Type() {
+		return false
+	}
+
+	switch a := a.(type) {
+	case *Integer:
+		return a.Value == b.(*Integer).Value
+
+	case *Boolean:
+		return a.Value == b.(*Boolean).Value
+
+	case *String:
+		return a.Value == b.(*String).Value
+
+	case *NULL:
+		return true
+
+	case *Array:
+		other := b.(*Array)
+		if a.Len() != other.Len() {
+			return false
+		}
+
+		pair := [2]Object{a, other}
+		if comparing[pair] {
+			return true
+		}
+		comparing[pair] = true
+		defer delete(comparing, pair)
+
+		for i := 0; i < a.Len(); i++ {
+			if !equal(a.At(i), other.At(i), comparing) {
+				return false
+			}
+		}
+
+		return true
+
+	case *Hash:
+		other := b.(*Hash)
+		if a.Len() != other.Len() {
+			return false
+		}
+
+		pair := [2]Object{a, other}
+		if comparing[pair] {
+			return true
+		}
+		comparing[pair] = true
+		defer delete(comparing, pair)
+
+		for _, p := range a.OrderedPairs() {
+			value, ok := other.Get(p.Key.(Hashable))
+			if !ok || !equal(p.Value, value, comparing) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
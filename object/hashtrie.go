@@ -0,0 +1,188 @@
+package object
+
+import (
+	"math/bits"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// hashTrie is the persistent storage of Hash, a hash array mapped trie:
+// each level takes 5 more bits of HashKey.Value to pick one of 32 slots, only the slots in use are stored.
+// set and delete return a new trie sharing everything but the path to the changed slot.
+
+const (
+	trieBits = 5
+	trieMask = 1<<trieBits - 1
+)
+
+// hashEntry is a pair and its position in the insertion order
+type hashEntry struct {
+	pair  HashPair
+	index int
+}
+
+type hashTrie struct {
+	bitmap uint32
+	slots  []trieSlot
+}
+
+// trieSlot is a trie one level down, or the entries of one hash value:
+// more than one when two keys collide (or have different types, 1 and true are both 1), told apart with Equal
+type trieSlot struct {
+	trie    *hashTrie
+	hashed  uint64
+	entries []hashEntry
+}
+
+func (t *hashTrie) find(hashed uint64, key Hashable) (hashEntry, bool) {
+	for shift := uint(0); t != nil; shift += trieBits {
+		bit := uint32(1) << ((hashed >> shift) & trieMask)
+		if t.bitmap&bit == 0 {
+			break
+		}
+
+		slot := t.slots[t.position(bit)]
+		if slot.trie != nil {
+			t = slot.trie
+			continue
+		}
+
+		if slot.hashed == hashed {
+			for _, entry := range slot.entries {
+				if Equal(entry.pair.Key, key) {
+					return entry, true
+				}
+			}
+		}
+		break
+	}
+
+	return hashEntry{}, false
+}
+
+func (t *hashTrie) position(bit uint32) int {
+	return bits.OnesCount32(t.bitmap & (bit - 1))
+}
+
+// set returns the trie with entry, replacing the one of an equal key: the index of that one is kept
+func (t *hashTrie) set(shift uint, hashed uint64, entry hashEntry) *hashTrie {
+	if t == nil {
+		t = &hashTrie{}
+	}
+
+	bit := uint32(1) << ((hashed >> shift) & trieMask)
+	pos := t.position(bit)
+
+	if t.bitmap&bit == 0 {
+		slots := make([]trieSlot, 0, len(t.slots)+1)
+		slots = append(slots, t.slots[:pos]...)
+		slots = append(slots, trieSlot{hashed: hashed, entries: []hashEntry{entry}})
+		slots = append(slots, t.slots[pos:]...)
+		return &hashTrie{bitmap: t.bitmap | bit, slots: slots}
+	}
+
+	slot := t.slots[pos]
+	switch {
+	case slot.trie != nil:
+		slot = trieSlot{trie: slot.trie.set(shift+trieBits, hashed, entry)}
+
+	case slot.hashed == hashed:
+		entries := make([]hashEntry, len(slot.entries), len(slot.entries)+1)
+		copy(entries, slot.entries)
+
+		replaced := false
+		for i, e := range entries {
+			if Equal(e.pair.Key, entry.pair.Key) {
+				entry.index = e.index
+				entries[i] = entry
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			entries = append(entries, entry)
+		}
+		slot = trieSlot{hashed: hashed, entries: entries}
+
+	default:
+		// another hash value in the same slot, both go one level down where their bits differ
+		var below *hashTrie
+		for _, e := range slot.entries {
+			below = below.set(shift+trieBits, slot.hashed, e)
+		}
+		slot = trieSlot{trie: below.set(shift+trieBits, hashed, entry)}
+	}
+
+	return t.withSlot(pos, slot)
+}
+
+// delete returns the trie without key, nil when it is left empty
+func (t *hashTrie) delete(shift uint, hashed uint64, key Hashable) (*hashTrie, bool) {
+	if t == nil {
+		return nil, false
+	}
+
+	bit := uint32(1) << ((hashed >> shift) & trieMask)
+	if t.bitmap&bit == 0 {
+		return t, false
+	}
+	pos := t.position(bit)
+
+	slot := t.slots[pos]
+	if slot.trie != nil {
+		below, deleted := slot.trie.delete(shift+trieBits, hashed, key)
+		if !deleted {
+			return t, false
+		}
+		if below != nil {
+			return t.withSlot(pos, trieSlot{trie: below}), true
+		}
+		return t.withoutSlot(pos, bit), true
+	}
+
+	if slot.hashed != hashed {
+		return t, false
+	}
+	for i, e := range slot.entries {
+		if !Equal(e.pair.Key, key) {
+			continue
+		}
+
+		if len(slot.entries) == 1 {
+			return t.withoutSlot(pos, bit), true
+		}
+		entries := append(slot.entries[:i:i], slot.entries[i+1:]...)
+		return t.withSlot(pos, trieSlot{hashed: hashed, entries: entries}), true
+	}
+
+	return t, false
+}
+
+func (t *hashTrie) withSlot(pos int, slot trieSlot) *hashTrie {
+	slots := make([]trieSlot, len(t.slots))
+	copy(slots, t.slots)
+	slots[pos] = slot
+	return &hashTrie{bitmap: t.bitmap, slots: slots}
+}
+
+func (t *hashTrie) withoutSlot(pos int, bit uint32) *hashTrie {
+	if len(t.slots) == 1 {
+		return nil
+	}
+	slots := append(t.slots[:pos:pos], t.slots[pos+1:]...)
+	return &hashTrie{bitmap: t.bitmap &^ bit, slots: slots}
+}
+
+// appendEntries appends all entries of the trie to out, in no particular order
+func (t *hashTrie) appendEntries(out []hashEntry) []hashEntry {
+	if t == nil {
+		return out
+	}
+	for _, slot := range t.slots {
+		if slot.trie != nil {
+			out = slot.trie.appendEntries(out)
+		} else {
+			out = append(out, slot.entries...)
+		}
+	}
+	return out
+}
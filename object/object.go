@@ -2,8 +2,10 @@ package object
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"hash/fnv"
+	"sort"
 	"strings"
 
 	"xmonkey/ast"
@@ -26,6 +28,9 @@ const (
 	ARRAY_OBJ = "ARRAY"
 
 	HASH_OBJ = "HASH"
+
+	QUOTE_OBJ = "QUOTE"
+	MACRO_OBJ = "MACRO"
 )
 
 // Object 是 eval 的返回值，是一个 interface，具体的返回值都是 struct pointer
@@ -68,19 +73,78 @@ func (r *ReturnValue) Inspect() string {
 	return r.Value.Inspect()
 }
 
-// Error
+// Error stops the evaluation until a try catches it.
+// Kind tells what went wrong, so a script can catch only some of them: e["kind"] == "NameError"
 type Error struct {
 	Message string
+	Kind    string
+
+	// Value is what throw threw, nil for the errors of the interpreter
+	Value Object
+
+	// Trace is the calls being evaluated when the error was raised, the innermost first
+	Trace []Frame
+}
+
+// Frame is one call on the stack: the function called, and where it was called
+type Frame struct {
+	Function string
+	Line     int
+	Column   int
+}
+
+func (f Frame) String() string {
+	return fmt.Sprintf("line %d, column %d, in %s", f.Line, f.Column, f.Function)
+}
+
+// Traceback prints the error the way python does, the outermost call first:
+//
+//	Traceback (most recent call last):
+//	  line 9, column 6, in outer
+//	  line 5, column 10, in inner
+//	NameError: identifier not found: x
+func (r *Error) Traceback() string {
+	var out bytes.Buffer
+
+	if len(r.Trace) > 0 {
+		out.WriteString("Traceback (most recent call last):\n")
+		for i := len(r.Trace) - 1; i >= 0; i-- {
+			out.WriteString("  " + r.Trace[i].String() + "\n")
+		}
+	}
+
+	kind := r.Kind
+	if kind == "" {
+		kind = RUNTIME_ERROR
+	}
+	out.WriteString(kind + ": " + r.Message)
+
+	return out.String()
 }
 
+// kinds of the errors raised by the interpreter and builtins,
+// throw without a kind uses THROWN_ERROR, error(kind, message) can make up others
+const (
+	RUNTIME_ERROR       = "RuntimeError"
+	NAME_ERROR          = "NameError"
+	TYPE_ERROR          = "TypeError"
+	ARGUMENT_ERROR      = "ArgumentError"
+	ZERO_DIVISION_ERROR = "ZeroDivisionError"
+	MATCH_ERROR         = "MatchError"
+	IO_ERROR            = "IOError"
+	THROWN_ERROR        = "Error"
+)
+
 func (r *Error) Type() ObjectType { return ERROR_OBJ }
 func (r *Error) Inspect() string  { return "ERROR: " + r.Message }
 
 // Function is function definition, Body will evaled only when call, not definition
 // Parameters are formal params, the name will be used for set up the call env.
 // Env will be passed to call env as the outer.
+// Name is empty for fn(a) { }
 type Function struct {
-	FormalParams   []*ast.Identifier
+	Name           string
+	FormalParams   []ast.Pattern
 	Body           *ast.BlockStatement
 	EnvWhenDefined *Environment
 }
@@ -95,6 +159,9 @@ func (r *Function) Inspect() string {
 	}
 
 	out.WriteString("fn")
+	if r.Name != "" {
+		out.WriteString(" " + r.Name)
+	}
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ","))
 	out.WriteString(") {\n")
@@ -104,6 +171,39 @@ func (r *Function) Inspect() string {
 	return out.String()
 }
 
+// Quote is what quote(expr) returns: the ast of expr, not its value
+type Quote struct {
+	Node ast.Node
+}
+
+func (r *Quote) Type() ObjectType { return QUOTE_OBJ }
+func (r *Quote) Inspect() string  { return "QUOTE(" + r.Node.String() + ")" }
+
+// Macro is a macro(a, b) { } bound by let, it only lives in the env used to expand the macros
+type Macro struct {
+	Parameters []*ast.Identifier
+	Body       *ast.BlockStatement
+	Env        *Environment
+}
+
+func (r *Macro) Type() ObjectType { return MACRO_OBJ }
+func (r *Macro) Inspect() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range r.Parameters {
+		params = append(params, p.String())
+	}
+
+	out.WriteString("macro(")
+	out.WriteString(strings.Join(params, ","))
+	out.WriteString(") {\n")
+	out.WriteString(r.Body.String())
+	out.WriteString("\n}")
+
+	return out.String()
+}
+
 type String struct {
 	Value string
 }
@@ -111,8 +211,50 @@ type String struct {
 func (r *String) Type() ObjectType { return STRING_OBJ }
 func (r *String) Inspect() string  { return r.Value }
 
+// Array is never changed once made: Push, Rest and Slice return a new array,
+// which shares the elements with r in a persistent vector, so they do not copy r.
+// The zero Array is empty.
 type Array struct {
-	Elements []Object
+	elements vector
+}
+
+// NewArray makes an array of a copy of elements
+func NewArray(elements []Object) *Array {
+	return &Array{elements: newVector(elements)}
+}
+
+func (r *Array) Len() int {
+	return r.elements.len()
+}
+
+// At is element i, 0 <= i < Len()
+func (r *Array) At(i int) Object {
+	return r.elements.at(i)
+}
+
+// Elements returns the elements in a new slice, the caller may change it
+func (r *Array) Elements() []Object {
+	return r.elements.appendTo(make([]Object, 0, r.Len()), 0, r.Len())
+}
+
+// Push returns r with el added at the end
+func (r *Array) Push(el Object) *Array {
+	return &Array{elements: r.elements.push(el)}
+}
+
+// Rest returns r without its first element, r must not be empty
+func (r *Array) Rest() *Array {
+	return &Array{elements: r.elements.drop(1)}
+}
+
+// Slice returns the elements from start to end (exclusive), 0 <= start <= end <= Len().
+// Up to the end of r it is shared like Rest, otherwise the elements are copied.
+func (r *Array) Slice(start, end int) *Array {
+	if end == r.Len() {
+		return &Array{elements: r.elements.drop(start)}
+	}
+
+	return NewArray(r.elements.appendTo(nil, start, end))
 }
 
 func (r *Array) Type() ObjectType { return ARRAY_OBJ }
@@ -120,7 +262,7 @@ func (r *Array) Inspect() string {
 	var out bytes.Buffer
 
 	elements := []string{}
-	for _, e := range r.Elements {
+	for _, e := range r.Elements() {
 		elements = append(elements, e.Inspect())
 	}
 
@@ -133,7 +275,8 @@ func (r *Array) Inspect() string {
 
 ////////////////////////////////////////////////////////////////////////////////
 // BuiltinFunc 内置函数
-type BuiltinFunc func(args ...Object) Object
+// env is the env of the call, a builtin does not look up names in it, only the Context of the evaluation
+type BuiltinFunc func(env *Environment, args ...Object) Object
 
 type Builtin struct {
 	Fn BuiltinFunc
@@ -155,8 +298,22 @@ type HashPair struct {
 	Value Object
 }
 
+// Hash keeps its pairs in insertion order: Inspect, keys() and values() always
+// list them in the order they were first set.
+// Pairs are kept in a persistent trie by HashKey, but a 64 bit hash can collide, so the keys
+// of the same hash are compared with Equal.
+// Set and Delete change r, but not the copies made by Copy: they share the trie, which is never changed.
+// A hash is only changed while it is made, so the order is worked out once, by the first OrderedPairs.
 type Hash struct {
-	Pairs map[HashKey]HashPair
+	pairs *hashTrie
+	size  int
+	next  int // the position in the order of the next new key
+
+	ordered []HashPair // what OrderedPairs returned, until the next Set or Delete
+}
+
+func NewHash() *Hash {
+	return &Hash{}
 }
 
 func (r *Hash) Type() ObjectType {
@@ -168,7 +325,7 @@ func (r *Hash) Inspect() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for _, pair := range r.Pairs {
+	for _, pair := range r.OrderedPairs() {
 		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
 	}
 
@@ -179,10 +336,96 @@ func (r *Hash) Inspect() string {
 	return out.String()
 }
 
+// Set adds or replaces the value of key, a replaced key keeps its position.
+func (r *Hash) Set(key Hashable, value Object) {
+	hashed := key.GetHash().Value
+	if _, ok := r.pairs.find(hashed, key); !ok {
+		r.size++
+	}
+
+	r.pairs = r.pairs.set(0, hashed, hashEntry{pair: HashPair{Key: key, Value: value}, index: r.next})
+	r.next++
+	r.ordered = nil
+}
+
+func (r *Hash) Get(key Hashable) (Object, bool) {
+	entry, ok := r.pairs.find(key.GetHash().Value, key)
+	if !ok {
+		return nil, false
+	}
+
+	return entry.pair.Value, true
+}
+
+// Delete removes key, it reports whether key was there.
+func (r *Hash) Delete(key Hashable) bool {
+	pairs, deleted := r.pairs.delete(0, key.GetHash().Value, key)
+	if deleted {
+		r.pairs = pairs
+		r.size--
+		r.ordered = nil
+	}
+
+	return deleted
+}
+
+func (r *Hash) Len() int {
+	return r.size
+}
+
+// OrderedPairs lists the pairs in insertion order.
+// The list is kept for the next calls and shared with the copies, the caller must not change it.
+func (r *Hash) OrderedPairs() []HashPair {
+	if r.ordered != nil {
+		return r.ordered
+	}
+
+	entries := r.pairs.appendEntries(make([]hashEntry, 0, r.size))
+	sort.Slice(entries, func(i, j int) bool { return entries[i].index < entries[j].index })
+
+	pairs := make([]HashPair, len(entries))
+	for i, entry := range entries {
+		pairs[i] = entry.pair
+	}
+
+	r.ordered = pairs
+	return pairs
+}
+
+// Copy returns a new hash with the same pairs in the same order,
+// builtins use it so that a hash value is never changed in place.
+// It shares the pairs with r, changing one of them later only copies what changes.
+func (r *Hash) Copy() *Hash {
+	copied := *r
+	return &copied
+}
+
+// Hashable objects can be used as hash key.
+// An Array is Hashable by type, but only usable as key when all its elements are, check with AsHashable.
 type Hashable interface {
+	Object
 	GetHash() HashKey
 }
 
+// AsHashable returns obj as a hash key, ok is false if obj can not be a key:
+// it is not Hashable, or it is an array holding something that is not.
+func AsHashable(obj Object) (Hashable, bool) {
+	key, ok := obj.(Hashable)
+	if !ok {
+		return nil, false
+	}
+
+	if arr, isArray := obj.(*Array); isArray {
+		for _, el := range arr.Elements() {
+			if _, ok := AsHashable(el); !ok {
+				return nil, false
+			}
+		}
+	}
+
+	return key, true
+}
+
 func (r *Boolean) GetHash() HashKey {
 	var value uint64
 	value = 0
@@ -203,3 +446,23 @@ func (r *String) GetHash() HashKey {
 	h.Write([]byte(r.Value))
 	return HashKey{Type: r.Type(), Value: h.Sum64()}
 }
+
+// GetHash of an array mixes the hashes of its elements, so equal arrays get the same key.
+func (r *Array) GetHash() HashKey {
+	h := fnv.New64a()
+	buf := make([]byte, 8)
+
+	for _, el := range r.Elements() {
+		key, ok := el.(Hashable)
+		if !ok {
+			continue
+		}
+
+		elHash := key.GetHash()
+		h.Write([]byte(elHash.Type))
+		binary.LittleEndian.PutUint64(buf, elHash.Value)
+		h.Write(buf)
+	}
+
+	return HashKey{Type: r.Type(), Value: h.Sum64()}
+}
@@ -21,3 +21,229 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content have the same keys")
 	}
 }
+
+func TestHashOrder(t *testing.T) {
+	hash := NewHash()
+	hash.Set(&String{Value: "c"}, &Integer{Value: 1})
+	hash.Set(&String{Value: "a"}, &Integer{Value: 2})
+	hash.Set(&String{Value: "b"}, &Integer{Value: 3})
+	hash.Set(&String{Value: "c"}, &Integer{Value: 4})
+
+	if hash.Inspect() != "{c: 4, a: 2, b: 3}" {
+		t.Errorf("wrong order. got=%q", hash.Inspect())
+	}
+
+	copied := hash.Copy()
+	if !hash.Delete(&String{Value: "a"}) {
+		t.Errorf("delete of an existing key reported false")
+	}
+
+	if hash.Delete(&String{Value: "a"}) {
+		t.Errorf("delete of a missing key reported true")
+	}
+
+	if hash.Inspect() != "{c: 4, b: 3}" || hash.Len() != 2 {
+		t.Errorf("wrong pairs after delete. got=%q", hash.Inspect())
+	}
+
+	if copied.Inspect() != "{c: 4, a: 2, b: 3}" {
+		t.Errorf("copy changed by delete. got=%q", copied.Inspect())
+	}
+
+	// the order is listed once, and again after a change
+	pairs := hash.OrderedPairs()
+	if again := hash.OrderedPairs(); &again[0] != &pairs[0] {
+		t.Errorf("pairs listed again without a change")
+	}
+	copied = hash.Copy()
+	hash.Set(&String{Value: "a"}, &Integer{Value: 5})
+	if hash.Inspect() != "{c: 4, b: 3, a: 5}" {
+		t.Errorf("wrong order after set. got=%q", hash.Inspect())
+	}
+	if copied.Inspect() != "{c: 4, b: 3}" {
+		t.Errorf("copy changed by set. got=%q", copied.Inspect())
+	}
+}
+
+// collider always hashes to the same key, like two strings whose FNV hashes collide
+type collider struct {
+	name string
+}
+
+func (r *collider) Type() ObjectType { return "COLLIDER" }
+func (r *collider) Inspect() string  { return r.name }
+func (r *collider) GetHash() HashKey { return HashKey{Type: r.Type(), Value: 42} }
+
+func TestHashKeyCollision(t *testing.T) {
+	a := &collider{name: "a"}
+	b := &collider{name: "b"}
+
+	hash := NewHash()
+	hash.Set(a, &Integer{Value: 1})
+	hash.Set(b, &Integer{Value: 2})
+
+	if hash.Len() != 2 {
+		t.Fatalf("colliding keys were merged. got=%q", hash.Inspect())
+	}
+
+	for key, expected := range map[Hashable]int64{a: 1, b: 2} {
+		value, ok := hash.Get(key)
+		if !ok || value.(*Integer).Value != expected {
+			t.Errorf("wrong value for %s. got=%v", key.Inspect(), value)
+		}
+	}
+
+	hash.Delete(a)
+	if _, ok := hash.Get(b); !ok || hash.Inspect() != "{b: 2}" {
+		t.Errorf("delete removed the wrong key. got=%q", hash.Inspect())
+	}
+}
+
+func TestArrayHashKey(t *testing.T) {
+	one := NewArray([]Object{&Integer{Value: 1}, &String{Value: "a"}})
+	two := NewArray([]Object{&Integer{Value: 1}, &String{Value: "a"}})
+	diff := NewArray([]Object{&String{Value: "a"}, &Integer{Value: 1}})
+
+	if one.GetHash() != two.GetHash() {
+		t.Errorf("arrays with same content have different keys")
+	}
+
+	if one.GetHash() == diff.GetHash() {
+		t.Errorf("arrays with different content have the same keys")
+	}
+
+	if _, ok := AsHashable(NewArray([]Object{&Integer{Value: 1}, &NULL{}})); ok {
+		t.Errorf("array holding NULL is usable as hash key")
+	}
+}
+
+func TestEqual(t *testing.T) {
+	inner := NewHash()
+	inner.Set(&String{Value: "x"}, NewArray([]Object{&Integer{Value: 1}}))
+
+	same := NewHash()
+	same.Set(&String{Value: "x"}, NewArray([]Object{&Integer{Value: 1}}))
+
+	if !Equal(inner, same) {
+		t.Errorf("hashes with the same pairs are not equal")
+	}
+
+	// a hash holding itself must not recurse forever
+	selfA := NewHash()
+	selfA.Set(&String{Value: "self"}, selfA)
+	selfB := NewHash()
+	selfB.Set(&String{Value: "self"}, selfB)
+
+	if !Equal(selfA, selfB) {
+		t.Errorf("self referencing hashes are not equal")
+	}
+
+	loop := &Array{}
+	loop.elements = newVector([]Object{&Integer{Value: 1}, loop})
+	other := &Array{}
+	other.elements = newVector([]Object{&Integer{Value: 2}, other})
+
+	if Equal(loop, other) {
+		t.Errorf("self referencing arrays with different elements are equal")
+	}
+}
+
+func TestArrayVector(t *testing.T) {
+	// past 32, 32*32 and 32*32*32 elements the tree gets another level
+	const n = 40000
+
+	model := []Object{}
+	arr := &Array{}
+	var half *Array
+	for i := 0; i < n; i++ {
+		model = append(model, &Integer{Value: int64(i)})
+		arr = arr.Push(model[i])
+		if i == n/2 {
+			half = arr
+		}
+	}
+
+	check := func(name string, arr *Array, want []Object) {
+		if arr.Len() != len(want) {
+			t.Fatalf("%s: wrong len. got=%d, want=%d", name, arr.Len(), len(want))
+		}
+		elements := arr.Elements()
+		for i := range want {
+			if arr.At(i) != want[i] || elements[i] != want[i] {
+				t.Fatalf("%s: wrong element %d. got=%s", name, i, arr.At(i).Inspect())
+			}
+		}
+	}
+
+	check("pushed", arr, model)
+	check("new", NewArray(model), model)
+	check("half", half, model[:n/2+1])
+
+	// pushing on an older array does not change the newer ones
+	x := &String{Value: "x"}
+	check("branched", half.Push(x), append(append([]Object{}, model[:n/2+1]...), x))
+	check("pushed after branch", arr, model)
+
+	rest := arr
+	for i := 0; i < 100; i++ {
+		rest = rest.Rest()
+	}
+	check("rest", rest, model[100:])
+	check("rest pushed", rest.Push(model[0]), append(append([]Object{}, model[100:]...), model[0]))
+	check("slice to the end", arr.Slice(33, n), model[33:])
+	check("slice", rest.Slice(1000, 2050), model[1100:2150])
+	check("empty", arr.Slice(5, 5), nil)
+
+	// what is left after dropping most of the elements does not keep the whole tree
+	tail := arr.Slice(n-100, n)
+	check("short slice to the end", tail, model[n-100:])
+	if tail.elements.count != 100 {
+		t.Errorf("short slice to the end shares the tree of %d elements", tail.elements.count)
+	}
+	rest = arr
+	for i := 0; i < n-10; i++ {
+		rest = rest.Rest()
+		if rest.elements.offset > rest.Len() && rest.elements.count > vectorWidth {
+			t.Fatalf("rest %d keeps %d elements for %d", i+1, rest.elements.count, rest.Len())
+		}
+	}
+	check("rest of most", rest, model[n-10:])
+}
+
+func TestHashCopy(t *testing.T) {
+	const n = 5000
+
+	hash := NewHash()
+	for i := 0; i < n; i++ {
+		hash.Set(&Integer{Value: int64(i)}, &Integer{Value: int64(i)})
+	}
+
+	copied := hash.Copy()
+	for i := 0; i < n; i += 2 {
+		hash.Delete(&Integer{Value: int64(i)})
+	}
+	hash.Set(&Integer{Value: 1}, &String{Value: "one"})
+	hash.Set(&Integer{Value: 0}, &String{Value: "zero"})
+
+	if hash.Len() != n/2+1 || copied.Len() != n {
+		t.Fatalf("wrong len. got=%d and %d", hash.Len(), copied.Len())
+	}
+
+	for i := 0; i < n; i++ {
+		value, ok := copied.Get(&Integer{Value: int64(i)})
+		if !ok || value.(*Integer).Value != int64(i) {
+			t.Fatalf("copy changed by the original at %d. got=%v", i, value)
+		}
+
+		_, ok = hash.Get(&Integer{Value: int64(i)})
+		if ok != (i%2 == 1 || i == 0) {
+			t.Fatalf("wrong keys after delete at %d", i)
+		}
+	}
+
+	// 1 keeps its place, 0 is new again and comes last
+	pairs := hash.OrderedPairs()
+	if pairs[0].Value.Inspect() != "one" || pairs[1].Key.Inspect() != "3" || pairs[len(pairs)-1].Value.Inspect() != "zero" {
+		t.Errorf("wrong order. got=%s, %s, ..., %s", pairs[0].Value.Inspect(), pairs[1].Key.Inspect(), pairs[len(pairs)-1].Value.Inspect())
+	}
+}
@@ -0,0 +1,158 @@
+package object
+
+////////////////////////////////////////////////////////////////////////////////
+// vector is the persistent storage of Array: a tree of 32 wide nodes with the elements in the leaves,
+// and the last (up to 32) elements in a tail outside the tree.
+// A vector is never changed, push returns a new one which shares all the leaves and nodes
+// it did not have to copy: the tail, and the path to the new leaf once every 32 pushes.
+// drop (for rest and slices up to the end) only moves offset, the elements before it stay in the tree
+// as long as some array shares it; once they are more than the ones left, the ones left are copied to a tree of their own.
+
+const (
+	vectorBits  = 5
+	vectorWidth = 1 << vectorBits
+	vectorMask  = vectorWidth - 1
+)
+
+type vectorNode struct {
+	children []*vectorNode // the nodes above the leaves
+	elements []Object      // the leaves
+}
+
+// the zero vector is empty
+type vector struct {
+	offset int // the elements dropped by rest
+	count  int // all elements, the dropped ones too
+	shift  uint
+	root   *vectorNode
+	tail   []Object
+}
+
+func newVector(elements []Object) vector {
+	var v vector
+
+	// every full leaf goes into the tree, the last one (full or not) is the tail
+	leaves := 0
+	if len(elements) > 0 {
+		leaves = (len(elements) - 1) >> vectorBits
+	}
+	for i := 0; i < leaves; i++ {
+		leaf := append([]Object(nil), elements[i<<vectorBits:(i+1)<<vectorBits]...)
+		v.root, v.shift = v.withLeaf(i, leaf)
+	}
+	v.tail = append([]Object(nil), elements[leaves<<vectorBits:]...)
+	v.count = len(elements)
+
+	return v
+}
+
+func (v vector) len() int {
+	return v.count - v.offset
+}
+
+// at is element i, counted from offset
+func (v vector) at(i int) Object {
+	i += v.offset
+	return v.leaf(i)[i&vectorMask]
+}
+
+// leaf holds the element at i, counted from the start of the tree
+func (v vector) leaf(i int) []Object {
+	if i >= v.count-len(v.tail) {
+		return v.tail
+	}
+
+	node := v.root
+	for level := v.shift; level > 0; level -= vectorBits {
+		node = node.children[(i>>level)&vectorMask]
+	}
+	return node.elements
+}
+
+func (v vector) push(el Object) vector {
+	if len(v.tail) == vectorWidth {
+		v.root, v.shift = v.withLeaf((v.count-vectorWidth)>>vectorBits, v.tail)
+		v.tail = nil
+	}
+
+	tail := make([]Object, len(v.tail)+1)
+	copy(tail, v.tail)
+	tail[len(v.tail)] = el
+
+	v.tail = tail
+	v.count++
+	return v
+}
+
+// drop is v without its first n elements, 0 <= n <= len()
+func (v vector) drop(n int) vector {
+	v.offset += n
+	if v.count > vectorWidth && v.offset > v.len() {
+		// most of the tree is dropped, keeping it all for the few left would hold on to the whole parent
+		return newVector(v.appendTo(make([]Object, 0, v.len()), 0, v.len()))
+	}
+	return v
+}
+
+// withLeaf is the tree with leaf added as its leaf number i, the tree holds the leaves before it
+func (v vector) withLeaf(i int, elements []Object) (*vectorNode, uint) {
+	leaf := &vectorNode{elements: elements}
+
+	if v.root == nil {
+		return &vectorNode{children: []*vectorNode{leaf}}, vectorBits
+	}
+
+	// the root is full, the tree gets one level higher
+	if i == 1<<v.shift {
+		root := &vectorNode{children: []*vectorNode{v.root, newVectorPath(v.shift, leaf)}}
+		return root, v.shift + vectorBits
+	}
+
+	return pushLeaf(v.shift, v.root, i, leaf), v.shift
+}
+
+// pushLeaf copies the path from parent down to where leaf number i goes
+func pushLeaf(level uint, parent *vectorNode, i int, leaf *vectorNode) *vectorNode {
+	idx := ((i << vectorBits) >> level) & vectorMask
+
+	children := make([]*vectorNode, len(parent.children), len(parent.children)+1)
+	copy(children, parent.children)
+
+	switch {
+	case level == vectorBits:
+		children = append(children, leaf)
+	case idx < len(children):
+		children[idx] = pushLeaf(level-vectorBits, children[idx], i, leaf)
+	default:
+		children = append(children, newVectorPath(level-vectorBits, leaf))
+	}
+
+	return &vectorNode{children: children}
+}
+
+func newVectorPath(level uint, leaf *vectorNode) *vectorNode {
+	if level == 0 {
+		return leaf
+	}
+	return &vectorNode{children: []*vectorNode{newVectorPath(level-vectorBits, leaf)}}
+}
+
+// appendTo appends the elements from start to end (counted from offset) to out, a leaf at a time
+func (v vector) appendTo(out []Object, start, end int) []Object {
+	start += v.offset
+	end += v.offset
+
+	for i := start; i < end; {
+		leaf := v.leaf(i)
+		from := i & vectorMask
+		to := len(leaf)
+		if n := end - i; to-from > n {
+			to = from + n
+		}
+
+		out = append(out, leaf[from:to]...)
+		i += to - from
+	}
+
+	return out
+}
@@ -0,0 +1,453 @@
+// Package optimize rewrites a program into one which gives the same results with less work at runtime:
+// operators on constants are computed once (60 * 60 * 24 is 86400), an if on a constant keeps only
+// the branch taken, and calls of trivial functions are replaced by their body.
+// An error is never computed ahead of time, 1 / 0 is left for the runtime to fail.
+package optimize
+
+import (
+	"xmonkey/analysis"
+	"xmonkey/ast"
+	"xmonkey/evaluator"
+	"xmonkey/object"
+	"xmonkey/token"
+)
+
+// Program optimizes program, to be run with scoping, in place and returns it
+func Program(program *ast.Program, scoping evaluator.Scoping) *ast.Program {
+	o := &optimizer{info: analysis.Resolve(program, scoping)}
+
+	// with LeakBlockScope a let in a block is bound in the enclosing scope only if the block runs,
+	// a call after the block may find the name unbound, so nothing is inlined
+	o.inline = !scoping.LeakBlockScope
+	o.declarations = declarations(program)
+
+	ast.Modify(program, o.optimize)
+	return program
+}
+
+type optimizer struct {
+	info   *analysis.Info
+	inline bool
+
+	// the fn declarations, which are hoisted and can run before the code above them
+	declarations []*ast.FunctionLiteral
+}
+
+func (o *optimizer) optimize(node ast.Node) ast.Node {
+	switch node := node.(type) {
+	case *ast.PrefixExpression:
+		return fold(node)
+
+	case *ast.InfixExpression:
+		return fold(node)
+
+	case *ast.ConditionalExpression:
+		if taken, ok := truthy(node.Condition); ok {
+			if taken {
+				return node.Consequence
+			}
+			return node.Alternative
+		}
+
+	case *ast.IfExpression:
+		return branch(node)
+
+	case *ast.CallExpression:
+		if o.inline {
+			return o.call(node)
+		}
+
+	case *ast.Program:
+		node.Statements = dropUnused(node.Statements)
+
+	case *ast.BlockStatement:
+		node.Statements = dropUnused(node.Statements)
+	}
+
+	return node
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// constants
+
+// constant is a literal, or a negative integer (the parser makes -5 a prefix on 5)
+func constant(expr ast.Expression) bool {
+	switch expr := expr.(type) {
+	case *ast.IntegerLiteral, *ast.Boolean, *ast.StringLiteral:
+		return true
+	case *ast.PrefixExpression:
+		_, ok := expr.Right.(*ast.IntegerLiteral)
+		return ok && expr.Operator == "-"
+	}
+	return false
+}
+
+// fold computes an operator on constants with the evaluator, so the result is the one of the runtime
+func fold(expr ast.Expression) ast.Expression {
+	var tok token.Token
+
+	switch e := expr.(type) {
+	case *ast.PrefixExpression:
+		if !constant(e.Right) || constant(e) {
+			return expr
+		}
+		tok = e.Token
+	case *ast.InfixExpression:
+		if !constant(e.Left) || !constant(e.Right) {
+			return expr
+		}
+		tok = e.Token
+	}
+
+	value := evaluator.Eval(expr, object.NewEnvironment())
+	switch value.(type) {
+	case *object.Integer, *object.Boolean, *object.String:
+	default:
+		// an error, it is raised when the code runs
+		return expr
+	}
+
+	node, err := evaluator.ObjectToNode(value, tok)
+	if err != nil {
+		return expr
+	}
+	return node.(ast.Expression)
+}
+
+// truthy tells if a constant condition holds, as the evaluator does: only false (and null) does not
+func truthy(expr ast.Expression) (taken bool, ok bool) {
+	if !constant(expr) {
+		return false, false
+	}
+	if b, isBool := expr.(*ast.Boolean); isBool {
+		return b.Value, true
+	}
+	return true, true
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// dead branches
+
+// branch keeps the block an if on a constant takes,
+// the block itself when it is a single expression, or under if (true) for its scope
+func branch(node *ast.IfExpression) ast.Expression {
+	taken, ok := truthy(node.Condition)
+	if !ok {
+		return node
+	}
+
+	block := node.Consequence
+	if !taken {
+		block = node.Alternative
+	}
+	if block == nil {
+		// the value is null, there is no literal for it: dropUnused takes it out where the value is not used
+		return node
+	}
+
+	if expr := single(block); expr != nil {
+		return expr
+	}
+
+	always := &ast.Boolean{Token: token.Token{Type: token.TRUE, RawString: "true", Line: node.Token.Line, Column: node.Token.Column}, Value: true}
+	return &ast.IfExpression{Token: node.Token, Condition: always, Consequence: block}
+}
+
+// single is the expression of a block which is only that, a fn declaration is not: it is bound in the block
+func single(block *ast.BlockStatement) ast.Expression {
+	if len(block.Statements) != 1 {
+		return nil
+	}
+	stmt, ok := block.Statements[0].(*ast.ExpressionStatement)
+	if !ok || stmt.Expr == nil {
+		return nil
+	}
+	if fn, ok := stmt.Expr.(*ast.FunctionLiteral); ok && fn.Name != nil {
+		return nil
+	}
+	return stmt.Expr
+}
+
+// dropUnused takes out the statements which do nothing: a constant, or an if (false) without else.
+// The last statement stays, its value is the value of the block.
+func dropUnused(stmts []ast.Statement) []ast.Statement {
+	kept := []ast.Statement{}
+	for i, stmt := range stmts {
+		if i == len(stmts)-1 || !unused(stmt) {
+			kept = append(kept, stmt)
+		}
+	}
+	return kept
+}
+
+func unused(stmt ast.Statement) bool {
+	exprStmt, ok := stmt.(*ast.ExpressionStatement)
+	if !ok {
+		return false
+	}
+	if constant(exprStmt.Expr) {
+		return true
+	}
+	if node, ok := exprStmt.Expr.(*ast.IfExpression); ok && node.Alternative == nil {
+		taken, ok := truthy(node.Condition)
+		return ok && !taken
+	}
+	return false
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// inlining
+
+// call replaces add(1, x) by the body of add when it is trivial: fn(a, b) { a + b },
+// and the body it gives can not fail
+func (o *optimizer) call(call *ast.CallExpression) ast.Expression {
+	fn := o.trivial(call)
+	if fn == nil {
+		return call
+	}
+
+	args := map[string]ast.Expression{}
+	for i, param := range fn.FormalParams {
+		args[param.(*ast.Identifier).Name] = call.ActualParams[i]
+	}
+
+	// every use gets its own copy of the arg, the ast stays a tree
+	body := ast.Copy(result(fn))
+	body = ast.Modify(body, func(node ast.Node) ast.Node {
+		if ident, ok := node.(*ast.Identifier); ok {
+			if arg, ok := args[ident.Name]; ok {
+				return ast.Copy(arg)
+			}
+		}
+		return node
+	})
+
+	// the args may make more constants
+	inlined, ok := ast.Modify(body, o.optimize).(ast.Expression)
+	if !ok || !safe(inlined) {
+		// the error would be raised without the frame of the call, its trace would change
+		return call
+	}
+	return inlined
+}
+
+// safe tells if expr can not fail whatever the values of its names are:
+// div(1, 0) is not inlined, the error has to come from inside div
+func safe(expr ast.Expression) bool {
+	if constant(expr) {
+		return true
+	}
+
+	switch expr := expr.(type) {
+	case *ast.Identifier:
+		return true
+
+	case *ast.PrefixExpression:
+		return expr.Operator == "!" && safe(expr.Right)
+
+	case *ast.InfixExpression:
+		switch expr.Operator {
+		case "==", "!=", "&&", "||", "??":
+			return safe(expr.Left) && safe(expr.Right)
+		}
+
+	case *ast.ConditionalExpression:
+		return safe(expr.Condition) && safe(expr.Consequence) && safe(expr.Alternative)
+
+	case *ast.ArrayLiteral:
+		for _, el := range expr.Elements {
+			if !safe(el) {
+				return false
+			}
+		}
+		return true
+
+	case *ast.HashLiteral:
+		// a key which is not a constant may not be hashable
+		for _, key := range expr.Keys {
+			if !constant(key) || !safe(expr.Pairs[key]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+// trivial is the fn called by call if it can be inlined:
+// it is bound once by a fn declaration or a let (and the call is after the let),
+// its body is one expression of operators on its params, and the args are constants or names.
+// A name is evaluated where the param is used, so for the call to fail as before the name has to be defined,
+// and the param used where it is always evaluated: not only on the right of && or a branch of ?:.
+func (o *optimizer) trivial(call *ast.CallExpression) *ast.FunctionLiteral {
+	ident, ok := call.CallableName.(*ast.Identifier)
+	if !ok {
+		return nil
+	}
+	sym := o.info.Uses[ident]
+	if sym == nil || (sym.Kind != analysis.LET && sym.Kind != analysis.FUNCTION) {
+		return nil
+	}
+	fn, ok := sym.Value.(*ast.FunctionLiteral)
+	if !ok || len(fn.FormalParams) != len(call.ActualParams) {
+		return nil
+	}
+
+	for _, other := range sym.Scope.Symbols {
+		if other != sym && other.Name == sym.Name {
+			return nil
+		}
+	}
+	// a let is bound when it runs, the call has to come later
+	if sym.Kind == analysis.LET && (!before(sym.Decl.Token, call.Token) || o.inDeclaration(call.Token)) {
+		return nil
+	}
+
+	expr := result(fn)
+	if expr == nil {
+		return nil
+	}
+
+	params := map[string]bool{}
+	for _, param := range fn.FormalParams {
+		ident, ok := param.(*ast.Identifier)
+		if !ok {
+			return nil
+		}
+		params[ident.Name] = true
+	}
+
+	simple := true
+	ast.Inspect(expr, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case nil, *ast.IntegerLiteral, *ast.Boolean, *ast.StringLiteral,
+			*ast.PrefixExpression, *ast.InfixExpression, *ast.ConditionalExpression,
+			*ast.ArrayLiteral, *ast.HashLiteral, *ast.IndexExpression, *ast.SliceExpression:
+		case *ast.Identifier:
+			if !params[node.Name] {
+				simple = false
+			}
+		default:
+			simple = false
+		}
+		return simple
+	})
+	if !simple {
+		return nil
+	}
+
+	always := map[string]int{}
+	evaluated(expr, always)
+
+	for i, arg := range call.ActualParams {
+		if constant(arg) {
+			continue
+		}
+		name, ok := arg.(*ast.Identifier)
+		if !ok || o.info.Uses[name] == nil || always[fn.FormalParams[i].(*ast.Identifier).Name] == 0 {
+			return nil
+		}
+	}
+
+	return fn
+}
+
+// evaluated counts the names of expr which are evaluated whenever expr is,
+// the right of &&, || and ??, the branches of ?: and what follows ?. may be skipped
+func evaluated(expr ast.Expression, names map[string]int) {
+	switch expr := expr.(type) {
+	case *ast.Identifier:
+		names[expr.Name]++
+
+	case *ast.PrefixExpression:
+		evaluated(expr.Right, names)
+
+	case *ast.InfixExpression:
+		evaluated(expr.Left, names)
+		if expr.Operator != "&&" && expr.Operator != "||" && expr.Operator != "??" {
+			evaluated(expr.Right, names)
+		}
+
+	case *ast.ConditionalExpression:
+		evaluated(expr.Condition, names)
+
+	case *ast.ArrayLiteral:
+		for _, el := range expr.Elements {
+			evaluated(el, names)
+		}
+
+	case *ast.HashLiteral:
+		for _, key := range expr.Keys {
+			evaluated(key, names)
+			evaluated(expr.Pairs[key], names)
+		}
+
+	case *ast.IndexExpression:
+		evaluated(expr.Left, names)
+		if !expr.Optional {
+			evaluated(expr.Index, names)
+		}
+
+	case *ast.SliceExpression:
+		evaluated(expr.Left, names)
+		if !expr.Optional {
+			evaluated(expr.Start, names)
+			evaluated(expr.End, names)
+		}
+	}
+}
+
+// result is the expression a fn body is made of: { a + b } or { return a + b; }
+func result(fn *ast.FunctionLiteral) ast.Expression {
+	if fn.Body == nil || len(fn.Body.Statements) != 1 {
+		return nil
+	}
+	switch stmt := fn.Body.Statements[0].(type) {
+	case *ast.ExpressionStatement:
+		return stmt.Expr
+	case *ast.ReturnStatement:
+		return stmt.Expr
+	}
+	return nil
+}
+
+// declarations finds all fn declarations of program
+func declarations(program *ast.Program) []*ast.FunctionLiteral {
+	var fns []*ast.FunctionLiteral
+
+	add := func(stmts []ast.Statement) {
+		for _, stmt := range stmts {
+			if exprStmt, ok := stmt.(*ast.ExpressionStatement); ok {
+				if fn, ok := exprStmt.Expr.(*ast.FunctionLiteral); ok && fn.Name != nil && fn.Body != nil {
+					fns = append(fns, fn)
+				}
+			}
+		}
+	}
+
+	ast.Inspect(program, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.Program:
+			add(node.Statements)
+		case *ast.BlockStatement:
+			add(node.Statements)
+		}
+		return true
+	})
+
+	return fns
+}
+
+func (o *optimizer) inDeclaration(tok token.Token) bool {
+	for _, fn := range o.declarations {
+		if before(fn.Token, tok) && before(tok, fn.Body.Rbrace) {
+			return true
+		}
+	}
+	return false
+}
+
+func before(a, b token.Token) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+}
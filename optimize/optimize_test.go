@@ -0,0 +1,146 @@
+package optimize
+
+import (
+	"testing"
+
+	"xmonkey/ast"
+	"xmonkey/evaluator"
+	"xmonkey/lexer"
+	"xmonkey/object"
+	"xmonkey/parser"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("%q: parser errors: %v", input, p.Errors())
+	}
+	return program
+}
+
+func TestProgram(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// constant folding
+		{`60 * 60 * 24`, `86400`},
+		{`"prefix" + "suffix"`, `prefixsuffix`},
+		{`let f = fn(x) { x * (60 * 60) }`, `let f = fn(x) (x*3600);`},
+		{`1 - 5`, `(-4)`},
+		{`-(-5) + 1`, `6`},
+		{`!true == false`, `true`},
+		{`2 ** 10 >> 2`, `256`},
+		{`x + 1 + 2`, `((x+1)+2)`},
+		{`1 / 0`, `(1/0)`},
+		{`1 + "a"`, `(1+a)`},
+		{`true && 3`, `3`},
+		// dead branches
+		{`if (true) { 1 } else { 2 }`, `1`},
+		{`if (1 > 2) { a } else { b }`, `b`},
+		{`if (0) { a }`, `a`},
+		{`if (false) { a } else { let b = 1; b }`, `iftrue let b = 1;b`},
+		{`if (true) { let a = 1; a } else { b }`, `iftrue let a = 1;a`},
+		{`if (x) { 1 + 1 } else { 2 }`, `ifx 2else 2`},
+		{`if (false) { a }; b`, `b`},
+		{`b; if (false) { a }`, `biffalse a`},
+		{`1; 2; 3`, `3`},
+		{`1 > 2 ? a : b`, `b`},
+		{`if (true) { fn g() { 1 } }`, `iftrue fn g() 1`},
+		// inlining
+		{`let double = fn(x) { x * 2 }; double(3)`, `let double = fn(x) (x*2);6`},
+		{`let y = 5; let add = fn(a, b) { return a + b; }; add(y, 1)`, `let y = 5;let add = fn(a, b) return (a+b);;add(y,1)`},
+		{`let y = 5; let same = fn(a, b) { a == b }; same(y, 1)`, `let y = 5;let same = fn(a, b) (a==b);(y==1)`},
+		{`let div = fn(a, b) { a / b }; div(1, 0)`, `let div = fn(a, b) (a/b);div(1,0)`},
+		{`let add = fn(a, b) { return a + b; }; add(missing, 1)`, `let add = fn(a, b) return (a+b);;add(missing,1)`},
+		{`fn sq(n) { n * n }; sq(sq(3))`, `fn sq(n) (n*n)81`},
+		// sq(2) is 4 before the declaration, a constant nobody uses
+		{`sq(2); fn sq(n) { n * n }`, `fn sq(n) (n*n)`},
+		{`let f = fn(a) { [a, {"k": a}] }; f(1)`, `let f = fn(a) [a,{k:a}];[1,{k:1}]`},
+		{`let ignore = fn(a) { 1 }; ignore(x)`, `let ignore = fn(a) 1;ignore(x)`},
+		{`let ignore = fn(a) { 1 }; ignore(2)`, `let ignore = fn(a) 1;1`},
+		{`let inc = fn(a) { a + 1 }; inc(f(1))`, `let inc = fn(a) (a+1);inc(f(1))`},
+		{`let g = fn(a) { a + z }; g(1)`, `let g = fn(a) (a+z);g(1)`},
+		{`let g = fn(a) { len(a) }; g(1)`, `let g = fn(a) len(a);g(1)`},
+		{`let g = fn(a) { a }; g(1, 2)`, `let g = fn(a) a;g(1,2)`},
+		{`let g = fn(a) { a }; let g = fn(a) { -a }; g(1)`, `let g = fn(a) a;let g = fn(a) (-a);g(1)`},
+		{`let h = fn() { g(1) }; let g = fn(a) { a }; h()`, `let h = fn() g(1);let g = fn(a) a;h()`},
+		{`let g = fn(a) { a }; fn h() { g(1) }`, `let g = fn(a) a;fn h() g(1)`},
+		{`let g = fn(a) { a }; let f = fn(g) { g(1) }`, `let g = fn(a) a;let f = fn(g) g(1);`},
+		{`let both = fn(a, b) { a && b }; both(false, missing)`, `let both = fn(a, b) (a&&b);both(false,missing)`},
+		{`let y = 1; let both = fn(a, b) { a && b }; both(y, 2)`, `let y = 1;let both = fn(a, b) (a&&b);(y&&2)`},
+	}
+
+	for _, tt := range tests {
+		program := Program(parse(t, tt.input), evaluator.Scoping{})
+
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestLeakBlockScope(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let g = fn(a) { a }; if (c) { let g = fn(a) { -a }; }; g(1)`, `let g = fn(a) a;ifc let g = fn(a) (-a);g(1)`},
+		// g is not bound if the block does not run
+		{`if (c) { let g = fn(a) { a }; }; g(1)`, `ifc let g = fn(a) a;g(1)`},
+	}
+
+	for _, tt := range tests {
+		program := Program(parse(t, tt.input), evaluator.Scoping{LeakBlockScope: true})
+
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+// the optimized program gives the same result as the one written
+func TestSameResults(t *testing.T) {
+	inputs := []string{
+		`let day = fn(n) { n * 60 * 60 * 24 }; day(2)`,
+		`let greet = fn(name) { "hello, " + name }; greet("monkey")`,
+		`let f = fn(x) { if (true) { x + 1 } else { x - 1 } }; f(1)`,
+		`let f = fn(x) { if (1 > 2) { let y = x; y } else { let y = x * 2; y } }; f(4)`,
+		`let x = 1; if (false) { let x = 2; }; x`,
+		`let sq = fn(n) { n * n }; let sum = fn(a, b) { a + b }; sum(sq(3), sq(4))`,
+		`fn fact(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; fact(10)`,
+		`let pick = fn(c, a, b) { c ? a : b }; [pick(true, 1, 2), pick(false, 1, 2), pick(0, 1, 2)]`,
+		`let div = fn(a, b) { a / b }; div(10, 0)`,
+		`let neg = fn(a) { -a }; neg(-5) + neg(3)`,
+		`let both = fn(a, b) { a && b }; [both(true, 1), both(false, 1), both(1, "x")]`,
+		`let first = fn(a) { a[0] }; first([7, 8])`,
+		`let id = fn(a) { a }; id(missing)`,
+		`let t = try { 1 / 0 } catch (e) { e["kind"] }; t`,
+		`-(2 ** 63) - 1`,
+		`"a" < "b" == true`,
+		// a param which may not be evaluated: the missing name fails the call, not the inlined body
+		`let both = fn(a, b) { a && b }; both(false, missing)`,
+		`let either = fn(a, b) { a || b }; either(true, missing)`,
+		`let orz = fn(a, b) { a ?? b }; orz(1, missing)`,
+		`let pick = fn(c, a, b) { c ? a : b }; pick(true, 1, missing)`,
+		`let at = fn(h, k) { h?.[k] }; at(null, missing)`,
+		`let add = fn(a, b) { b + a }; add(missing, other)`,
+		`let x = 2; let both = fn(a, b) { a && b }; both(false, x)`,
+		// an error keeps the frame of the call it is raised in
+		`fn div(a, b) { a / b }; try { div(1, 0) } catch (e) { e["trace"] }`,
+		`let y = "a"; let add = fn(a, b) { a + b }; try { add(y, 1) } catch (e) { e["trace"] }`,
+		`let at = fn(a, i) { a[i] }; try { at(1, 0) } catch (e) { e["trace"] }`,
+	}
+
+	for _, input := range inputs {
+		want := evaluator.Eval(parse(t, input), object.NewEnvironment())
+
+		optimized := Program(parse(t, input), evaluator.Scoping{})
+		got := evaluator.Eval(optimized, object.NewEnvironment())
+
+		if got.Inspect() != want.Inspect() {
+			t.Errorf("%q: optimized to %q, got=%q, want=%q", input, optimized.String(), got.Inspect(), want.Inspect())
+		}
+	}
+}
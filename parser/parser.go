@@ -13,14 +13,35 @@ import (
 const (
 	_ int = iota
 	LOWEST
+
+	// c ? a : b
+	TERNARY
+
+	// a ?? b
+	NULLISH
+
+	// && and || are below the comparisons: a < b && b < c
+	OR
+	AND
+
 	EQUALS
 	LESSGREATER
+
+	// bitwise ops bind tighter than comparisons, x & 1 == 0 is (x & 1) == 0
+	BITOR
+	BITXOR
+	BITAND
+	SHIFT
+
 	SUM
 	PRODUCT
 
-	// PREFIX means ! -
+	// PREFIX means ! - ~
 	PREFIX
 
+	// ** is above PREFIX, so -2 ** 2 is -(2 ** 2)
+	POWER
+
 	// CALL means function call (
 	CALL
 
@@ -30,19 +51,43 @@ const (
 
 // used in infix
 var precedences = map[token.TokenType]int{
+	token.QUESTION: TERNARY,
+	token.NULLISH:  NULLISH,
+	token.OR:       OR,
+	token.AND:      AND,
 	token.EQ:       EQUALS,
 	token.NOT_EQ:   EQUALS,
 	token.LT:       LESSGREATER,
 	token.GT:       LESSGREATER,
+	token.LT_EQ:    LESSGREATER,
+	token.GT_EQ:    LESSGREATER,
 	token.PLUS:     SUM,
 	token.MINUS:    SUM,
 	token.SLASH:    PRODUCT,
 	token.ASTERISK: PRODUCT,
+	token.PERCENT:  PRODUCT,
+	token.POWER:    POWER,
+
+	token.BIT_OR:      BITOR,
+	token.BIT_XOR:     BITXOR,
+	token.BIT_AND:     BITAND,
+	token.SHIFT_LEFT:  SHIFT,
+	token.SHIFT_RIGHT: SHIFT,
 
 	// ( is for function call, which is the heighest priority
 	token.LPAREN: CALL,
 
-	token.LBRACKET: INDEX,
+	token.LBRACKET:       INDEX,
+	token.OPTIONAL_CHAIN: INDEX,
+}
+
+// Precedence is how tight the infix operator op binds, LOWEST if op is not one.
+// The formatter uses it to know where parentheses are needed.
+func Precedence(op string) int {
+	if p, ok := precedences[token.TokenType(op)]; ok {
+		return p
+	}
+	return LOWEST
 }
 
 type (
@@ -60,6 +105,8 @@ type Parser struct {
 	infixParseFns  map[token.TokenType]infixParseFn
 
 	errors []string
+	// the token each of errors is about, for the positions
+	errorTokens []token.Token
 }
 
 // New creates a new parser
@@ -79,6 +126,8 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	// -5
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
+	// ~5
+	p.registerPrefix(token.TILDE, p.parsePrefixExpression)
 
 	// 1 * ( 2 + 3) + 4
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
@@ -88,6 +137,7 @@ func New(l *lexer.Lexer) *Parser {
 
 	// fn(a, b) { let c = a + b; c }
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
+	p.registerPrefix(token.MACRO, p.parseMacroLiteral)
 
 	// "abc"
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
@@ -98,6 +148,12 @@ func New(l *lexer.Lexer) *Parser {
 	// {"one": 1, "two": 2}
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
 
+	// match (x) { 0 => "zero", _ => "other" }
+	p.registerPrefix(token.MATCH, p.parseMatchExpression)
+
+	// try { ... } catch (e) { ... } finally { ... }
+	p.registerPrefix(token.TRY, p.parseTryExpression)
+
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	// 都是 infix，尽管类型多，但是构造的 ast.node 类型是一样的 InfixExpression，
 	// 在 eval 顶层是一个入口， 然后根据 op 不同，再做不同的 case 处理
@@ -109,6 +165,17 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.POWER, p.parseInfixExpression)
+	p.registerInfix(token.BIT_AND, p.parseInfixExpression)
+	p.registerInfix(token.BIT_OR, p.parseInfixExpression)
+	p.registerInfix(token.BIT_XOR, p.parseInfixExpression)
+	p.registerInfix(token.SHIFT_LEFT, p.parseInfixExpression)
+	p.registerInfix(token.SHIFT_RIGHT, p.parseInfixExpression)
 
 	// ( 是 fun call 的 infix op，处理逻辑和 +- 等上面的不同
 	// 返回 CallExpression, 直接在 eval 顶层直接处理
@@ -117,6 +184,13 @@ func New(l *lexer.Lexer) *Parser {
 	// arr[2]
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
 
+	// c ? a : b
+	p.registerInfix(token.QUESTION, p.parseConditionalExpression)
+	// a ?? b is evaluated lazily like && and ||, but parsed the same as +
+	p.registerInfix(token.NULLISH, p.parseInfixExpression)
+	// h?.["k"], obj?.field
+	p.registerInfix(token.OPTIONAL_CHAIN, p.parseOptionalChain)
+
 	p.nextToken()
 	p.nextToken()
 
@@ -163,13 +237,23 @@ func (p *Parser) curPrecedence() int {
 func (p *Parser) peekError(t token.TokenType) {
 	msg := fmt.Sprintf("expect next token to be %s. got %s instead", t, p.peekToken.Type)
 
-	p.errors = append(p.errors, msg)
+	p.addError(p.peekToken, msg)
 }
 
 func (p *Parser) Errors() []string {
 	return p.errors
 }
 
+// ErrorTokens returns the token each of Errors is about, so the errors can be shown where they are
+func (p *Parser) ErrorTokens() []token.Token {
+	return p.errorTokens
+}
+
+func (p *Parser) addError(tok token.Token, msg string) {
+	p.errors = append(p.errors, msg)
+	p.errorTokens = append(p.errorTokens, tok)
+}
+
 func (p *Parser) registerPrefix(tokenType token.TokenType, fn prefixParseFn) {
 	p.prefixParseFns[tokenType] = fn
 }
@@ -209,6 +293,8 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.THROW:
+		return p.parseThrowStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -236,6 +322,8 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		p.nextToken()
 	}
 
+	block.Rbrace = p.curToken
+
 	return block
 }
 
@@ -246,12 +334,33 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 func (p *Parser) parseLetStatement() *ast.LetStatement {
 	stmt := &ast.LetStatement{Token: p.curToken}
 
-	// expectPeek 如果返回 true，会调用 p.nextToken()，消耗 input 向前推进，curToken 已经变了
-	if !p.expectPeek(token.IDENT) {
-		return nil
+	// let [a, b] = ...; let {name} = ...; 解构
+	if p.peekTokenIs(token.LBRACKET) || p.peekTokenIs(token.LBRACE) {
+		p.nextToken()
+
+		stmt.Pattern = p.parsePattern()
+		if stmt.Pattern == nil {
+			return nil
+		}
+	} else {
+		// expectPeek 如果返回 true，会调用 p.nextToken()，消耗 input 向前推进，curToken 已经变了
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+
+		stmt.Name = &ast.Identifier{Token: p.curToken, Name: p.curToken.RawString}
 	}
 
-	stmt.Name = &ast.Identifier{Token: p.curToken, Name: p.curToken.RawString}
+	// let x: int = 1; 类型标注是可选的
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		p.nextToken()
+
+		stmt.Type = p.parseType()
+		if stmt.Type == nil {
+			return nil
+		}
+	}
 
 	// if expectPeek return true, it will call nextToken, means move curToken to =
 	if !p.expectPeek(token.ASSIGN) {
@@ -286,6 +395,24 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+// statement: throw expr;
+func (p *Parser) parseThrowStatement() *ast.ThrowStatement {
+	stmt := &ast.ThrowStatement{Token: p.curToken}
+
+	p.nextToken()
+
+	stmt.Expr = p.parseExpression(LOWEST)
+	if stmt.Expr == nil {
+		return nil
+	}
+
+	for p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 // statement-3: expressionStatement
 var nestLevel = 0
@@ -390,7 +517,7 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
 	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	p.addError(p.curToken, msg)
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -433,7 +560,7 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	value, err := strconv.ParseInt(p.curToken.RawString, 0, 64)
 	if err != nil {
 		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.RawString)
-		p.errors = append(p.errors, msg)
+		p.addError(p.curToken, msg)
 		return nil
 	}
 
@@ -455,6 +582,7 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 	arr := &ast.ArrayLiteral{Token: p.curToken}
 
 	arr.Elements = p.parseExpressionList(token.RBRACKET)
+	arr.Rbracket = p.curToken
 
 	return arr
 }
@@ -499,7 +627,7 @@ func (p *Parser) parseGroupedExpression() ast.Expression {
 
 // expression: fn(a, b) { a + b; }
 // this is fun definition, not call
-// Notice: no name for the fn, only can use let to assign
+// fn(a) { } has no name, only can use let to assign; fn name(a) { } is named
 // ast.Expression is interface. the actual return value is struct pointer
 func (p *Parser) parseFunctionLiteral() ast.Expression {
 	// actual return value is pointer, which impl the interface
@@ -507,11 +635,30 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	// curToken is fixed to fn, see registerInfix
 	fn := &ast.FunctionLiteral{Token: p.curToken}
 
+	if p.peekTokenIs(token.IDENT) {
+		p.nextToken()
+		fn.Name = &ast.Identifier{Token: p.curToken, Name: p.curToken.RawString}
+	}
+
 	if !p.expectPeek(token.LPAREN) {
 		return nil
 	}
 
-	fn.FormalParams = p.parseFormalParams()
+	fn.FormalParams, fn.ParamTypes = p.parseFormalParams()
+	if fn.FormalParams == nil {
+		return nil
+	}
+
+	// fn(a): int { ... }
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		p.nextToken()
+
+		fn.ReturnType = p.parseType()
+		if fn.ReturnType == nil {
+			return nil
+		}
+	}
 
 	if !p.expectPeek(token.LBRACE) {
 		return nil
@@ -522,38 +669,186 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	return fn
 }
 
-// 函数定义时的 形参，只能是 identifier, 只需要 name，不需要 eval;
+// expression: macro(cond, body) { quote(...) }
+// the params are plain names, the args of a macro call are quotes, there is nothing to destructure
+func (p *Parser) parseMacroLiteral() ast.Expression {
+	macro := &ast.MacroLiteral{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	params, types := p.parseFormalParams()
+	if params == nil {
+		return nil
+	}
+	if types != nil {
+		p.addError(macro.Token, "macro params can not have types")
+		return nil
+	}
+	for _, param := range params {
+		ident, ok := param.(*ast.Identifier)
+		if !ok {
+			p.addError(macro.Token, fmt.Sprintf("macro params must be names, got %s", param.String()))
+			return nil
+		}
+		macro.Parameters = append(macro.Parameters, ident)
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	macro.Body = p.parseBlockStatement()
+
+	return macro
+}
+
+// 函数定义时的 形参，是 identifier 或者解构的 [a, b] / {name}, 只需要 name，不需要 eval;
 // 形参的 name 在  callExpression 的 eval 时使用，作为 实参 的 name，保存在 callEnv 中
-func (p *Parser) parseFormalParams() []*ast.Identifier {
-	// ast.Identifier is struct, so use the pointer.
-	identifiers := []*ast.Identifier{}
+// 每个形参可以有类型标注 a: int, types 在没有任何标注时为 nil，否则和 params 一一对应
+func (p *Parser) parseFormalParams() ([]ast.Pattern, []ast.TypeExpr) {
+	params := []ast.Pattern{}
+	types := []ast.TypeExpr{}
+	annotated := false
 
 	//  没有参数
 	if p.peekTokenIs(token.RPAREN) {
 		p.nextToken()
-		return identifiers
+		return params, nil
 	}
 
-	// 第一个参数
-	p.nextToken()
+	for {
+		// 第一个参数, 以及 , 之后的参数
+		p.nextToken()
 
-	ident := &ast.Identifier{Token: p.curToken, Name: p.curToken.RawString}
-	identifiers = append(identifiers, ident)
+		param := p.parseFormalParam()
+		if param == nil {
+			return nil, nil
+		}
+		params = append(params, param)
+
+		var typ ast.TypeExpr
+		if p.peekTokenIs(token.COLON) {
+			p.nextToken()
+			p.nextToken()
+
+			typ = p.parseType()
+			if typ == nil {
+				return nil, nil
+			}
+			annotated = true
+		}
+		types = append(types, typ)
 
-	for p.peekTokenIs(token.COMMA) {
-		//  后续参数
-		p.nextToken()
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
 		p.nextToken()
-		ident := &ast.Identifier{Token: p.curToken, Name: p.curToken.RawString}
-		identifiers = append(identifiers, ident)
 	}
 
 	// 参数的右括号
 	if !p.expectPeek(token.RPAREN) {
+		return nil, nil
+	}
+
+	if !annotated {
+		return params, nil
+	}
+	return params, types
+}
+
+// a literal can not be a param by itself, only inside [] or {}
+func (p *Parser) parseFormalParam() ast.Pattern {
+	switch p.curToken.Type {
+	case token.IDENT, token.LBRACKET, token.LBRACE:
+		return p.parsePattern()
+	default:
+		msg := fmt.Sprintf("unexpected %s in parameter list", p.curToken.Type)
+		p.addError(p.curToken, msg)
+		return nil
+	}
+}
+
+// parseType parses a type annotation starting at curToken:
+// int string bool null any, [elem], {key: value}, fn(param, ...): return
+func (p *Parser) parseType() ast.TypeExpr {
+	switch p.curToken.Type {
+	case token.IDENT:
+		switch p.curToken.RawString {
+		case "int", "string", "bool", "null", "any":
+			return &ast.NamedType{Token: p.curToken, Name: p.curToken.RawString}
+		}
+		p.addError(p.curToken, fmt.Sprintf("unknown type %s", p.curToken.RawString))
+		return nil
+
+	case token.LBRACKET:
+		typ := &ast.ArrayType{Token: p.curToken}
+		p.nextToken()
+
+		typ.Elem = p.parseType()
+		if typ.Elem == nil || !p.expectPeek(token.RBRACKET) {
+			return nil
+		}
+		return typ
+
+	case token.LBRACE:
+		typ := &ast.HashType{Token: p.curToken}
+		p.nextToken()
+
+		typ.Key = p.parseType()
+		if typ.Key == nil || !p.expectPeek(token.COLON) {
+			return nil
+		}
+		p.nextToken()
+
+		typ.Value = p.parseType()
+		if typ.Value == nil || !p.expectPeek(token.RBRACE) {
+			return nil
+		}
+		return typ
+
+	case token.FUNCTION:
+		return p.parseFunctionType()
+	}
+
+	p.addError(p.curToken, fmt.Sprintf("expect a type, got %s instead", p.curToken.Type))
+	return nil
+}
+
+func (p *Parser) parseFunctionType() ast.TypeExpr {
+	typ := &ast.FunctionType{Token: p.curToken, Params: []ast.TypeExpr{}}
+
+	if !p.expectPeek(token.LPAREN) {
 		return nil
 	}
 
-	return identifiers
+	for !p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+
+		param := p.parseType()
+		if param == nil {
+			return nil
+		}
+		typ.Params = append(typ.Params, param)
+
+		if !p.peekTokenIs(token.RPAREN) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+	p.nextToken()
+
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		p.nextToken()
+
+		typ.Return = p.parseType()
+		if typ.Return == nil {
+			return nil
+		}
+	}
+
+	return typ
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -575,6 +870,12 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	precedure := p.curPrecedence()
 	p.nextToken()
 
+	// ** is right associative, 2 ** 3 ** 2 is 2 ** (3 ** 2):
+	// a lower precedence lets the next ** be taken by the right side
+	if expression.Operator == token.POWER {
+		precedure--
+	}
+
 	// 这里是递归，两个函数之间
 	// 把 + 的优先级传入，后续会和 * 的优先级比较
 	expression.Right = p.parseExpression(precedure)
@@ -590,6 +891,7 @@ func (p *Parser) parseCallExpression(fn ast.Expression) ast.Expression {
 	expr := &ast.CallExpression{Token: p.curToken, CallableName: fn}
 
 	expr.ActualParams = p.parseExpressionList(token.RPAREN)
+	expr.Rparen = p.curToken
 
 	return expr
 }
@@ -598,10 +900,22 @@ func (p *Parser) parseCallExpression(fn ast.Expression) ast.Expression {
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	exp := &ast.IndexExpression{Token: p.curToken, Left: left}
 
+	// arr[:2]
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		return p.parseSliceExpression(left, exp.Token, nil)
+	}
+
 	p.nextToken()
 
 	exp.Index = p.parseExpression(LOWEST)
 
+	// arr[1:2], arr[1:]
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		return p.parseSliceExpression(left, exp.Token, exp.Index)
+	}
+
 	// skip the right ]
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
@@ -609,6 +923,72 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	return exp
 }
 
+// the start (if any) is already parsed, curToken is :
+func (p *Parser) parseSliceExpression(left ast.Expression, tok token.Token, start ast.Expression) ast.Expression {
+	exp := &ast.SliceExpression{Token: tok, Left: left, Start: start}
+
+	if !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		exp.End = p.parseExpression(LOWEST)
+	}
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+	return exp
+}
+
+// c ? a : b, the condition is already parsed, curToken is ?
+func (p *Parser) parseConditionalExpression(condition ast.Expression) ast.Expression {
+	expr := &ast.ConditionalExpression{Token: p.curToken, Condition: condition}
+
+	p.nextToken()
+	expr.Consequence = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.COLON) {
+		return nil
+	}
+
+	// LOWEST, not TERNARY: a ? b : c ? d : e is a ? b : (c ? d : e)
+	p.nextToken()
+	expr.Alternative = p.parseExpression(LOWEST)
+
+	return expr
+}
+
+// h?.["k"] or obj?.field, curToken is ?.
+// obj?.field is the same as obj?.["field"], the StringLiteral keeps the IDENT token it was written with.
+func (p *Parser) parseOptionalChain(left ast.Expression) ast.Expression {
+	switch {
+	case p.peekTokenIs(token.LBRACKET):
+		p.nextToken()
+
+		switch exp := p.parseIndexExpression(left).(type) {
+		case *ast.IndexExpression:
+			exp.Optional = true
+			return exp
+		case *ast.SliceExpression:
+			exp.Optional = true
+			return exp
+		default:
+			return nil
+		}
+
+	case p.peekTokenIs(token.IDENT):
+		exp := &ast.IndexExpression{Token: p.curToken, Left: left, Optional: true}
+
+		p.nextToken()
+		exp.Index = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.RawString}
+
+		return exp
+
+	default:
+		msg := fmt.Sprintf("expect [ or field name after ?., got %s instead", p.peekToken.Type)
+		p.addError(p.peekToken, msg)
+		return nil
+	}
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 // compound expression: if ( condition ) { consequence } else  { alternative }
 func (p *Parser) parseIfExpression() ast.Expression {
@@ -644,6 +1024,56 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return expr
 }
 
+// try { block } catch (e) { handler } finally { cleanup }, catch or finally may be left out, but not both
+func (p *Parser) parseTryExpression() ast.Expression {
+	expr := &ast.TryExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	expr.Block = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+
+		// catch (e) binds the error, catch { } ignores it
+		if p.peekTokenIs(token.LPAREN) {
+			p.nextToken()
+
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			expr.CatchParam = &ast.Identifier{Token: p.curToken, Name: p.curToken.RawString}
+
+			if !p.expectPeek(token.RPAREN) {
+				return nil
+			}
+		}
+
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		expr.Catch = p.parseBlockStatement()
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		expr.Finally = p.parseBlockStatement()
+	}
+
+	if expr.Catch == nil && expr.Finally == nil {
+		msg := fmt.Sprintf("expect catch or finally after try block, got %s instead", p.peekToken.Type)
+		p.addError(p.peekToken, msg)
+		return nil
+	}
+
+	return expr
+}
+
 func (p *Parser) parseHashLiteral() ast.Expression {
 	hash := &ast.HashLiteral{Token: p.curToken}
 	hash.Pairs = make(map[ast.Expression]ast.Expression)
@@ -660,6 +1090,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		value := p.parseExpression(LOWEST)
 
 		hash.Pairs[key] = value
+		hash.Keys = append(hash.Keys, key)
 
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
 			return nil
@@ -669,6 +1100,192 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	if !p.expectPeek(token.RBRACE) {
 		return nil
 	}
+	hash.Rbrace = p.curToken
 
 	return hash
 }
+
+////////////////////////////////////////////////////////////////////////////////
+// match (subject) { pattern => body, pattern if guard => body, }
+func (p *Parser) parseMatchExpression() ast.Expression {
+	expr := &ast.MatchExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	expr.Subject = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+
+		arm := &ast.MatchArm{Token: p.curToken}
+
+		arm.Pattern = p.parsePattern()
+		if arm.Pattern == nil {
+			return nil
+		}
+
+		if p.peekTokenIs(token.IF) {
+			p.nextToken()
+			p.nextToken()
+			arm.Guard = p.parseExpression(LOWEST)
+		}
+
+		if !p.expectPeek(token.ARROW) {
+			return nil
+		}
+
+		p.nextToken()
+		arm.Body = p.parseExpression(LOWEST)
+
+		expr.Arms = append(expr.Arms, arm)
+
+		// the comma after the last arm is optional
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	expr.Rbrace = p.curToken
+
+	return expr
+}
+
+// parsePattern parses the pattern starting at curToken:
+// a name, _, a literal, [p1, p2, ...rest] or {"key": p, name}
+func (p *Parser) parsePattern() ast.Pattern {
+	switch p.curToken.Type {
+	case token.IDENT:
+		return &ast.Identifier{Token: p.curToken, Name: p.curToken.RawString}
+
+	case token.INT, token.STRING, token.TRUE, token.FALSE:
+		pattern := &ast.LiteralPattern{Token: p.curToken}
+		pattern.Value = p.prefixParseFns[p.curToken.Type]()
+		if pattern.Value == nil {
+			return nil
+		}
+		return pattern
+
+	case token.MINUS:
+		// negative numbers are the only prefix expression allowed in a pattern
+		pattern := &ast.LiteralPattern{Token: p.curToken}
+		if !p.expectPeek(token.INT) {
+			return nil
+		}
+		right := p.parseIntegerLiteral()
+		if right == nil {
+			return nil
+		}
+		pattern.Value = &ast.PrefixExpression{Token: pattern.Token, Operator: "-", Right: right}
+		return pattern
+
+	case token.LBRACKET:
+		return p.parseArrayPattern()
+
+	case token.LBRACE:
+		return p.parseHashPattern()
+
+	default:
+		msg := fmt.Sprintf("unexpected %s in pattern", p.curToken.Type)
+		p.addError(p.curToken, msg)
+		return nil
+	}
+}
+
+// [a, [b, c], ...rest], the ...rest can only be the last one
+func (p *Parser) parseArrayPattern() ast.Pattern {
+	pattern := &ast.ArrayPattern{Token: p.curToken}
+
+	for !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+
+		if p.curTokenIs(token.ELLIPSIS) {
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			pattern.Rest = &ast.Identifier{Token: p.curToken, Name: p.curToken.RawString}
+
+			// nothing after the rest
+			break
+		}
+
+		el := p.parsePattern()
+		if el == nil {
+			return nil
+		}
+		pattern.Elements = append(pattern.Elements, el)
+
+		if !p.peekTokenIs(token.RBRACKET) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+
+	return pattern
+}
+
+// {"name": n, "age": 20, id}, keys are literals, id alone is short for "id": id
+func (p *Parser) parseHashPattern() ast.Pattern {
+	pattern := &ast.HashPattern{Token: p.curToken}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+
+		var key ast.Expression
+		var value ast.Pattern
+
+		switch {
+		case p.curTokenIs(token.IDENT) && !p.peekTokenIs(token.COLON):
+			// the key keeps the IDENT token, like obj?.field
+			key = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.RawString}
+			value = &ast.Identifier{Token: p.curToken, Name: p.curToken.RawString}
+
+		case p.curTokenIs(token.STRING) || p.curTokenIs(token.INT) ||
+			p.curTokenIs(token.TRUE) || p.curTokenIs(token.FALSE):
+			key = p.prefixParseFns[p.curToken.Type]()
+			if key == nil || !p.expectPeek(token.COLON) {
+				return nil
+			}
+
+			p.nextToken()
+			value = p.parsePattern()
+			if value == nil {
+				return nil
+			}
+
+		default:
+			msg := fmt.Sprintf("unexpected %s as key of hash pattern", p.curToken.Type)
+			p.addError(p.curToken, msg)
+			return nil
+		}
+
+		pattern.Keys = append(pattern.Keys, key)
+		pattern.Values = append(pattern.Values, value)
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+
+	return pattern
+}
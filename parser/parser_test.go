@@ -439,6 +439,8 @@ func TestParsingInfixExpressions(t *testing.T) {
 		{"5 < 5; ", 5, "<", 5},
 		{"5 == 5; ", 5, "==", 5},
 		{"5 != 5; ", 5, "!=", 5},
+		{"5 <= 5; ", 5, "<=", 5},
+		{"5 >= 5; ", 5, ">=", 5},
 		{"true != false; ", true, "!=", false},
 	}
 
@@ -492,6 +494,33 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 		{"a + b * c + d / e -f", "(((a+(b*c))+(d/e))-f)"},
 		{"true", "true"},
 		{" 3 > 5 == false", "((3>5)==false)"},
+		{"a <= b == b >= a", "((a<=b)==(b>=a))"},
+		{"a || b && c", "(a||(b&&c))"},
+		{"a % b * c", "((a%b)*c)"},
+		{"a ** b ** c", "(a**(b**c))"},
+		{"-a ** b", "(-(a**b))"},
+		{"a * b ** c", "(a*(b**c))"},
+		{"a | b ^ c & d", "(a|(b^(c&d)))"},
+		{"a & b == c", "((a&b)==c)"},
+		{"a + b << c - d", "((a+b)<<(c-d))"},
+		{"~a >> b", "((~a)>>b)"},
+		{"a ? b : c", "(a?b:c)"},
+		{"a || b ? c + 1 : d", "((a||b)?(c+1):d)"},
+		{"a ? b : c ? d : e", "(a?b:(c?d:e))"},
+		{"a ? b ? c : d : e", "(a?(b?c:d):e)"},
+		{"a ?? b || c", "(a??(b||c))"},
+		{"a ?? b ? c : d", "((a??b)?c:d)"},
+		{"a?.b", "(a?.[b])"},
+		{"a?.[b + 1][c]", "((a?.[(b+1)])[c])"},
+		{"f(a)?.b", "(f(a)?.[b])"},
+		{"a[1:b + 1]", "(a[1:(b+1)])"},
+		{"a[:-1]", "(a[:(-1)])"},
+		{"a[i:][0]", "((a[i:])[0])"},
+		{"a[:]", "(a[:])"},
+		{"a?.[1:]", "(a?.[1:])"},
+		{"a[c ? 1 : 2]", "(a[(c?1:2)])"},
+		{"a && b || c", "((a&&b)||c)"},
+		{"a == b && !c || d < e", "(((a==b)&&(!c))||(d<e))"},
 		{"1 * (2 + 3) * 4", "((1*(2+3))*4)"},
 		{"!(true == false);", "(!(true==false))"},
 		{"a + add(b *c) +d", "((a+add((b*c)))+d)"},
@@ -935,3 +964,349 @@ func TestParsingHash2(t *testing.T) {
 		testFunc(value)
 	}
 }
+
+func TestOptionalChainErrors(t *testing.T) {
+	l := lexer.New("a?.1")
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) == 0 || errors[0] != "expect [ or field name after ?., got INT instead" {
+		t.Errorf("wrong parser errors. got=%q", errors)
+	}
+}
+
+func TestParsingMatchExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`match (x) { 1 => "one", -1 => "minus one", _ => "other" }`,
+			`matchx {1 => one, (-1) => minus one, _ => other}`,
+		},
+		{
+			`match (x) { [a, ...rest] if a > 0 => rest, [] => 0, }`,
+			`matchx {[a, ...rest] if (a>0) => rest, [] => 0}`,
+		},
+		{
+			`match (p) { {"x": 0, y} => y, {"name": [n]} => n }`,
+			`matchp {{x:0, y:y} => y, {name:[n]} => n}`,
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+		}
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		match, ok := stmt.Expr.(*ast.MatchExpression)
+		if !ok {
+			t.Fatalf("stmt.Expr is not ast.MatchExpression. got=%T", stmt.Expr)
+		}
+
+		if match.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, match.String())
+		}
+	}
+}
+
+func TestMatchPatternErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`match (x) { a + 1 => 1 }`, "expect next token to be =>. got + instead"},
+		{`match (x) { [...rest, a] => 1 }`, "expect next token to be ]. got , instead"},
+		{`match (x) { fn => 1 }`, "unexpected FUNCTION in pattern"},
+		{`match (x) { {name: a} => a }`, "unexpected IDENT as key of hash pattern"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0] != tt.expected {
+			t.Errorf("input %q: wrong parser errors. got=%q", tt.input, errors)
+		}
+	}
+}
+
+func TestParsingDestructuring(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let [a, b, ...rest] = arr;`, `let [a, b, ...rest] = arr;`},
+		{`let {name, "age": age} = person;`, `let {name:name, age:age} = person;`},
+		{`let [[x, y], {z}] = p;`, `let [[x, y], {z:z}] = p;`},
+		{`fn([x, y], {name}, z) { x }`, `fn([x, y], {name:name}, z) x`},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{`let 1 = x;`, "expect next token to be IDENT. got INT instead"},
+		{`let [a, 1 + 2] = x;`, "expect next token to be ,. got + instead"},
+		{`fn(1) { 1 }`, "unexpected INT in parameter list"},
+		{`fn([a, ...b, c]) { 1 }`, "expect next token to be ]. got , instead"},
+	}
+
+	for _, tt := range errorTests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0] != tt.expected {
+			t.Errorf("input %q: wrong parser errors. got=%q", tt.input, errors)
+		}
+	}
+}
+
+func TestParsingTryAndThrow(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`throw "bad";`, `throw bad;`},
+		{`throw error("ValueError", x + 1)`, `throw error(ValueError,(x+1));`},
+		{`try { f(1) } catch (e) { e["message"] }`, `try f(1) catch(e) (e[message])`},
+		{`try { f(1) } catch { 0 } finally { g() }`, `try f(1) catch 0 finally g()`},
+		{`try { f(1) } finally { g() }`, `try f(1) finally g()`},
+		{`let x = try { 1 } catch (e) { 2 };`, `let x = try 1 catch(e) 2;`},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{`try { 1 }`, "expect catch or finally after try block, got EOF instead"},
+		{`try { 1 } catch (1) { 2 }`, "expect next token to be IDENT. got INT instead"},
+		{`try 1 catch { 2 }`, "expect next token to be {. got INT instead"},
+	}
+
+	for _, tt := range errorTests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0] != tt.expected {
+			t.Errorf("input %q: wrong parser errors. got=%q", tt.input, errors)
+		}
+	}
+}
+
+func TestParsingNamedFunction(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedName string
+		expected     string
+	}{
+		{`fn add(a, b) { a + b }`, "add", `fn add(a, b) (a+b)`},
+		{`fn(a) { a }`, "", `fn(a) a`},
+		{`let f = fn fact(n) { n }`, "fact", `let f = fn fact(n) n;`},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+
+		var expr ast.Expression
+		switch stmt := program.Statements[0].(type) {
+		case *ast.ExpressionStatement:
+			expr = stmt.Expr
+		case *ast.LetStatement:
+			expr = stmt.Expr
+		}
+
+		fn, ok := expr.(*ast.FunctionLiteral)
+		if !ok {
+			t.Fatalf("not ast.FunctionLiteral. got=%T", expr)
+		}
+
+		name := ""
+		if fn.Name != nil {
+			name = fn.Name.Name
+		}
+		if name != tt.expectedName {
+			t.Errorf("name wrong. expected=%q, got=%q", tt.expectedName, name)
+		}
+	}
+}
+
+func TestParsingTypeAnnotations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let x: int = 1`, `let x: int = 1;`},
+		{`let [a, b]: [string] = c`, `let [a, b]: [string] = c;`},
+		{`let h: {string: [bool]} = {}`, `let h: {string: [bool]} = {};`},
+		{`let f: fn(int, any): null = g`, `let f: fn(int, any): null = g;`},
+		{`let f: fn() = g`, `let f: fn() = g;`},
+		{`fn(a: int, b) { a }`, `fn(a: int, b) a`},
+		{`fn add(a, b): int { a + b }`, `fn add(a, b): int (a+b)`},
+		{`fn(f: fn(int): int): fn(): int { f }`, `fn(f: fn(int): int): fn(): int f`},
+		{`x ? fn(a) { a } : y`, `(x?fn(a) a:y)`},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+
+	// without annotations ParamTypes stays nil, so old code gives the same ast
+	p := New(lexer.New(`fn(a, b) { a }`))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	fn := program.Statements[0].(*ast.ExpressionStatement).Expr.(*ast.FunctionLiteral)
+	if fn.ParamTypes != nil || fn.ReturnType != nil {
+		t.Errorf("expect no types, got %v %v", fn.ParamTypes, fn.ReturnType)
+	}
+}
+
+func TestTypeAnnotationErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let x: integer = 1`, "unknown type integer"},
+		{`let x: 1 = 1`, "expect a type, got INT instead"},
+		{`fn(a: [int) { a }`, "expect next token to be ]. got ) instead"},
+		{`let h: {string} = {}`, "expect next token to be :. got } instead"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		if len(p.Errors()) == 0 || p.Errors()[0] != tt.expected {
+			t.Errorf("%q: expected error %q, got %v", tt.input, tt.expected, p.Errors())
+		}
+	}
+}
+
+func TestMacroLiteralParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		err      string
+	}{
+		{`macro(x, y) { x + y; }`, `macro(x, y) (x+y)`, ""},
+		{`let unless = macro() { quote(1) }`, `let unless = macro() quote(1);`, ""},
+		{`macro([a], b) { a }`, "", "macro params must be names, got [a]"},
+		{`macro(a: int) { a }`, "", "macro params can not have types"},
+		{`macro { a }`, "", "expect next token to be (. got { instead"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+
+		if tt.err != "" {
+			if len(p.Errors()) == 0 || p.Errors()[0] != tt.err {
+				t.Errorf("%q: expected error %q, got %v", tt.input, tt.err, p.Errors())
+			}
+			continue
+		}
+
+		checkParserErrors(t, p)
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+
+	p := New(lexer.New(`macro(x, y) { x + y; }`))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	macro, ok := program.Statements[0].(*ast.ExpressionStatement).Expr.(*ast.MacroLiteral)
+	if !ok {
+		t.Fatalf("expect *ast.MacroLiteral, got %T", program.Statements[0].(*ast.ExpressionStatement).Expr)
+	}
+	if len(macro.Parameters) != 2 || macro.Parameters[0].Name != "x" || macro.Parameters[1].Name != "y" {
+		t.Errorf("wrong params %v", macro.Parameters)
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	input := `let add = fn(a: int, b): int { a + b }; add(1, 2) * -add(3, 4);
+fn fib(n) { if (n < 2) { return n; } else { fib(n - 1) + fib(n - 2) } }
+let h: {string: [any]} = {"a": [1, true], "b": []}; h["a"][0] ?? h?.c?.[1:];
+let [x, [y, ...z], {w, "v": u}] = q;
+let r = try { throw error("K", "m"); } catch (e) { e["kind"] } finally { puts("done") };
+match (v) { 0 => "zero", [p, ...ps] if p > 0 => ps, {"k": [k]} => k, _ => a ? b : c }
+let g: fn(int): [string] = fn() {};
+let m = macro(a, b) { quote(unquote(b) - unquote(a)) };`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	data, err := ast.EncodeJSON(program)
+	if err != nil {
+		t.Fatalf("encode: %s", err)
+	}
+
+	node, err := ast.DecodeJSON(data)
+	if err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+
+	if node.String() != program.String() {
+		t.Errorf("program changed.\nexpected=%q\ngot=     %q", program.String(), node.String())
+	}
+
+	again, err := ast.EncodeJSON(node)
+	if err != nil {
+		t.Fatalf("encode again: %s", err)
+	}
+	if string(again) != string(data) {
+		t.Errorf("json changed after a round trip")
+	}
+}
@@ -14,17 +14,23 @@ import (
 const PROMPT = ">> "
 
 func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
-	env := object.NewEnvironment()
+	reader := bufio.NewReader(in)
+
+	// puts writes next to the results, and readline/input take the lines after the one being evaluated,
+	// so both share the reader with the prompt instead of buffering stdin on their own.
+	ctx := &evaluator.Context{Output: out, Input: reader}
+
+	env := evaluator.WithContext(object.NewEnvironment(), ctx)
+	// a macro defined on one line is expanded on the next ones
+	macroEnv := evaluator.WithContext(object.NewEnvironment(), ctx)
 
 	for {
 		fmt.Printf(PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
 			return
 		}
 
-		line := scanner.Text()
 		l := lexer.New(line)
 		p := parser.New(l)
 
@@ -34,7 +40,22 @@ func Start(in io.Reader, out io.Writer) {
 			continue
 		}
 
+		evaluator.DefineMacros(program, macroEnv)
+		if _, errObj := evaluator.ExpandMacros(program, macroEnv); errObj != nil {
+			io.WriteString(out, errObj.Traceback())
+			io.WriteString(out, "\n")
+			continue
+		}
+
+		// the globals of the lines before are in env by name, only the names inside the line get slots
+		evaluator.Resolve(program, ctx.Scoping)
+
 		evaluated := evaluator.Eval(program, env)
+		if errObj, ok := evaluated.(*object.Error); ok {
+			io.WriteString(out, errObj.Traceback())
+			io.WriteString(out, "\n")
+			continue
+		}
 		if evaluated != nil {
 			io.WriteString(out, evaluated.Inspect())
 			io.WriteString(out, "\n")
@@ -9,15 +9,22 @@ type TokenType string
 // Token stream is the lexer output, and will feed to parser to build the AST
 // Type and Literal(RawString) are all string.
 // input = 125, and after lexer the token is string "125", after parser will cast to int 125
+// Line and Column are where the token starts, both count from 1, Column counts bytes.
 type Token struct {
 	Type      TokenType
 	RawString string
+
+	Line   int
+	Column int
 }
 
 const (
 	ILLEGAL = "ILLEGA"
 	EOF     = "EOF"
 
+	// a // comment, only kept by the lexer for the formatter, never returned by NextToken
+	COMMENT = "COMMENT"
+
 	IDENT = "IDENT"
 	INT   = "INT"
 
@@ -28,13 +35,33 @@ const (
 	BANG     = "!"
 	ASTERISK = "*"
 	SLASH    = "/"
-
-	LT = "<"
-	GT = ">"
+	PERCENT  = "%"
+	POWER    = "**"
+
+	// bitwise
+	BIT_AND     = "&"
+	BIT_OR      = "|"
+	BIT_XOR     = "^"
+	TILDE       = "~"
+	SHIFT_LEFT  = "<<"
+	SHIFT_RIGHT = ">>"
+
+	LT    = "<"
+	GT    = ">"
+	LT_EQ = "<="
+	GT_EQ = ">="
 
 	EQ     = "=="
 	NOT_EQ = "!="
 
+	AND = "&&"
+	OR  = "||"
+
+	// c ? a : b, a ?? b, h?.["k"] and obj?.field
+	QUESTION       = "?"
+	NULLISH        = "??"
+	OPTIONAL_CHAIN = "?."
+
 	// Delimiters
 	COMMA     = ","
 	SEMICOLON = ";"
@@ -46,6 +73,10 @@ const (
 
 	COLON = ":"
 
+	// match arms: pattern => expr, rest of an array pattern: [first, ...rest]
+	ARROW    = "=>"
+	ELLIPSIS = "..."
+
 	STRING = "STRING"
 
 	LBRACKET = "["
@@ -59,18 +90,30 @@ const (
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	MATCH    = "MATCH"
+	THROW    = "THROW"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	FINALLY  = "FINALLY"
+	MACRO    = "MACRO"
 )
 
 // keywords mean something predefined(a subset of identifier),
 // true/false is also keywords
 var keywords = map[string]TokenType{
-	"true":   TRUE,
-	"false":  FALSE,
-	"let":    LET,
-	"return": RETURN,
-	"fn":     FUNCTION,
-	"if":     IF,
-	"else":   ELSE,
+	"true":    TRUE,
+	"false":   FALSE,
+	"let":     LET,
+	"return":  RETURN,
+	"fn":      FUNCTION,
+	"if":      IF,
+	"else":    ELSE,
+	"match":   MATCH,
+	"throw":   THROW,
+	"try":     TRY,
+	"catch":   CATCH,
+	"finally": FINALLY,
+	"macro":   MACRO,
 }
 
 // LookupIdent first find in keyword list, if not exist, then it should be identifier
@@ -0,0 +1,497 @@
+package types
+
+import (
+	"fmt"
+
+	"xmonkey/analysis"
+	"xmonkey/ast"
+	"xmonkey/evaluator"
+	"xmonkey/token"
+)
+
+// Error is a type error, Token is where it is
+type Error struct {
+	Token   token.Token
+	Message string
+}
+
+func (e Error) String() string {
+	return fmt.Sprintf("%d:%d: %s", e.Token.Line, e.Token.Column, e.Message)
+}
+
+// Check infers the types of program, to be run with scoping, and reports the mismatches, in source order
+func Check(program *ast.Program, scoping evaluator.Scoping) []Error {
+	c := &checker{info: analysis.Resolve(program, scoping), types: map[*analysis.Symbol]Type{}}
+	c.statements(program.Statements)
+	return c.errors
+}
+
+type checker struct {
+	info *analysis.Info
+	// the type of every binding seen so far, the others are any
+	types map[*analysis.Symbol]Type
+	// the functions being checked, innermost last
+	funcs  []*funcContext
+	errors []Error
+}
+
+type funcContext struct {
+	// the annotated return type, nil when it is inferred
+	declared Type
+	// the types of the return statements, for the inference
+	returns Type
+}
+
+func (c *checker) errorf(tok token.Token, format string, a ...interface{}) {
+	c.errors = append(c.errors, Error{Token: tok, Message: fmt.Sprintf(format, a...)})
+}
+
+func (c *checker) bind(ident *ast.Identifier, typ Type) {
+	if sym := c.info.Defs[ident]; sym != nil {
+		c.types[sym] = typ
+	}
+}
+
+// bindPattern gives the names of a pattern the types of the parts of typ they take
+func (c *checker) bindPattern(pattern ast.Pattern, typ Type) {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		c.bind(pattern, typ)
+
+	case *ast.LiteralPattern:
+		c.expr(pattern.Value)
+
+	case *ast.ArrayPattern:
+		elem := Type(Any)
+		if arr, ok := typ.(*Array); ok {
+			elem = arr.Elem
+		}
+		for _, el := range pattern.Elements {
+			c.bindPattern(el, elem)
+		}
+		if pattern.Rest != nil {
+			c.bind(pattern.Rest, &Array{Elem: elem})
+		}
+
+	case *ast.HashPattern:
+		value := Type(Any)
+		if hash, ok := typ.(*Hash); ok {
+			value = hash.Value
+		}
+		for i, key := range pattern.Keys {
+			c.expr(key)
+			c.bindPattern(pattern.Values[i], value)
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// statements
+
+// statements checks a program or block, the result is the type of its value,
+// nil when it always leaves by return or throw
+func (c *checker) statements(stmts []ast.Statement) Type {
+	// hoisted functions can be called before the declaration, with the annotated signature
+	for _, stmt := range stmts {
+		if exprStmt, ok := stmt.(*ast.ExpressionStatement); ok {
+			if fn, ok := exprStmt.Expr.(*ast.FunctionLiteral); ok && fn.Name != nil {
+				c.bind(fn.Name, signature(fn))
+			}
+		}
+	}
+
+	var value Type = Null
+	for _, stmt := range stmts {
+		value = c.statement(stmt)
+		if value == nil {
+			return nil
+		}
+	}
+
+	return value
+}
+
+func (c *checker) statement(stmt ast.Statement) Type {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		typ := c.value(stmt.Expr)
+		if stmt.Type != nil {
+			declared := FromExpr(stmt.Type)
+			if !Assignable(typ, declared) {
+				c.errorf(stmt.Token, "cannot use %s as %s in let %s", typ, declared, letName(stmt))
+			}
+			typ = declared
+		}
+
+		if stmt.Pattern != nil {
+			c.bindPattern(stmt.Pattern, typ)
+		} else {
+			c.bind(stmt.Name, typ)
+		}
+		return Null
+
+	case *ast.ReturnStatement:
+		typ := c.value(stmt.Expr)
+		if len(c.funcs) == 0 {
+			return nil
+		}
+
+		fn := c.funcs[len(c.funcs)-1]
+		if fn.declared != nil && !Assignable(typ, fn.declared) {
+			c.errorf(stmt.Token, "cannot return %s as %s", typ, fn.declared)
+		}
+		fn.returns = join(fn.returns, typ)
+		return nil
+
+	case *ast.ThrowStatement:
+		c.expr(stmt.Expr)
+		return nil
+
+	case *ast.ExpressionStatement:
+		return c.expr(stmt.Expr)
+
+	case *ast.BlockStatement:
+		return c.block(stmt)
+	}
+
+	return Null
+}
+
+func (c *checker) block(block *ast.BlockStatement) Type {
+	if block == nil {
+		return Null
+	}
+	return c.statements(block.Statements)
+}
+
+func letName(stmt *ast.LetStatement) string {
+	if stmt.Pattern != nil {
+		return stmt.Pattern.String()
+	}
+	return stmt.Name.Name
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// expressions
+
+// expr infers the type of expr, nil when it never has a value (an if whose branches all return)
+func (c *checker) expr(expr ast.Expression) Type {
+	switch expr := expr.(type) {
+	case *ast.IntegerLiteral:
+		return Int
+	case *ast.StringLiteral:
+		return String
+	case *ast.Boolean:
+		return Bool
+
+	case *ast.Identifier:
+		return c.identifier(expr)
+
+	case *ast.ArrayLiteral:
+		var elem Type
+		for _, el := range expr.Elements {
+			elem = join(elem, c.value(el))
+		}
+		if elem == nil {
+			elem = Any
+		}
+		return &Array{Elem: elem}
+
+	case *ast.HashLiteral:
+		var key, value Type
+		for _, k := range expr.Keys {
+			key = join(key, c.value(k))
+			value = join(value, c.value(expr.Pairs[k]))
+		}
+		if key == nil {
+			key, value = Any, Any
+		}
+		return &Hash{Key: key, Value: value}
+
+	case *ast.PrefixExpression:
+		return c.prefix(expr)
+
+	case *ast.InfixExpression:
+		return c.infix(expr)
+
+	case *ast.ConditionalExpression:
+		c.expr(expr.Condition)
+		return join(c.expr(expr.Consequence), c.expr(expr.Alternative))
+
+	case *ast.IfExpression:
+		c.expr(expr.Condition)
+		consequence := c.block(expr.Consequence)
+		if expr.Alternative == nil {
+			return join(consequence, Null)
+		}
+		alternative := c.block(expr.Alternative)
+		if consequence == nil && alternative == nil {
+			return nil
+		}
+		return join(consequence, alternative)
+
+	case *ast.CallExpression:
+		return c.call(expr)
+
+	case *ast.IndexExpression:
+		return c.index(expr)
+
+	case *ast.SliceExpression:
+		left := c.value(expr.Left)
+		for _, bound := range []ast.Expression{expr.Start, expr.End} {
+			if bound == nil {
+				continue
+			}
+			if typ := c.value(bound); !Assignable(typ, Int) {
+				c.errorf(expr.Token, "slice index must be int, got %s", typ)
+			}
+		}
+		if _, ok := left.(*Array); ok || left == String {
+			return left
+		}
+		if left != Any && left != Null {
+			c.errorf(expr.Token, "cannot slice %s", left)
+		}
+		return Any
+
+	case *ast.FunctionLiteral:
+		return c.function(expr)
+
+	case *ast.TryExpression:
+		typ := c.block(expr.Block)
+		if expr.Catch != nil {
+			if expr.CatchParam != nil {
+				// see errorHash: kind, message, value and trace
+				c.bind(expr.CatchParam, &Hash{Key: String, Value: Any})
+			}
+			typ = join(typ, c.block(expr.Catch))
+		}
+		c.block(expr.Finally)
+		return typ
+
+	case *ast.MatchExpression:
+		subject := c.value(expr.Subject)
+
+		var typ Type
+		for _, arm := range expr.Arms {
+			c.bindPattern(arm.Pattern, subject)
+			if arm.Guard != nil {
+				c.expr(arm.Guard)
+			}
+			typ = join(typ, c.value(arm.Body))
+		}
+		if typ == nil {
+			return Any
+		}
+		return typ
+	}
+
+	return Any
+}
+
+// value is expr as an operand, which has a value, if it returns the operation never happens
+func (c *checker) value(expr ast.Expression) Type {
+	if typ := c.expr(expr); typ != nil {
+		return typ
+	}
+	return Any
+}
+
+func (c *checker) identifier(ident *ast.Identifier) Type {
+	sym := c.info.Uses[ident]
+	if sym == nil {
+		return Any
+	}
+
+	if sym.Kind == analysis.BUILTIN {
+		if typ, ok := builtinTypes[sym.Name]; ok {
+			return typ
+		}
+		return Any
+	}
+
+	if typ, ok := c.types[sym]; ok {
+		return typ
+	}
+	// declared later (used in a fn body), or in code not checked yet
+	return Any
+}
+
+// builtinTypes are the builtins with a fixed signature, the others take and return any
+var builtinTypes = map[string]Type{
+	"len": &Func{Params: []Type{Any}, Return: Int},
+}
+
+func (c *checker) prefix(expr *ast.PrefixExpression) Type {
+	right := c.value(expr.Right)
+
+	switch expr.Operator {
+	case "!":
+		return Bool
+	case "-", "~":
+		if !Assignable(right, Int) {
+			c.errorf(expr.Token, "invalid operation: %s%s", expr.Operator, right)
+		}
+		return Int
+	}
+	return Any
+}
+
+func (c *checker) infix(expr *ast.InfixExpression) Type {
+	left, right := c.value(expr.Left), c.value(expr.Right)
+
+	switch expr.Operator {
+	case "==", "!=":
+		return Bool
+
+	case "&&", "||", "??":
+		return join(left, right)
+
+	case "+":
+		switch {
+		case left == Int && right == Int:
+			return Int
+		case left == String && right == String:
+			return String
+		case left == Any && (right == Int || right == String):
+			return right
+		case right == Any && (left == Int || left == String):
+			return left
+		case left == Any || right == Any:
+			return Any
+		}
+		c.errorf(expr.Token, "invalid operation: %s + %s", left, right)
+		return Any
+
+	case "<", ">", "<=", ">=":
+		comparable := func(t Type) bool { return t == Int || t == String || t == Any }
+		if !comparable(left) || !comparable(right) || !Assignable(left, right) {
+			c.errorf(expr.Token, "invalid operation: %s %s %s", left, expr.Operator, right)
+		}
+		return Bool
+	}
+
+	// - * / % ** & | ^ << >> are for ints only
+	if !Assignable(left, Int) || !Assignable(right, Int) {
+		c.errorf(expr.Token, "invalid operation: %s %s %s", left, expr.Operator, right)
+	}
+	return Int
+}
+
+func (c *checker) call(call *ast.CallExpression) Type {
+	callee := c.value(call.CallableName)
+
+	// quote(expr) does not run expr, it is only code
+	if ident, ok := call.CallableName.(*ast.Identifier); ok {
+		if sym := c.info.Uses[ident]; sym != nil && sym.Kind == analysis.BUILTIN && sym.Name == "quote" {
+			return Any
+		}
+	}
+
+	args := []Type{}
+	for _, arg := range call.ActualParams {
+		args = append(args, c.value(arg))
+	}
+
+	fn, ok := callee.(*Func)
+	if !ok {
+		if callee != Any {
+			c.errorf(call.Token, "cannot call %s", callee)
+		}
+		return Any
+	}
+
+	if len(args) != len(fn.Params) {
+		c.errorf(call.Token, "wrong number of arguments to %s: got %d, want %d", call.CallableName, len(args), len(fn.Params))
+		return fn.Return
+	}
+
+	for i, arg := range args {
+		if !Assignable(arg, fn.Params[i]) {
+			c.errorf(call.Token, "cannot use %s as %s in argument %d to %s", arg, fn.Params[i], i+1, call.CallableName)
+		}
+	}
+
+	return fn.Return
+}
+
+func (c *checker) index(expr *ast.IndexExpression) Type {
+	left, index := c.value(expr.Left), c.value(expr.Index)
+
+	switch left := left.(type) {
+	case *Array:
+		if !Assignable(index, Int) {
+			c.errorf(expr.Token, "array index must be int, got %s", index)
+		}
+		return left.Elem
+
+	case *Hash:
+		// a key of another type is not there, the runtime gives null for it as for any missing key
+		if !Assignable(index, left.Key) {
+			return Any
+		}
+		return left.Value
+	}
+
+	switch left {
+	case String:
+		if !Assignable(index, Int) {
+			c.errorf(expr.Token, "string index must be int, got %s", index)
+		}
+		return String
+	case Any, Null:
+		// null?.[k] is null, and null[k] is left to the runtime
+		return Any
+	}
+
+	c.errorf(expr.Token, "cannot index %s", left)
+	return Any
+}
+
+// signature is the type of fn from its annotations alone
+func signature(fn *ast.FunctionLiteral) *Func {
+	sig := &Func{Return: FromExpr(fn.ReturnType)}
+	for i := range fn.FormalParams {
+		var annotation ast.TypeExpr
+		if fn.ParamTypes != nil {
+			annotation = fn.ParamTypes[i]
+		}
+		sig.Params = append(sig.Params, FromExpr(annotation))
+	}
+	return sig
+}
+
+// function checks the body of fn, a return type which is not annotated is inferred from it
+func (c *checker) function(fn *ast.FunctionLiteral) Type {
+	sig := signature(fn)
+	for i, param := range fn.FormalParams {
+		c.bindPattern(param, sig.Params[i])
+	}
+	// sig is shared with the binding, so the inferred return type below reaches the name too
+	if fn.Name != nil {
+		c.bind(fn.Name, sig)
+	}
+
+	ctx := &funcContext{}
+	if fn.ReturnType != nil {
+		ctx.declared = sig.Return
+	}
+
+	c.funcs = append(c.funcs, ctx)
+	value := c.block(fn.Body)
+	c.funcs = c.funcs[:len(c.funcs)-1]
+
+	// the value of the last statement is returned too
+	if value != nil && ctx.declared != nil && !Assignable(value, ctx.declared) {
+		c.errorf(fn.Body.Rbrace, "cannot return %s as %s", value, ctx.declared)
+	}
+
+	if ctx.declared == nil {
+		sig.Return = join(ctx.returns, value)
+		if sig.Return == nil {
+			sig.Return = Any
+		}
+	}
+
+	return sig
+}
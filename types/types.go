@@ -0,0 +1,145 @@
+// Package types is a gradual type checker: annotated code is checked, the rest is `any`,
+// and `any` goes with every type, so untyped scripts pass as they are.
+// It runs before the evaluator and only reports what would surely fail at runtime.
+package types
+
+import (
+	"strings"
+
+	"xmonkey/ast"
+)
+
+// Type is int, string, bool, null, any, [elem], {key: value} or fn(params): return
+type Type interface {
+	String() string
+}
+
+// Basic are the types without parts
+type Basic string
+
+const (
+	Int    Basic = "int"
+	String Basic = "string"
+	Bool   Basic = "bool"
+	Null   Basic = "null"
+	// Any is what is not known, it is assignable to and from every type
+	Any Basic = "any"
+)
+
+func (b Basic) String() string { return string(b) }
+
+type Array struct {
+	Elem Type
+}
+
+func (a *Array) String() string { return "[" + a.Elem.String() + "]" }
+
+type Hash struct {
+	Key   Type
+	Value Type
+}
+
+func (h *Hash) String() string { return "{" + h.Key.String() + ": " + h.Value.String() + "}" }
+
+type Func struct {
+	Params []Type
+	Return Type
+}
+
+func (f *Func) String() string {
+	params := []string{}
+	for _, p := range f.Params {
+		params = append(params, p.String())
+	}
+	return "fn(" + strings.Join(params, ", ") + "): " + f.Return.String()
+}
+
+// FromExpr is the type an annotation stands for, Any for nil (no annotation)
+func FromExpr(expr ast.TypeExpr) Type {
+	switch expr := expr.(type) {
+	case *ast.NamedType:
+		return Basic(expr.Name)
+
+	case *ast.ArrayType:
+		return &Array{Elem: FromExpr(expr.Elem)}
+
+	case *ast.HashType:
+		return &Hash{Key: FromExpr(expr.Key), Value: FromExpr(expr.Value)}
+
+	case *ast.FunctionType:
+		fn := &Func{Return: FromExpr(expr.Return)}
+		for _, param := range expr.Params {
+			fn.Params = append(fn.Params, FromExpr(param))
+		}
+		return fn
+	}
+
+	return Any
+}
+
+// Identical compares the structure, the String of a type spells out all of it
+func Identical(a, b Type) bool {
+	return a.String() == b.String()
+}
+
+// Assignable tells if a value of type from can be used where to is expected
+func Assignable(from, to Type) bool {
+	if from == Any || to == Any {
+		return true
+	}
+
+	switch to := to.(type) {
+	case Basic:
+		return from == to
+
+	case *Array:
+		from, ok := from.(*Array)
+		return ok && Assignable(from.Elem, to.Elem)
+
+	case *Hash:
+		from, ok := from.(*Hash)
+		return ok && Assignable(from.Key, to.Key) && Assignable(from.Value, to.Value)
+
+	case *Func:
+		from, ok := from.(*Func)
+		if !ok || len(from.Params) != len(to.Params) {
+			return false
+		}
+		// the caller passes what to takes, the function gets it as its own params
+		for i := range to.Params {
+			if !Assignable(to.Params[i], from.Params[i]) {
+				return false
+			}
+		}
+		return Assignable(from.Return, to.Return)
+	}
+
+	return false
+}
+
+// join is the type of a value which is either a or b,
+// nil stands for no value at all (a branch which returns or throws)
+func join(a, b Type) Type {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if Identical(a, b) {
+		return a
+	}
+
+	if a, ok := a.(*Array); ok {
+		if b, ok := b.(*Array); ok {
+			return &Array{Elem: join(a.Elem, b.Elem)}
+		}
+	}
+	if a, ok := a.(*Hash); ok {
+		if b, ok := b.(*Hash); ok {
+			return &Hash{Key: join(a.Key, b.Key), Value: join(a.Value, b.Value)}
+		}
+	}
+
+	return Any
+}
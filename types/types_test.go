@@ -0,0 +1,110 @@
+package types
+
+import (
+	"strings"
+	"testing"
+
+	"xmonkey/evaluator"
+	"xmonkey/lexer"
+	"xmonkey/parser"
+)
+
+func check(t *testing.T, input string) []string {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("%q: parser errors: %v", input, p.Errors())
+	}
+
+	var errors []string
+	for _, err := range Check(program, evaluator.Scoping{}) {
+		errors = append(errors, err.String())
+	}
+	return errors
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		// untyped code passes as it is
+		{"let add = fn(a, b) { a + b }; add(1, 2); add(\"a\", \"b\")", nil},
+		{"let x = f(1); x + 1; x[0]; x()", nil},
+
+		{"let x: int = 1", nil},
+		{"let x: int = \"a\"", []string{"1:1: cannot use string as int in let x"}},
+		{"let a: [int] = [1, 2]; let b: [int] = []; let c: [string] = [1]", []string{"1:43: cannot use [int] as [string] in let c"}},
+		{"let h: {string: int} = {\"a\": 1}; let g: {string: int} = {\"a\": true}", []string{"1:34: cannot use {string: bool} as {string: int} in let g"}},
+		{"let m = [1, \"a\"]; let n: [int] = m", nil},
+		{"let s: string = 1 + 2", []string{"1:1: cannot use int as string in let s"}},
+
+		// operators
+		{"1 + \"a\"", []string{"1:3: invalid operation: int + string"}},
+		{"\"a\" - \"b\"", []string{"1:5: invalid operation: string - string"}},
+		{"-true", []string{"1:1: invalid operation: -bool"}},
+		{"1 < \"a\"", []string{"1:3: invalid operation: int < string"}},
+		{"let b: bool = 1 < 2 && \"a\" == \"b\"", nil},
+
+		// functions
+		{"let f = fn(a: int): string { \"x\" }; f(1)", nil},
+		{"let f = fn(a: int): string { a }", []string{"1:32: cannot return int as string"}},
+		{"let f = fn(a: int): string { if (a > 0) { return a; } \"x\" }", []string{"1:43: cannot return int as string"}},
+		{"let f = fn(a: int, b: string) { b }; f(\"x\", \"y\")", []string{"1:39: cannot use string as int in argument 1 to f"}},
+		{"let f = fn(a: int) { a }; f(1, 2)", []string{"1:28: wrong number of arguments to f: got 2, want 1"}},
+		{"let f = fn() { \"s\" }; let n: int = f()", []string{"1:23: cannot use string as int in let n"}},
+		{"fn twice(f: fn(int): int, x: int): int { f(f(x)) }; twice(fn(x: int): int { x * 2 }, 1)", nil},
+		{"fn twice(f: fn(int): int, x: int): int { f(f(x)) }; twice(fn(x: string) { x }, 1)", []string{"1:58: cannot use fn(string): string as fn(int): int in argument 1 to twice"}},
+		{"let n: int = g(); fn g(): string { \"a\" }", []string{"1:1: cannot use string as int in let n"}},
+		{"fn fact(n: int): int { if (n < 2) { return 1; } n * fact(n - 1) }; fact(5)", nil},
+		{"let x = 1; x()", []string{"1:13: cannot call int"}},
+		{"let n: int = len(\"abc\"); len(1, 2)", []string{"1:29: wrong number of arguments to len: got 2, want 1"}},
+
+		// indexes and patterns
+		{"let a = [1, 2]; let s: string = a[0]", []string{"1:17: cannot use int as string in let s"}},
+		{"let a = [1, 2]; a[\"x\"]", []string{"1:18: array index must be int, got string"}},
+		{"let h = {\"a\": 1}; h[1]", nil},
+		{"let h = {1: \"a\"}; h[\"1\"] ?? \"none\"", nil},
+		{"let h = {\"a\": 1}; let s: string = h[true] ?? \"none\"", nil},
+		{"let h = {\"a\": 1}; let s: string = h[\"a\"]", []string{"1:19: cannot use int as string in let s"}},
+		{"let [x, ...rest]: [int] = [1, 2]; let s: string = x; let r: [int] = rest", []string{"1:35: cannot use int as string in let s"}},
+		{"match ([1]) { [x] => x + \"a\", _ => 0 }", []string{"1:24: invalid operation: int + string"}},
+		{"1[0]; true[1:]", []string{"1:2: cannot index int", "1:11: cannot slice bool"}},
+		{"try { 1 } catch (e) { e[\"message\"] + 1 }", nil},
+	}
+
+	for _, tt := range tests {
+		got := check(t, tt.input)
+		if strings.Join(got, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("%q: expect\n%s\ngot\n%s", tt.input, strings.Join(tt.expected, "\n"), strings.Join(got, "\n"))
+		}
+	}
+}
+
+func TestAssignable(t *testing.T) {
+	intToInt := &Func{Params: []Type{Int}, Return: Int}
+	anyToInt := &Func{Params: []Type{Any}, Return: Int}
+
+	tests := []struct {
+		from, to Type
+		expected bool
+	}{
+		{Int, Int, true},
+		{Int, String, false},
+		{Any, Int, true},
+		{Null, Int, false},
+		{&Array{Elem: Any}, &Array{Elem: Int}, true},
+		{&Array{Elem: Int}, &Hash{Key: Int, Value: Int}, false},
+		{anyToInt, intToInt, true},
+		{intToInt, &Func{Params: []Type{Int, Int}, Return: Int}, false},
+		{intToInt, &Func{Params: []Type{Int}, Return: String}, false},
+	}
+
+	for _, tt := range tests {
+		if got := Assignable(tt.from, tt.to); got != tt.expected {
+			t.Errorf("Assignable(%s, %s): expect %t, got %t", tt.from, tt.to, tt.expected, got)
+		}
+	}
+}